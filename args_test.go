@@ -0,0 +1,85 @@
+package ts_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+// Arguments given by name, and pairs given as arguments.
+func TestKeywordArgs(t *testing.T) {
+	const defs = `
+		def steps(to, from = 0, step = 1) = [from, to, step];
+		def opts(a, **kw) = [a, kw.size];
+		def first(p) = p.left;
+		class Point()
+			def x, y;
+			def create(x, y)
+				this.x = x;
+				this.y = y;
+			end;
+		end;
+	`
+	tests := []struct {
+		name, src, want string
+	}{
+		{"positional", "steps(10, 2);", "[2, 10, 1]"},
+		{"keyword", "steps(10, step = 2);", "[0, 10, 2]"},
+		{"keywords only", "steps(from = 5, to = 10);", "[5, 10, 1]"},
+		{"spaced", "steps(10, step = 3);", "[0, 10, 3]"},
+		{"collected", "opts(1, b = 2, c = 3);", "[1, 2]"},
+		{"apply", `steps.apply([10], {"step": 4});`, "[0, 10, 4]"},
+		{"pair", "first(1: 2);", "1"},
+		{"named pair", "def a = 1; first(a: 2);", "1"},
+		{"bracketed pair", "first((1: 2));", "1"},
+		{"comparison", "def a = 1; steps(a == 1);", "[0, true, 1]"},
+		{"pair value", "first(p = 1: 2);", "1"},
+		{"switchType class", `switchType(1, Number: fn() = "num");`, "num"},
+		{"switchType", "switchType(5, String: fn() = 1, Integer: fn() = 2);", "2"},
+		{"pattern", `
+			def r;
+			match Point(1, 2)
+			case Point(y = a) then
+				r = a;
+			end;
+			r;
+		`, "2"},
+	}
+	for _, test := range tests {
+		x, err := run(t, ts.New(), 20*time.Second, defs + test.src, ts.Limits{})
+		expect(t, test.name, x, err, test.want)
+	}
+}
+
+// Mistakes in naming arguments.
+func TestKeywordArgErrors(t *testing.T) {
+	const defs = `
+		def steps(to, from = 0, step = 1) = [from, to, step];
+		def f(x) = x;
+	`
+	tests := []struct {
+		name, src, want string
+	}{
+		{"unexpected", "steps(10, by = 2);", "unexpected keyword argument: by"},
+		{"no keywords", "f(x = 1, y = 2);", "unexpected keyword argument"},
+		{"twice", "steps(10, to = 2);", "argument given twice: to"},
+		{"missing", "steps(step = 2);", "missing argument: to"},
+		{"caught", `
+			def e = catch(fn() steps(10, by = 2); end);
+			throw(e.msg + " was caught");
+		`, "unexpected keyword argument: by was caught"},
+	}
+	for _, test := range tests {
+		_, err := run(t, ts.New(), 20*time.Second, defs + test.src, ts.Limits{})
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.want)
+		}
+	}
+	for _, src := range []string{"f(x = 1, x = 2);", "f(x = 1, 2);"} {
+		u := new(ts.Unit)
+		if err := u.CompileErr(strings.NewReader(src), "test"); err == nil {
+			t.Errorf("%s: compiled", src)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package ts
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	. "github.com/bobappleyard/ts/parse"
+)
+
+/*******************************************************************************
+
+	Syntax trees
+
+*******************************************************************************/
+
+// The kinds of node found in the trees that Parse() gives. The Token of a node
+// is where it was found in the source, and its Text is the name or operator the
+// node is about, if there is one. Children that may be left out are nil.
+//
+// Operators are method calls, so "a + b" is a CallNode whose children are a
+// LookNode for "__add__", holding a, and then b. Array and hash literals,
+// strings with "${...}" in them, packages and imports are also turned into
+// calls, definitions and functions by the parser.
+const (
+	// A group of other nodes, such as a block of statements or the arguments
+	// in a function definition. Where a name is expected, a group holds the
+	// name in its Text and, for an optional argument, its default value as its
+	// child. The group holding the arguments of a function has a FnArgs as
+	// its Data.
+	GroupNode = invalidNode
+	// A constant, whose value is its Data, an *Object.
+	ValNode = valNode
+	// An accessor, as in "@name".
+	AccNode = accNode
+	// A definition. Its children are VarNodes, FnNodes and PropNodes.
+	DefNode = defNode
+	// A variable, named by Text. In a DefNode it has a pattern or name and
+	// then the value, or nil, as its children instead, and a SlotVis as its
+	// Data, which matters in classes.
+	VarNode = varNode
+	// An assignment, of its second child to its first.
+	MutNode = mutNode
+	// A compound assignment, such as "x += 1". Text is the operator.
+	UpdNode = updNode
+	// A pattern that takes a value apart. Text is "[" for arrays, "{" for
+	// hashes and ":" for pairs, and the children are the parts.
+	PatNode = patNode
+	// The rest of an array in a pattern, as in "[a, b*]".
+	RestNode = restNode
+	// A conditional. The children are the condition and then groups holding
+	// the statements for each branch.
+	IfNode = ifNode
+	// "&&" or "||", as given by Text, on its two children.
+	LogNode = logNode
+	// A return statement, with the value returned as its child.
+	RetNode = retNode
+	// A loop, with the condition and a group holding the body as children.
+	WhileNode = whileNode
+	// A loop, with the name, the sequence and a group holding the body as
+	// children.
+	ForNode = forNode
+	BreakNode = breakNode
+	ContNode = contNode
+	// A try statement. The children are a group holding the body, a group
+	// holding CatchNodes and a group holding the finally clause, or nil.
+	TryNode = tryNode
+	// A catch clause, with the name, the class caught or nil and a group
+	// holding the body as children.
+	CatchNode = catchNode
+	// A match statement, with the value being matched and then CaseNodes as
+	// children.
+	MatchNode = matchNode
+	// A case in a match statement, with the pattern, the guard or nil and a
+	// group holding the body as children.
+	CaseNode = caseNode
+	// A yield expression, with the value yielded as its child.
+	YieldNode = yieldNode
+	// Indexing, as in "a[i]". The children are the array and the indices.
+	AlookNode = alookNode
+	// A function. Its children are a group holding the arguments and then the
+	// statements of the body. In a DefNode or a class, it has the name and
+	// then the function as its children instead, and a SlotVis as its Data.
+	FnNode = fnNode
+	// A call, with the function and then the arguments as its children.
+	CallNode = callNode
+	// A keyword argument in a call. Text is the keyword, and the child is the
+	// value.
+	KwNode = kwNode
+	// A class. The children are its name, the global it defines, its ancestor,
+	// a group holding the traits it mixes in and then the DefNodes of its
+	// members. The name, global and ancestor may be nil. Its Data is true for
+	// traits.
+	ClassNode = classNode
+	// A property in a DefNode, with the name and then a group holding the get
+	// and set functions, either of which may be nil, as its children. Its Data
+	// is a SlotVis.
+	PropNode = propNode
+	// Looking up a member, named by Text, of its child.
+	LookNode = lookNode
+	ThisNode = thisNode
+	// "super", which appears as the child of a LookNode.
+	SuperNode = superNode
+)
+
+// The name of a kind of node: "group" for GroupNode, and otherwise that of the
+// constant in lower case without "Node", e.g. "val" or "call". ContNode is
+// "continue".
+func NodeKindName(k int) string {
+	if k == GroupNode {
+		return "group"
+	}
+	if k < 0 || k >= nodeCount {
+		return ""
+	}
+	return nodeNames[k]
+}
+
+// The kind of node with a name given by NodeKindName(), or -1 if there is none.
+func NodeKindNamed(s string) int {
+	if s == "group" {
+		return GroupNode
+	}
+	for k, x := range nodeNames[1:] {
+		if x == s {
+			return k+1
+		}
+	}
+	return -1
+}
+
+// Parse a TranScript program, giving a tree for each of its toplevel
+// statements.
+func Parse(src string) ([]*Node, error) {
+	return ParseReader(strings.NewReader(src), "unknown")
+}
+
+// Parse a TranScript source file as Parse().
+func ParseReader(in io.Reader, f string) (ns []*Node, err error) {
+	defer catchError(&err)
+	l := NewScanner(in, f)
+	for l.Lookahead().Kind != eof {
+		if n := parseToplevel(l); n != nil {
+			ns = append(ns, n)
+		}
+	}
+	return ns, nil
+}
+
+// Compile a toplevel statement, given as a syntax tree. The tree is copied
+// first, so it need not have its Parent fields set and is not changed by
+// compiling it. Panics on error, which includes a node that does not have the
+// children its kind needs.
+func (u *Unit) CompileNode(n *Node) {
+	u.compileTree(copyNode(n, invalidNode))
+}
+
+// Compile a syntax tree as CompileNode(), returning an error rather than
+// panicking.
+func (u *Unit) CompileNodeErr(n *Node) (err error) {
+	defer catchError(&err)
+	u.CompileNode(n)
+	return nil
+}
+
+// Compile a syntax tree into a function. The function takes no arguments, and
+// runs the code as the top level of a program each time it is called, giving
+// the value of the statement. So a tree for an anonymous function gives a
+// function that returns the function the tree defines.
+func (i *Interpreter) CompileNode(n *Node) (f *Object, err error) {
+	u := new(Unit)
+	if err := u.CompileNodeErr(n); err != nil {
+		return nil, err
+	}
+	return Wrap(func(o *Object) *Object {
+		return i.Exec(u.Copy())
+	}), nil
+}
+
+// Copy a tree, checking that each node has the children the compiler expects
+// of its kind, given the kind of its parent.
+func copyNode(n *Node, in int) *Node {
+	if n == nil {
+		panic(fmt.Errorf("no syntax tree to compile"))
+	}
+	checkNode(n, in)
+	res := &Node{Kind: n.Kind, Token: n.Token, Data: n.Data}
+	for _, x := range n.Child {
+		if x == nil {
+			res.Add(nil)
+		} else {
+			res.Add(copyNode(x, n.Kind))
+		}
+	}
+	return res
+}
+
+// How many children each kind of node has: at least the first number and at
+// most the second, or any number more where that is -1.
+var nodeArity = [nodeCount][2]int {
+	invalidNode: {0, -1},
+	valNode: {0, 0},
+	accNode: {0, 0},
+	defNode: {1, -1},
+	varNode: {0, 2},
+	mutNode: {2, 2},
+	updNode: {2, 2},
+	patNode: {0, -1},
+	restNode: {1, 1},
+	ifNode: {3, 3},
+	logNode: {2, 2},
+	retNode: {1, 1},
+	whileNode: {2, 2},
+	forNode: {3, 3},
+	breakNode: {0, 0},
+	contNode: {0, 0},
+	tryNode: {3, 3},
+	catchNode: {3, 3},
+	matchNode: {1, -1},
+	caseNode: {3, 3},
+	yieldNode: {1, 1},
+	alookNode: {1, -1},
+	fnNode: {1, -1},
+	callNode: {1, -1},
+	kwNode: {1, 1},
+	classNode: {4, -1},
+	propNode: {2, 2},
+	lookNode: {1, 1},
+	thisNode: {0, 0},
+	superNode: {0, 0},
+}
+
+func checkNode(n *Node, in int) {
+	if n.Kind < 0 || n.Kind >= nodeCount {
+		panic(TokenError("unknown kind of node: %d", n.Token, n.Kind))
+	}
+	bad := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		panic(TokenError("malformed %s node: %s", n.Token, NodeKindName(n.Kind), msg))
+	}
+	lo, hi := nodeArity[n.Kind][0], nodeArity[n.Kind][1]
+	// definitions name what they define
+	if in == defNode && (n.Kind == varNode || n.Kind == fnNode) {
+		lo, hi = 2, 2
+	} else if n.Kind == varNode {
+		hi = 0
+	}
+	if c := len(n.Child); c < lo || (hi != -1 && c > hi) {
+		switch {
+		case lo == hi:
+			bad("has %d children, needs %d", c, lo)
+		case hi == -1:
+			bad("has %d children, needs at least %d", c, lo)
+		default:
+			bad("has %d children, needs %d to %d", c, lo, hi)
+		}
+	}
+	group := func(i int) {
+		if x := n.Child[i]; x != nil && x.Kind != invalidNode {
+			bad("child %d is a %s, needs a group", i+1, NodeKindName(x.Kind))
+		}
+	}
+	kinds := func(x *Node, ks ...int) {
+		for _, k := range ks {
+			if x.Kind == k {
+				return
+			}
+		}
+		bad("has a %s among its children", NodeKindName(x.Kind))
+	}
+	for i, x := range n.Child {
+		if x == nil && !mayBeNil(n, in, i) {
+			bad("child %d is missing", i+1)
+		}
+	}
+	switch n.Kind {
+	case valNode:
+		if _, ok := n.Data.(*Object); !ok {
+			bad("has no value")
+		}
+	case defNode:
+		for _, x := range n.Child {
+			kinds(x, varNode, fnNode, propNode)
+		}
+	case ifNode:
+		group(1)
+		group(2)
+	case whileNode:
+		group(1)
+	case forNode, catchNode, caseNode:
+		group(2)
+	case tryNode:
+		group(0)
+		group(1)
+		group(2)
+		for _, x := range n.Child[1].Child {
+			kinds(x, catchNode)
+		}
+	case matchNode:
+		for _, x := range n.Child[1:] {
+			kinds(x, caseNode)
+		}
+	case fnNode:
+		if in == defNode {
+			if x := n.Child[1]; x != nil {
+				kinds(x, fnNode)
+			}
+		} else {
+			group(0)
+		}
+	case classNode:
+		group(3)
+		for _, x := range n.Child[4:] {
+			kinds(x, defNode)
+		}
+	case propNode:
+		group(1)
+		if len(n.Child[1].Child) != 2 {
+			bad("needs a getter and a setter, either of which may be nil")
+		}
+	}
+}
+
+// Whether the child of a node may be left out.
+func mayBeNil(n *Node, in, i int) bool {
+	switch n.Kind {
+	case varNode, fnNode:
+		return in == defNode && i == 1
+	case tryNode:
+		return i == 2
+	case catchNode, caseNode:
+		return i == 1
+	case classNode:
+		return i < 3
+	case invalidNode:
+		// the getter and setter of a property
+		return in == propNode
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package ts_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+	_ "github.com/bobappleyard/ts/ext/ast"
+	_ "github.com/bobappleyard/ts/ext/system"
+	"github.com/bobappleyard/ts/parse"
+)
+
+// A tree as an s-expression, naming the kinds of its nodes.
+func sexp(n *parse.Node) string {
+	if n == nil {
+		return "nil"
+	}
+	res := "(" + ts.NodeKindName(n.Kind)
+	if n.Token.Text != "" {
+		res += " " + n.Token.Text
+	}
+	for _, x := range n.Child {
+		res += " " + sexp(x)
+	}
+	return res + ")"
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"call", "1 + 2;", "(call + (look __add__ (val 1)) (val 2))"},
+		{"def", "def a = b;", "(def def (var (group a) (var b)))"},
+		{"function", "fn(x) = this;", "(fn (group (group x)) (ret = (this this)))"},
+		{"if", "if a then b; end;", "(if if (var a) (group (var b)) (group))"},
+		{"statements", "a; b;", "(var a) (var b)"},
+	}
+	for _, test := range tests {
+		ns, err := ts.Parse(test.src)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		var got []string
+		for _, n := range ns {
+			got = append(got, sexp(n))
+		}
+		if s := strings.Join(got, " "); s != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, s, test.want)
+		}
+	}
+	ns, err := ts.ParseReader(strings.NewReader("a;\n  b;"), "src.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok := ns[1].Token; tok.File != "src.ts" || tok.Line != 2 || tok.Col != 3 {
+		t.Errorf("token: %+v", tok)
+	}
+	// errors are placed in the source, whether or not an interpreter has been
+	// made yet
+	errs := []struct {
+		src, want string
+	}{
+		{"1 +;", "unknown(1): unexpected ;"},
+		{"a;\ndef;", "unknown(2): "},
+		{"f(a", "unknown(1): expected , or ), got "},
+	}
+	for _, test := range errs {
+		_, err := ts.Parse(test.src)
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%q: got %v, want %s", test.src, err, test.want)
+		}
+	}
+}
+
+func TestNodeKinds(t *testing.T) {
+	for k := 0; ts.NodeKindName(k) != ""; k++ {
+		if ts.NodeKindNamed(ts.NodeKindName(k)) != k {
+			t.Errorf("kind %d: named %s", k, ts.NodeKindName(k))
+		}
+	}
+	if ts.NodeKindName(ts.GroupNode) != "group" || ts.NodeKindName(ts.ContNode) != "continue" {
+		t.Errorf("names: %s %s", ts.NodeKindName(ts.GroupNode), ts.NodeKindName(ts.ContNode))
+	}
+	if ts.NodeKindName(-1) != "" || ts.NodeKindName(1000) != "" || ts.NodeKindNamed("bogus") != -1 {
+		t.Error("unknown kinds have names")
+	}
+}
+
+func node(k int, text string, children ...*parse.Node) *parse.Node {
+	n := &parse.Node{Kind: k, Token: parse.Token{Text: text}}
+	for _, x := range children {
+		n.Add(x)
+	}
+	return n
+}
+
+func val(x interface{}) *parse.Node {
+	return &parse.Node{Kind: ts.ValNode, Data: ts.Wrap(x)}
+}
+
+// Trees built by hand compile into functions.
+func TestCompileNode(t *testing.T) {
+	i := ts.New()
+	// fn(a, b*) = [a, b]
+	args := node(ts.GroupNode, "", node(ts.GroupNode, "a"), node(ts.GroupNode, "b"))
+	args.Data = ts.FnArgs{Rest: true}
+	body, err := ts.Parse("[a, b];")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := node(ts.FnNode, "", args, node(ts.RetNode, "", body[0]))
+	f, err := i.CompileNode(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.Define("made", f.Call(nil))
+	x, err := run(t, i, 20*time.Second, "[made(1, 2, 3), made(1)];", ts.Limits{})
+	expect(t, "function", x, err, "[[1, [2, 3]], [1, []]]")
+	_, err = run(t, i, 20*time.Second, "made();", ts.Limits{})
+	if err == nil || !strings.Contains(err.Error(), "wrong number of arguments 0") {
+		t.Errorf("arity: got %v", err)
+	}
+	x, err = run(t, i, 20*time.Second, "catch(fn() = made()).msg;", ts.Limits{})
+	expect(t, "caught", x, err, "wrong number of arguments 0")
+
+	// the tree is left as it was, even when it is optimised
+	ns, _ := ts.Parse("60 * 60;")
+	u := new(ts.Unit)
+	u.SetOpt(1)
+	u.CompileNode(ns[0])
+	x, err = i.ExecErr(u)
+	expect(t, "folded", x, err, "3600")
+	if s := sexp(ns[0]); s != "(call * (look __mul__ (val 60)) (val 60))" {
+		t.Errorf("tree changed: %s", s)
+	}
+}
+
+func TestCompileNodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		n *parse.Node
+		want string
+	}{
+		{"nil", nil, "no syntax tree to compile"},
+		{"unknown", node(99, ""), "unknown kind of node: 99"},
+		{"no value", node(ts.ValNode, ""), "malformed val node: has no value"},
+		{"no function", node(ts.CallNode, ""), "malformed call node: has 0 children, needs at least 1"},
+		{"too many", node(ts.LookNode, "x", val(1), val(2)), "malformed look node: has 2 children, needs 1"},
+		{"missing", node(ts.CallNode, "", nil), "malformed call node: child 1 is missing"},
+		{"not a group", node(ts.IfNode, "", val(true), val(1), node(ts.GroupNode, "")), "malformed if node: child 2 is a val, needs a group"},
+		{"not a catch", node(ts.TryNode, "", node(ts.GroupNode, ""), node(ts.GroupNode, "", val(1)), nil), "malformed try node: has a val among its children"},
+		{"nested", node(ts.RetNode, "", node(ts.LookNode, "x")), "malformed look node: has 0 children, needs 1"},
+	}
+	for _, test := range tests {
+		_, err := ts.New().CompileNode(test.n)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.want)
+		}
+		got := func() (msg string) {
+			defer func() {
+				msg = fmt.Sprint(recover())
+			}()
+			new(ts.Unit).CompileNode(test.n)
+			return "no panic"
+		}()
+		if !strings.Contains(got, test.want) {
+			t.Errorf("%s (unit): got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+// Scripts work on trees through the ast package.
+func TestAstPackage(t *testing.T) {
+	expectAll(t, []scriptTest{
+		{"print", `import ast; ast.parse("1 + 2;")[0];`, `(call "+" (look "__add__" (val 1)) (val 2))`},
+		{"rewrite", `import ast; def t = ast.parse("3 + 2;")[0]; t.children[0].text = "__mul__"; ast.eval(t);`, "6"},
+		{"define", `import ast; ast.eval(ast.parse("def sq(x) = x * x;")[0]); sq(5);`, "25"},
+		{"compile", `import ast; def f = ast.compile(ast.parse("[1, 2];")[0]); [f(), f()];`, "[[1, 2], [1, 2]]"},
+		{"position", `import ast; def t = ast.parse("a;\n  b;", "src.ts")[1]; [t.kind, t.text, t.file, t.line, t.col];`, "[var, b, src.ts, 2, 3]"},
+		{"arguments", `
+			import ast;
+			def v = ast.parse("def f(a, b = 1, r*) = a;")[0].children[0].children[1].children[0].value;
+			[v["opt"], v["rest"], v["kw"]];
+		`, "[1, true, false]"},
+		{"change arguments", `
+			import ast;
+			def n = ast.parse("def f(a, b) = [a, b];")[0];
+			n.children[0].children[1].children[0].value = {"opt": 0, "rest": true, "kw": false};
+			ast.eval(n);
+			f(1, 2, 3);
+		`, "[1, [2, 3]]"},
+		{"visibility", `import ast; ast.parse("class A() private def x; end;")[0].children[4].children[0].value;`, "private"},
+		{"trait", `import ast; ast.parse("trait T end;")[0].value;`, "true"},
+		{"built", `import ast; def n = ast.Node("val"); n.value = 42; ast.eval(n);`, "42"},
+		{"kinds", `import ast; [ast.kinds[0], ast.kinds.size];`, "[group, 30]"},
+		{"caught", `
+			import ast;
+			def n = ast.Node("call", "", [ast.Node("var", "throw"), ast.Node("val")]);
+			n.children[1].value = "boom";
+			catch(fn() = ast.eval(n)).msg;
+		`, "boom"},
+		{"parse error line", `import ast; catch(fn() = ast.parse("\n1 +;", "bad.ts")).line;`, "2"},
+	})
+}
+
+func TestAstPackageErrors(t *testing.T) {
+	expectErrors(t, []scriptTest{
+		{"parse", `import ast; ast.parse("1 +;");`, "unknown(1): unexpected ;"},
+		{"parse type", "import ast; ast.parse(5);", "wrong type: Integer"},
+		{"parse arity", "import ast; ast.parse();", "wrong number of arguments 0"},
+		{"kind", `import ast; ast.Node("bogus");`, "unknown node kind: bogus"},
+		{"changed kind", `import ast; def n = ast.Node("val"); n.kind = "nothing"; ast.compile(n);`, "unknown node kind: nothing"},
+		{"node arity", "import ast; ast.Node();", "wrong number of arguments: 0"},
+		{"too many", `import ast; ast.Node("val", "x", [], 4);`, "wrong number of arguments: 4"},
+		{"not a tree", "import ast; ast.compile(5);", "not a syntax tree: 5"},
+		{"not a child", `import ast; ast.compile(ast.Node("group", "", [5]));`, "not a syntax tree: 5"},
+		{"malformed", `import ast; ast.compile(ast.Node("call"));`, "malformed call node: has 0 children, needs at least 1"},
+		{"visibility", `
+			import ast;
+			def n = ast.parse("def f(a) = a;")[0];
+			n.children[0].value = "wrong";
+			ast.compile(n);
+		`, "unknown visibility: wrong"},
+		{"undefined", `import ast; ast.eval(ast.Node("var", "nope"));`, "undefined variable: nope"},
+		{"compiled arity", `import ast; ast.eval(ast.parse("def f(a) = a;")[0]); f(1, 2);`, "wrong number of arguments 2"},
+	})
+}
@@ -0,0 +1,185 @@
+package ts
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+/*******************************************************************************
+
+	Binding Go types
+
+*******************************************************************************/
+
+// Options for BindClass().
+type BindOptions struct {
+	// The class to extend. If nil, Object is extended.
+	Ancestor *Class
+	// Flags for the class, such as Final. UserData is always set.
+	Flags int
+	// Traits to mix into the class.
+	Traits []*Class
+}
+
+// Derive a class from the Go struct type T. Instances of the class hold a *T
+// as their user data, so scripts and Go code share the same value. Use
+// Class.Instance() to hand an existing *T to scripts, and Unwrap() or
+// Object.UserData() to get it back.
+//
+// Exported fields become properties and methods on *T become methods. By
+// default a Go name becomes a script name by lower-casing its leading capitals,
+// so Name becomes name and URLPath becomes urlPath. A String() method becomes
+// toString(). Fields may be tagged to control this:
+//
+//	Name string `ts:"title"`         // known to scripts as title
+//	Secret string `ts:",private"`    // a private property
+//	Cache map[string]int `ts:"-"`    // not visible to scripts
+//
+// Arguments and results are converted as by Wrap() and Unwrap().
+//
+// The constructor takes an optional hash of property values. The class is not
+// defined as a global. Panics if T is not a struct or if two members would
+// have the same name.
+func BindClass[T any](i *Interpreter, n string, opts BindOptions) *Class {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Errorf("cannot bind %s: not a struct", t))
+	}
+	a := opts.Ancestor
+	if a == nil {
+		a = ObjectClass
+	}
+	e := []Slot {
+		MSlot("create", func(o *Object, args []*Object) *Object {
+			o.SetUserData(reflect.New(t).Interface())
+			switch len(args) {
+			case 0:
+			case 1:
+				for _, item := range args[0].hashData().items() {
+					o.Set(i.Accessor(item.key.ToString()), item.val)
+				}
+			default:
+				panic(ArgError(len(args)))
+			}
+			return Nil
+		}),
+	}
+	names := map[string] bool{}
+	add := func(s Slot) {
+		if names[s.Name] {
+			panic(fmt.Errorf("cannot bind %s: duplicate name %s", t, s.Name))
+		}
+		names[s.Name] = true
+		e = append(e, s)
+	}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name, vis, ok := bindName(f)
+		if !ok {
+			continue
+		}
+		add(bindField(t, f, name, vis))
+	}
+	pt := reflect.PtrTo(t)
+	for j := 0; j < pt.NumMethod(); j++ {
+		m := pt.Method(j)
+		name := scriptName(m.Name)
+		if m.Name == "String" && m.Type.NumIn() == 1 && m.Type.NumOut() == 1 &&
+		   m.Type.Out(0).Kind() == reflect.String {
+			name = "toString"
+		}
+		add(bindMethod(t, j, name))
+	}
+	return a.Extend(i, n, opts.Flags|UserData, e, opts.Traits...)
+}
+
+// Create an instance of a class that holds user data, without calling its
+// constructor. Use this to hand Go values to scripts as instances of classes
+// made by BindClass().
+func (c *Class) Instance(x interface{}) *Object {
+	if !c.FlagSet(UserData) {
+		panic(fmt.Errorf("class has no user data: %s", c.n))
+	}
+	o := c.alloc()
+	o.data = x
+	return o
+}
+
+// How a field is known to scripts.
+func bindName(f reflect.StructField) (name string, vis SlotVis, ok bool) {
+	tag := f.Tag.Get("ts")
+	if tag == "-" {
+		return "", 0, false
+	}
+	opts := strings.Split(tag, ",")
+	name, vis = opts[0], Public
+	if name == "" {
+		name = scriptName(f.Name)
+	}
+	for _, x := range opts[1:] {
+		switch x {
+		case "private":
+			vis = Private
+		default:
+			panic(fmt.Errorf("bad tag on %s: %q", f.Name, tag))
+		}
+	}
+	return name, vis, true
+}
+
+// Lower-case the leading capitals of a Go name, leaving the last of them alone
+// if it begins another word.
+func scriptName(n string) string {
+	rs := []rune(n)
+	for i := range rs {
+		if !unicode.IsUpper(rs[i]) {
+			break
+		}
+		if i > 0 && i+1 < len(rs) && unicode.IsLower(rs[i+1]) {
+			break
+		}
+		rs[i] = unicode.ToLower(rs[i])
+	}
+	return string(rs)
+}
+
+// The *T held by an instance of a bound class.
+func boundData(o *Object, t reflect.Type) reflect.Value {
+	v := reflect.ValueOf(o.data)
+	if v.Kind() != reflect.Ptr || v.Type().Elem() != t || v.IsNil() {
+		panic(TypeError(o))
+	}
+	return v
+}
+
+func bindField(t reflect.Type, f reflect.StructField, n string, vis SlotVis) Slot {
+	field := func(o *Object) reflect.Value {
+		res, err := boundData(o, t).Elem().FieldByIndexErr(f.Index)
+		if err != nil {
+			panic(err)
+		}
+		return res
+	}
+	get := Wrap(func(o *Object) *Object {
+		return wrapField(field(o))
+	})
+	set := Wrap(func(o, x *Object) *Object {
+		setField(field(o), x, n)
+		return Nil
+	})
+	return Slot{Flags: Flags(Property, vis), Name: n, Value: get, Set: set}
+}
+
+func bindMethod(t reflect.Type, j int, n string) Slot {
+	f := new(funcObj).init(func(p *process) {
+		p.b = len(p.s) - p.n
+		p.noKeywords()
+		p.ret(callFunc(boundData(p.t, t).Method(j), p.args()))
+	})
+	return Slot{Flags: Flags(Method, Public), Name: n, Value: f}
+}
+
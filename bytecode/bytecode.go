@@ -1,6 +1,8 @@
 package bytecode
 
-import ()
+import (
+	"fmt"
+)
 
 const (
 	NOP = iota 
@@ -36,6 +38,110 @@ const (
 	THIS
 	LTHIS
 	SUPER
+	// Deprecated: no longer generated. It gave the source position of the code
+	// after it, which is now kept in a table alongside the code.
 	SOURCE
+	TRY
+	UNTRY
+	THROW
+	FINALLY
+	GENERATE
+	YIELD
+	CALLK
+	PROLOG_KW
+	DEFAULT
+	BIND
+	SHAPE
+	MATCH
+	FIELDS
+	MIXIN
+	TRAIT
+	PUSHV
+	PUSHB
+	GLOBALU
+	BOUNDU
 )
 
+
+// How many operands follow each instruction. CALLK and PROLOG_KW are followed by
+// further operands, one for each keyword.
+var operands = [...]int {
+	JUMP: 1,
+	BRANCH: 1,
+	VALUE: 1,
+	ACCESSOR: 1,
+	BOUND: 1,
+	FREE: 1,
+	GLOBAL: 1,
+	BOX: 1,
+	UNDEFINE: 1,
+	FRAME: 1,
+	SHUFFLE: 1,
+	RETRACT: 1,
+	CALL: 1,
+	CLOSE: 2,
+	CLOSEM: 2,
+	PROLOG: 1,
+	PROLOG_OPT: 2,
+	PROLOG_REST: 2,
+	EXTENDA: 1,
+	FINISH: 1,
+	GET: 2,
+	GETM: 2,
+	SET: 2,
+	SUPER: 1,
+	SOURCE: 2,
+	TRY: 1,
+	FINALLY: 1,
+	CALLK: 2,
+	PROLOG_KW: 3,
+	DEFAULT: 2,
+	BIND: 1,
+	SHAPE: 2,
+	MATCH: 2,
+	FIELDS: 1,
+	MIXIN: 1,
+	TRAIT: 1,
+	PUSHV: 1,
+	PUSHB: 1,
+	GLOBALU: 1,
+	BOUNDU: 1,
+}
+
+// The number of words taken up by the instruction at the start of some code,
+// including its operands. Panics if there is no valid instruction there.
+func Width(code []uint32) int {
+	op := code[0]
+	if int(op) >= len(operands) {
+		panic(fmt.Errorf("bad instruction: %d", op))
+	}
+	n := 1 + operands[op]
+	switch op {
+	case CALLK:
+		n += int(code[2])
+	case PROLOG_KW:
+		n += int(code[2])
+	}
+	if n > len(code) {
+		panic(fmt.Errorf("truncated instruction: %d", op))
+	}
+	return n
+}
+
+var names = [...]string {
+	"NOP", "JUMP", "BRANCH", "VALUE", "ACCESSOR", "BOUND", "FREE", "GLOBAL",
+	"BOX", "UNDEFINE", "UNBOX", "UPDATE", "DEFINE", "PUSH", "FRAME", "SHUFFLE",
+	"RETURN", "RETRACT", "CALL", "CLOSE", "CLOSEM", "PROLOG", "PROLOG_OPT",
+	"PROLOG_REST", "EXTEND", "EXTENDA", "FINISH", "GET", "GETM", "SET", "THIS",
+	"LTHIS", "SUPER", "SOURCE", "TRY", "UNTRY", "THROW", "FINALLY", "GENERATE",
+	"YIELD", "CALLK", "PROLOG_KW", "DEFAULT", "BIND", "SHAPE", "MATCH", "FIELDS",
+	"MIXIN", "TRAIT", "PUSHV", "PUSHB", "GLOBALU", "BOUNDU",
+}
+
+// The name of an instruction, as it is written in this package.
+func Name(op uint32) string {
+	if int(op) >= len(names) {
+		return fmt.Sprintf("<%d>", op)
+	}
+	return names[op]
+}
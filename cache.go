@@ -0,0 +1,124 @@
+package ts
+
+import (
+	"sync/atomic"
+	. "github.com/bobappleyard/ts/bytecode"
+)
+
+/*******************************************************************************
+
+	Inline caches
+
+*******************************************************************************/
+
+// Each GET, GETM and SET instruction keeps a cache of the slots it has found,
+// keyed by the class of the object it was used on. Where the instruction knows
+// something about the slot statically, the class whose code is running is part
+// of the key as well.
+//
+// A class's slots do not move once it has been added, and the classes it is
+// descended from do not change, so the only thing that can change what is
+// found is a new entry in the accessor. Adding an entry replaces the accessor's
+// list of entries, so the cache notes the list it was filled from and is
+// thrown away when that is no longer the accessor's list.
+type cacheSite struct {
+	e atomic.Pointer[cacheEntries]
+}
+
+// How many classes a site remembers. Beyond that the site keeps the classes it
+// saw first and looks the rest up each time.
+const cacheSize = 4
+
+// The entries are never changed once they have been stored in a site, so code
+// running at the same time in other processes sees either the old entries or
+// the new ones.
+type cacheEntries struct {
+	es *[]Slot
+	n int
+	c, sc [cacheSize]*Class
+	s [cacheSize]*Slot
+}
+
+// Whether instructions are given caches when code is linked. Only turned off to
+// measure what the caches are worth.
+var inlineCaching = true
+
+// Make room in the unit's caches for the code that has been added since it was
+// last linked. Code is only ever added to the end of a block.
+func (u *Unit) linkCaches() {
+	if !inlineCaching {
+		return
+	}
+	for len(u.ic) < len(u.b) {
+		u.ic = append(u.ic, nil)
+	}
+	for k, c := range u.b {
+		ic := u.ic[k]
+		if len(ic) == len(c) {
+			continue
+		}
+		next := make([]*cacheSite, len(c))
+		copy(next, ic)
+		for p := len(ic); p < len(c); p += Width(c[p:]) {
+			switch c[p] {
+			case GET, GETM, SET:
+				next[p] = new(cacheSite)
+			}
+		}
+		u.ic[k] = next
+	}
+}
+
+// Find the slot for an access by the instruction that has just been read, which
+// has m as its static slot. Returns nil if there is no slot.
+func (p *process) cached(a *Accessor, m int) *Slot {
+	var site *cacheSite
+	if p.k < len(p.u.ic) && p.p-3 < len(p.u.ic[p.k]) {
+		site = p.u.ic[p.k][p.p-3]
+	}
+	if site == nil {
+		return p.lookup(a, m)
+	}
+	c, sc := p.v.c, p.sc
+	if uint32(m) == slotUnknown {
+		sc = nil
+	} else if c == sc {
+		// the slot is right there, which is quicker than any cache
+		if e := &sc.e[m]; e.Flags.Kind() != Marker {
+			return e
+		}
+	}
+	// load the entries first, so that anything found is at least as new
+	es := a.e.Load()
+	x := site.e.Load()
+	if x != nil && x.es == es {
+		for j := 0; j < x.n; j++ {
+			if x.c[j] == c && x.sc[j] == sc {
+				return x.s[j]
+			}
+		}
+	}
+	s := p.lookup(a, m)
+	if s == nil {
+		return nil
+	}
+	y := &cacheEntries{es: es}
+	if x != nil && x.es == es {
+		if x.n == cacheSize {
+			return s
+		}
+		*y = *x
+	}
+	y.c[y.n], y.sc[y.n], y.s[y.n] = c, sc, s
+	y.n++
+	site.e.Store(y)
+	return s
+}
+
+// Find the slot for an access without a cache.
+func (p *process) lookup(a *Accessor, m int) *Slot {
+	if s := p.lookups(m); s != nil {
+		return s
+	}
+	return a.lookup(p.v)
+}
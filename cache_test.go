@@ -0,0 +1,282 @@
+package ts
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// Object-heavy scripts, run with and without the caches at GET, GETM and SET
+// instructions. Each defines work(), which is called once per iteration.
+
+// Mostly one class at each site, with some calls going to a subclass. Most of
+// the time goes on making new vectors, and most accesses are to this, which
+// does not use the caches, so the two should take about as long.
+const vectorScript = `
+	class Vec()
+		def x, y;
+		def create(x, y)
+			this.x = x;
+			this.y = y;
+		end;
+		def add(o) = Vec(this.x + o.x, this.y + o.y);
+		def dot(o) = this.x * o.x + this.y * o.y;
+	end;
+	class Vec3(Vec)
+		def z = 0;
+		def dot(o) = super.dot(o) + this.z;
+	end;
+	def vecs = [Vec(1, 2), Vec3(3, 4), Vec(5, 6), Vec3(7, 8)];
+	def work()
+		def acc = Vec(0, 0), total = 0, i = 0;
+		while i < 1000 do
+			def v = vecs[i % 4];
+			acc = acc.add(v);
+			total = total + v.dot(acc);
+			i = i + 1;
+		end;
+		return total;
+	end;
+`
+
+// Several classes at each site, some of them finding methods and fields a few
+// ancestors up.
+const shapeScript = `
+	class Shape()
+		def scale = 1;
+		def size() = this.area() * this.scale;
+	end;
+	class Square(Shape)
+		def side = 2;
+		def area() = this.side * this.side;
+	end;
+	class Rect(Square)
+		def width = 3;
+		def area() = this.side * this.width;
+	end;
+	class Circle(Shape)
+		def r = 1;
+		def area() = 3 * this.r * this.r;
+	end;
+	class Dot(Circle)
+		def area() = 0;
+	end;
+	def shapes = [Square(), Rect(), Circle(), Dot()];
+	def work()
+		def total = 0, i = 0;
+		while i < 1000 do
+			total = total + shapes[i % 4].size();
+			i = i + 1;
+		end;
+		return total;
+	end;
+`
+
+// Fields read and written, both by methods and from outside.
+const counterScript = `
+	class Counter()
+		def count = 0;
+		def step = 1;
+		def tick()
+			this.count = this.count + this.step;
+		end;
+	end;
+	def work()
+		def c = Counter(), i = 0;
+		while i < 1000 do
+			c.tick();
+			c.step = c.count % 3 + 1;
+			i = i + 1;
+		end;
+		return c.count;
+	end;
+`
+
+// A method that many classes define, called on objects of classes with long
+// ancestries.
+const deepScript = `
+	for n in range(30) do
+		eval("class Other${n}() def area() = ${n}; end;");
+	end;
+	class Base()
+		def area() = 1;
+	end;
+	def Deep = Base;
+	for n in range(12) do
+		eval("class Deep${n}(Deep) end; Deep = Deep${n};");
+	end;
+	class Mid(Base)
+		def area() = 2;
+	end;
+	def shapes = [Base(), Deep(), Mid(), Deep5()];
+	def work()
+		def total = 0, i = 0;
+		while i < 1000 do
+			total = total + shapes[i % 4].area();
+			i = i + 1;
+		end;
+		return total;
+	end;
+`
+
+// Scripts where what a site finds changes after it has first been used. Each
+// gives the same result with and without the caches.
+var cacheTests = []struct {
+	name, src, want string
+}{
+	{"class redefined", `
+		class A()
+			def m() = 1;
+		end;
+		def call(o) = o.m();
+		def old = A(), before = call(old);
+		class A()
+			def m() = 2;
+		end;
+		[before, call(A()), call(old)];
+	`, "[1, 2, 1]"},
+	{"method defined elsewhere", `
+		class A()
+			def m() = 1;
+		end;
+		def call(o) = o.m();
+		def before = call(A());
+		eval("class B() def m() = 2; end;");
+		[before, call(A()), call(B())];
+	`, "[1, 1, 2]"},
+	{"field reassigned", `
+		class A()
+			def f = fn() = 1;
+		end;
+		def call(o) = o.f();
+		def a = A(), before = call(a);
+		a.f = fn() = 2;
+		[before, call(a)];
+	`, "[1, 2]"},
+	{"subclass receiver", `
+		class A()
+			def m() = 1;
+		end;
+		class B(A)
+			def m() = 2;
+		end;
+		class C(A)
+		end;
+		class D(B)
+			def m() = super.m() + 1;
+		end;
+		def call(o) = o.m();
+		[call(A()), call(B()), call(C()), call(D()), call(A())];
+	`, "[1, 2, 1, 3, 1]"},
+	{"more classes than the cache holds", `
+		class A()
+			def m() = 0;
+		end;
+		def call(o) = o.m();
+		def res = [];
+		for n in range(8) do
+			eval("class K${n}(A) def m() = ${n}; end;");
+			res.push(call(eval("K${n}();")));
+			res.push(call(A()));
+		end;
+		res;
+	`, "[0, 0, 1, 0, 2, 0, 3, 0, 4, 0, 5, 0, 6, 0, 7, 0]"},
+	{"this in an inherited method", `
+		class A()
+			def run() = this.m() + this.n();
+			def m() = 1;
+		private
+			def n() = 10;
+		end;
+		class B(A)
+			def m() = 2;
+			def n() = 20;
+		end;
+		[A().run(), B().run(), A().run()];
+	`, "[11, 12, 11]"},
+	{"field then property", `
+		class A()
+			def x = 1;
+		end;
+		class B()
+			def x get() = 2;
+		end;
+		def get(o) = o.x;
+		def set(o, v)
+			o.x = v;
+		end;
+		def a = A();
+		set(a, 3);
+		[get(a), get(B()), get(a)];
+	`, "[3, 2, 3]"},
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	defer func(was bool) {
+		inlineCaching = was
+	}(inlineCaching)
+	for _, test := range cacheTests {
+		for _, cached := range []bool{true, false} {
+			inlineCaching = cached
+			u := new(Unit)
+			err := u.CompileErr(strings.NewReader(test.src), "test")
+			var x *Object
+			if err == nil {
+				x, err = New().ExecLimits(context.Background(), u, Limits{})
+			}
+			if err != nil {
+				t.Errorf("%s (cached %v): %s", test.name, cached, err)
+			} else if x.String() != test.want {
+				t.Errorf("%s (cached %v): got %s, want %s", test.name, cached, x, test.want)
+			}
+		}
+	}
+}
+
+func benchmarkScript(b *testing.B, src string, cached bool) {
+	defer func(was bool) {
+		inlineCaching = was
+	}(inlineCaching)
+	inlineCaching = cached
+	i := New()
+	u := new(Unit)
+	u.CompileStr(src)
+	i.Exec(u)
+	work := i.Get("work")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		work.Call(nil)
+	}
+}
+
+func BenchmarkVectors(b *testing.B) {
+	benchmarkScript(b, vectorScript, true)
+}
+
+func BenchmarkVectorsUncached(b *testing.B) {
+	benchmarkScript(b, vectorScript, false)
+}
+
+func BenchmarkShapes(b *testing.B) {
+	benchmarkScript(b, shapeScript, true)
+}
+
+func BenchmarkShapesUncached(b *testing.B) {
+	benchmarkScript(b, shapeScript, false)
+}
+
+func BenchmarkCounter(b *testing.B) {
+	benchmarkScript(b, counterScript, true)
+}
+
+func BenchmarkCounterUncached(b *testing.B) {
+	benchmarkScript(b, counterScript, false)
+}
+
+func BenchmarkDeep(b *testing.B) {
+	benchmarkScript(b, deepScript, true)
+}
+
+func BenchmarkDeepUncached(b *testing.B) {
+	benchmarkScript(b, deepScript, false)
+}
@@ -29,4 +29,50 @@ For keys, the following rules hold:
 */
 var HashClass *Class
 
+/*
+
+	class Generator(Iterator)
+
+Calling a function whose body contains "yield" returns a Generator rather than
+running the body. Each call to next() runs the body up to its next "yield",
+and returns the value given there. Once the body has finished, next() returns
+done.
+*/
+var GeneratorClass *Class
+
+/*
+
+	class BigInt(Integer)
+
+A BigInt is an integer too large to fit in 64 bits. Arithmetic on integers
+gives a BigInt when the result would overflow, and an Integer whenever the
+result fits in one again, so scripts rarely need to know which they have.
+*/
+var BigIntClass *Class
+
+/*
+
+	class Rational(Number)
+
+A Rational is an exact fraction. Rational(n, d) makes n/d from two integers, and
+Rational(x) converts an integer, a float or a string such as "1/3".
+
+Arithmetic between a rational and an integer gives a rational, so that
+Rational(1) / 3 is exactly a third. Arithmetic with a float gives a float.
+Dividing integers still gives a float.
+*/
+var RationalClass *Class
+
+
+
+/*
+
+	class GoObject
+
+A GoObject holds a value passed in from Go by Wrap(). Exported fields of a
+struct may be read and written as properties, and the value's methods may be
+called as methods. Arguments and results are converted as described for 
+Wrap() and Unwrap().
+*/
+var GoObjectClass *Class
 
@@ -3,6 +3,7 @@ package ts
 import (
 	"io"
 	"fmt"
+	"sort"
 	"strings"
 	. "github.com/bobappleyard/ts/parse"
 	. "github.com/bobappleyard/ts/bytecode"
@@ -23,19 +24,29 @@ func (u *Unit) Compile(in io.Reader, f string) {
 
 // Compile a single toplevel statement.
 func (u *Unit) CompileStmt(l *Lexer) bool {
-	if len(u.b) == 0 {
-		u.b = [][]uint16{nil}
-	}
-	if len(u.v) == 0 {
-		u.v = []*Object{Nil, True, False}
-	}
+	u.prepare()
 	if l.Lookahead().Kind == eof {
 		return false
 	}
-	u.compileTopLevel(parseToplevel(l))
+	u.compileTree(parseToplevel(l))
 	return true
 }
 
+// Compile a source file as Compile(), returning an error rather than
+// panicking.
+func (u *Unit) CompileErr(in io.Reader, f string) (err error) {
+	defer catchError(&err)
+	u.Compile(in, f)
+	return nil
+}
+
+// Compile a single toplevel statement as CompileStmt(), returning an error
+// rather than panicking.
+func (u *Unit) CompileStmtErr(l *Lexer) (more bool, err error) {
+	defer catchError(&err)
+	return u.CompileStmt(l), nil
+}
+
 // Shorthand wrapper around Compile().
 func (u *Unit) CompileStr(s string) {
 	u.Compile(strings.NewReader(s), "unknown")
@@ -60,15 +71,28 @@ const (
 	defNode
 	varNode
 	mutNode
+	updNode
+	patNode
+	restNode
 	// control features
 	ifNode
 	logNode
 	retNode
+	whileNode
+	forNode
+	breakNode
+	contNode
+	tryNode
+	catchNode
+	matchNode
+	caseNode
+	yieldNode
 	// arrays
 	alookNode
 	// functions
 	fnNode
 	callNode
+	kwNode
 	// classes and objects
 	classNode
 	propNode
@@ -85,12 +109,25 @@ var nodeNames = []string {
 	"def",
 	"var",
 	"mut",
+	"upd",
+	"pat",
+	"rest",
 	"if",
 	"log",
 	"ret",
+	"while",
+	"for",
+	"break",
+	"continue",
+	"try",
+	"catch",
+	"match",
+	"case",
+	"yield",
 	"alook",
 	"fn",
 	"call",
+	"kw",
 	"class",
 	"prop",
 	"look",
@@ -100,24 +137,60 @@ var nodeNames = []string {
 
 type compilerCtx struct {
 	bound, free, boxed, class []string
-	block *[]uint16
+	block *[]uint32
 	offset int
+	src *[]srcPos
+	loop *loopCtx
+	try *tryCtx
+}
+
+// The innermost loop around the code being compiled.
+type loopCtx struct {
+	depth int // how many variables are on the stack outside the loop body
+	start int
+	exits []compilerSym
+}
+
+// The innermost try statement around the code being compiled.
+type tryCtx struct {
+	finally []*Node
+	handlers int // how many handlers are set up at this point
+	loop *loopCtx // the loop the statement is in
+	outer *tryCtx
+}
+
+// A pattern in a match statement being compiled.
+type failCtx struct {
+	depth int // how many variables are on the stack outside the pattern
+	exits [][]compilerSym // by how many more there are when the test fails
 }
 
 type compilerSym int
 
-func (e compilerCtx) write(op uint16, args... int) compilerSym {
+// Write an instruction. Operands are indices, counts and offsets, apart from -1,
+// which stands for a slot that is not known statically.
+func (e compilerCtx) write(op uint32, args... int) compilerSym {
 	s := compilerSym(len(*e.block))
 	*e.block = append(*e.block, op)
 	for _, x := range args {
-		*e.block = append(*e.block, uint16(x))
+		*e.block = append(*e.block, operand(x))
 	}
 	return s
 }
 
 func (s compilerSym) place(e compilerCtx) {
 	b := *e.block
-	b[s+1] = uint16(len(b) + e.offset)
+	b[s+1] = operand(len(b) + e.offset)
+}
+
+func operand(x int) uint32 {
+	if x == -1 {
+		return slotUnknown
+	}
+	if x < 0 || int64(x) >= int64(slotUnknown) {
+		panic(fmt.Errorf("operand out of range: %d", x))
+	}
+	return uint32(x)
 }
 
 func (s compilerSym) pos(e compilerCtx) int {
@@ -133,29 +206,67 @@ func (e compilerCtx) static(n string) int {
 	return lookup(n, e.class)
 }
 
+// Set up the unit for compiling, if nothing has been compiled into it yet.
+func (u *Unit) prepare() {
+	if len(u.b) == 0 {
+		u.b = [][]uint32{nil}
+	}
+	if len(u.v) == 0 {
+		u.v = []*Object{Nil, True, False}
+	}
+}
+
+// Compile a toplevel statement that has been parsed.
+func (u *Unit) compileTree(n *Node) {
+	u.prepare()
+	if u.opt > 0 {
+		foldConstants(n)
+	}
+	u.compileTopLevel(n)
+}
+
 func (u *Unit) compileTopLevel(n *Node) {
 	if n == nil {
 		return
 	}
-	e := compilerCtx{nil, nil, nil, nil, new([]uint16), len(u.b[0])}
+	e := compilerCtx{nil, nil, nil, nil, new([]uint32), len(u.b[0]), new([]srcPos), nil, nil}
 	u.compileNode(n, e)
+	if u.opt > 0 {
+		*e.block, *e.src = u.peephole(*e.block, e.offset, *e.src)
+	}
 	u.b[0] = append(u.b[0], *e.block...)
+	u.addSrc(0, *e.src)
 }
 
-func (u *Unit) writeSrc(n *Node, e compilerCtx) {
+// Note that the code about to be written comes from the node's position in the
+// source.
+func (u *Unit) compileSrc(n *Node, e compilerCtx) {
 	t := n.Token
-	if t.Line != 0 {
-		u.file = t.File
-		u.line = t.Line
-		e.write(SOURCE, u.getVal(Wrap(t.File)), u.line)
+	if t.Line == 0 {
+		return
 	}
+	u.file = t.File
+	pos := srcPos{len(*e.block) + e.offset, t.File, t.Line, t.Col}
+	s := *e.src
+	if l := len(s); l != 0 {
+		last := &s[l-1]
+		if last.file == pos.file && last.line == pos.line && last.col == pos.col {
+			return
+		}
+		if last.offset == pos.offset {
+			*last = pos
+			return
+		}
+	}
+	*e.src = append(s, pos)
 }
 
-func (u *Unit) compileSrc(n *Node, e compilerCtx) {
-	t := n.Token
-	if t.Line != u.line {
-		u.writeSrc(n, e)
+// Add to the position table for a block.
+func (u *Unit) addSrc(ix int, s []srcPos) {
+	for len(u.src) <= ix {
+		u.src = append(u.src, nil)
 	}
+	u.src[ix] = append(u.src[ix], s...)
 }
 
 func (u *Unit) compileNode(n *Node, e compilerCtx) {
@@ -171,14 +282,34 @@ func (u *Unit) compileNode(n *Node, e compilerCtx) {
 		u.compileAcc(n, e)
 	case mutNode:
 		u.compileMutation(n, e)
+	case updNode:
+		u.compileUpdate(n, e)
 	case ifNode:
 		u.compileIf(n, e)
 	case logNode:
 		u.compileLog(n, e)
 	case retNode:
 		u.compileRet(n, e)
+	case whileNode:
+		u.compileWhile(n, e)
+	case forNode:
+		u.compileFor(n, e)
+	case breakNode, contNode:
+		u.compileBreak(n, e)
+	case tryNode:
+		u.compileTry(n, e)
+	case matchNode:
+		u.compileMatch(n, e)
+	case yieldNode:
+		if yieldsFrom(n) == nil {
+			panic(Unexpected(n.Token))
+		}
+		u.compileNode(n.Child[0], e)
+		e.write(YIELD)
 	case alookNode:
 		u.compileMethod(n, "__aget__", e)
+	case patNode, restNode, caseNode:
+		panic(Unexpected(n.Token))
 	case fnNode:
 		u.compileFn(n, e)
 	case callNode:
@@ -240,6 +371,32 @@ func lookup(n string, s []string) int {
 	return -1
 }
 
+// Where entries are in one of a unit's tables, so that compiling large programs
+// does not search the tables again for each name or constant.
+type tableIndex struct {
+	pos map[interface{}] int
+	n int // how many entries have been indexed
+}
+
+// Find the first entry with key k in a table with size entries, given the keys
+// of the entries. Entries whose key is nil are left out. Returns -1 if there is
+// no such entry.
+func (t *tableIndex) find(k interface{}, size int, key func(i int) interface{}) int {
+	if t.pos == nil || t.n > size {
+		t.pos, t.n = map[interface{}] int{}, 0
+	}
+	for ; t.n < size; t.n++ {
+		x := key(t.n)
+		if _, ok := t.pos[x]; x != nil && !ok {
+			t.pos[x] = t.n
+		}
+	}
+	if i, ok := t.pos[k]; ok {
+		return i
+	}
+	return -1
+}
+
 func merge(a, b []string) []string {
 	inc := map[string] bool {}
 	for _, x := range a {
@@ -305,12 +462,6 @@ func closedVars(body []*Node, e compilerCtx) []*Node {
 	return res
 }
 
-func isBoxed(n *Node, b []string) bool {
-	return n.Kind == mutNode &&
-	       n.Child[0].Kind == varNode &&
-	       lookup(n.Child[0].Token.Text, b) != -1
-}
-
 func boxedVars(n []*Node, b []string, e compilerCtx) []string {
 	in := map[string] bool{}
 	for _, x := range e.boxed {
@@ -321,8 +472,12 @@ func boxedVars(n []*Node, b []string, e compilerCtx) []string {
 	}
 	for _, x := range n {
 		x.Scan(func(n *Node) bool {
-			if isBoxed(n, b) {
-				in[n.Child[0].Token.Text] = true
+			if n.Kind == mutNode || n.Kind == updNode {
+				for _, v := range patternVars(n.Child[0]) {
+					if lookup(v, b) != -1 {
+						in[v] = true
+					}
+				}
 			}
 			return true;
 		})
@@ -337,13 +492,16 @@ func boxedVars(n []*Node, b []string, e compilerCtx) []string {
 }
 
 func isTail(n *Node) bool {
-	if n.Parent == nil {
+	if n.Parent == nil || n.Parent.Kind != retNode {
 		return false
 	}
-	if n.Parent.Kind == retNode {
-		return true
+	// try statements have to clear up after the call
+	for cur := n.Parent; cur != nil && cur.Kind != fnNode; cur = cur.Parent {
+		if cur.Kind == tryNode {
+			return false
+		}
 	}
-	return false
+	return true
 }
 
 func containingNode(n *Node, k int) *Node {
@@ -361,13 +519,16 @@ func isInside(n *Node, k int) bool {
 
 func (u *Unit) compileArgs(s []*Node, e compilerCtx) {
 	for _, x := range s {
+		if x.Kind == kwNode {
+			x = x.Child[0]
+		}
 		u.compileNode(x, e)
 		e.write(PUSH)
 	}
 }
 
 func (u *Unit) getGlobal(n string) int {
-	if i := lookup(n, u.gn); i != -1 {
+	if i := u.gi.find(n, len(u.gn), func(i int) interface{} { return u.gn[i] }); i != -1 {
 		return i
 	}
 	i := len(u.gn)
@@ -378,7 +539,7 @@ func (u *Unit) getGlobal(n string) int {
 
 func (u *Unit) getAccessor(n string) int {
 	if n != "" {
-		if i := lookup(n, u.an); i != -1 {
+		if i := u.ai.find(n, len(u.an), func(i int) interface{} { return u.an[i] }); i != -1 {
 			return i
 		}
 	}
@@ -388,8 +549,40 @@ func (u *Unit) getAccessor(n string) int {
 	return i
 }
 
+// The key by which a constant is indexed, if it is one that the compiler makes
+// from a literal.
+type valKey struct {
+	c *Class
+	x interface{}
+}
+
+func constKey(v *Object) interface{} {
+	switch v.c {
+	case StringClass:
+		return valKey{v.c, v.ToString()}
+	case IntClass:
+		return valKey{v.c, v.ToInt()}
+	case FltClass:
+		return valKey{v.c, v.ToFloat()}
+	case BigIntClass:
+		return valKey{v.c, toBig(v).String()}
+	}
+	return nil
+}
+
 func (u *Unit) getVal(v *Object) int {
+	if k := constKey(v); k != nil {
+		i := u.vi.find(k, len(u.v), func(i int) interface{} { return constKey(u.v[i]) })
+		if i == -1 {
+			i = len(u.v)
+			u.v = append(u.v, v)
+		}
+		return i
+	}
 	for i, x := range u.v {
+		if x == v {
+			return i
+		}
 		if x.c.m == nil {
 			continue
 		}
@@ -407,25 +600,103 @@ func (u *Unit) compileDef(n *Node, e compilerCtx) {
 		if x.Kind == propNode {
 			panic(fmt.Errorf("property definition outside a class"))
 		}
+		if x.Child[0].Kind == patNode {
+			u.compileUnpack(x.Child[0], x.Child[1], e, func(t, v *Node, e compilerCtx) {
+				u.compileNode(v, e)
+				u.defineVar(n, t.Token.Text, e)
+			})
+			continue
+		}
 		t := x.Child[0].Token
 		if x.Child[1] == nil {
 			e.write(VALUE, 0)
 		} else {
 			u.compileNode(x.Child[1], e)
 		}
-		e.write(PUSH)
-		if n.Parent == nil {
-			e.write(GLOBAL, u.getGlobal(t.Text))
-		} else {
-			e.write(BOUND, lookup(t.Text, e.bound))
+		u.defineVar(n, t.Text, e)
+	}
+}
+
+// Give a variable being defined the value just worked out.
+func (u *Unit) defineVar(n *Node, name string, e compilerCtx) {
+	e.write(PUSH)
+	if n.Parent == nil {
+		e.write(GLOBAL, u.getGlobal(name))
+	} else {
+		e.write(BOUND, lookup(name, e.bound))
+	}
+	e.write(DEFINE)
+	e.write(UPDATE)
+}
+
+// The variables a pattern binds or assigns to.
+func patternVars(n *Node) []string {
+	switch n.Kind {
+	case patNode, restNode:
+		var res []string
+		for _, x := range n.Child {
+			res = append(res, patternVars(x)...)
 		}
-		e.write(DEFINE)
-		e.write(UPDATE)
+		return res
+	case invalidNode, varNode:
+		return []string{n.Token.Text}
 	}
+	return nil
+}
+
+// Take a value apart according to a pattern. Each name or location in the
+// pattern is passed to bind, along with a node that gives its part of the
+// value.
+func (u *Unit) compileUnpack(pat, val *Node, e compilerCtx, bind func(t, v *Node, e compilerCtx)) {
+	if pat.Kind != patNode {
+		bind(pat, val, e)
+		return
+	}
+	shape, l := shapeHash, len(pat.Child)
+	switch pat.Token.Text {
+	case "[":
+		shape = shapeArray
+		if l != 0 && pat.Child[l-1].Kind == restNode {
+			shape = shapeArrayRest
+			l--
+		}
+	case ":":
+		shape = shapePair
+	}
+	u.compileNode(val, e)
+	u.compileSrc(pat, e)
+	e.write(SHAPE, shape, l)
+	// hold on to the value while taking it apart
+	e.write(PUSH)
+	e.bound = append(hideVars(e.bound, "@unpack"), "@unpack")
+	tmp := func() *Node {
+		x := &Node{Kind: varNode, Token: pat.Token}
+		x.Token.Text = "@unpack"
+		return x
+	}
+	for i, x := range pat.Child {
+		var v *Node
+		switch {
+		case x.Kind == restNode:
+			v = methodCall(pat, "slice", tmp(), vNode(i))
+			x = x.Child[0]
+		case shape == shapeHash:
+			v = methodCall(pat, "__aget__", tmp(), vNode(x.Token.Text))
+		case shape == shapePair:
+			v = &Node{Kind: lookNode, Token: pat.Token}
+			v.Token.Text = []string{"left", "right"}[i]
+			v.Add(tmp())
+			v.Parent = pat
+		default:
+			v = methodCall(pat, "__aget__", tmp(), vNode(i))
+		}
+		u.compileUnpack(x, v, e, bind)
+	}
+	e.write(RETRACT, 1)
 }
 
 func (u *Unit) compileLookup(n *Node, e compilerCtx) {
-	var p uint16
+	var p uint32
 	var l int
 	s := n.Token.Text
 	if i := lookup(s, e.bound); i != -1 {
@@ -458,24 +729,64 @@ func (u *Unit) compileMutation(n *Node, e compilerCtx) {
 		ns := make([]*Node, len(n.Child[0].Child) + 1)
 		copy(ns, n.Child[0].Child)
 		ns[len(ns)-1] = n.Child[1]
-		m := &Node { Child: ns }
+		m := &Node { Token: n.Child[0].Token, Child: ns }
 		u.compileMethod(m, "__aset__", e)
 	case lookNode:
 		u.compileNode(n.Child[1], e)
 		e.write(PUSH)
 		u.compileNode(n.Child[0].Child[0], e)
+		u.compileSrc(n.Child[0], e)
 		nm := n.Child[0].Token.Text
 		e.write(SET, u.getAccessor(nm), e.static(nm))
 	case varNode:
 		u.compileNode(n.Child[1], e)
 		e.write(PUSH)
 		u.compileLookup(n.Child[0], e)
+		u.compileSrc(n.Child[0], e)
 		e.write(UPDATE)
+	case patNode:
+		u.compileUnpack(n.Child[0], n.Child[1], e, func(t, v *Node, e compilerCtx) {
+			m := &Node{Kind: mutNode, Token: n.Token}
+			m.Add(t, v)
+			u.compileMutation(m, e)
+		})
+	default:
+		file := n.Token.File
+		line := n.Token.Line
+		panic(fmt.Errorf("%s(%d): invalid location for writing", file, line))
+	}
+}
+
+// Compile an assignment such as x += 1, working out where x is only once.
+func (u *Unit) compileUpdate(n *Node, e compilerCtx) {
+	loc := n.Child[0]
+	k := 0
+	switch loc.Kind {
+	case varNode:
+	case lookNode, alookNode:
+		// the object and any indices are kept on the stack
+		k = len(loc.Child)
+		tmp := &Node{Kind: loc.Kind, Token: loc.Token}
+		for i, x := range loc.Child {
+			u.compileNode(x, e)
+			e.write(PUSH)
+			nm := fmt.Sprintf("@upd%d", i)
+			e.bound = append(hideVars(e.bound, nm), nm)
+			tmp.Add(tNode(varNode, nm))
+		}
+		loc = tmp
 	default:
 		file := n.Token.File
 		line := n.Token.Line
 		panic(fmt.Errorf("%s(%d): invalid location for writing", file, line))
 	}
+	get := *loc
+	m := &Node{Kind: mutNode, Token: n.Token}
+	m.Add(loc, methodCall(n, updateOps[n.Token.Text], &get, n.Child[1]))
+	u.compileMutation(m, e)
+	if k != 0 {
+		e.write(RETRACT, k)
+	}
 }
 
 func (u *Unit) compileLog(n *Node, e compilerCtx) {
@@ -508,6 +819,475 @@ func (u *Unit) compileIf(n *Node, e compilerCtx) {
 	jpos.place(e)
 }
 
+func (u *Unit) compileWhile(n *Node, e compilerCtx) {
+	l := &loopCtx{depth: len(e.bound), start: len(*e.block) + e.offset}
+	e.loop = l
+	// while <expr>
+	u.compileNode(n.Child[0], e)
+	l.exits = append(l.exits, e.write(BRANCH, 0))
+	// do <block>
+	u.compileBlock(n.Child[1].Child, e)
+	e.write(JUMP, l.start)
+	for _, x := range l.exits {
+		x.place(e)
+	}
+	e.write(VALUE, 0)
+}
+
+func (u *Unit) compileFor(n *Node, e compilerCtx) {
+	t := n.Token
+	nm := n.Child[0].Token.Text
+	// the iterator and then the current item are kept on the stack, hiding
+	// those of any enclosing loop
+	d := len(e.bound)
+	u.compileNode(methodCall(n, "__iter__", n.Child[1]), e)
+	e.write(PUSH)
+	e.bound = append(hideVars(e.bound, "@iter", "@item", nm), "@iter")
+	l := &loopCtx{depth: d+1, start: len(*e.block) + e.offset}
+	e.loop = l
+	u.compileNode(methodCall(n, "next", tNode(varNode, "@iter")), e)
+	e.write(PUSH)
+	e.bound = append(e.bound, "@item")
+	done := &Node{Kind: varNode, Token: t}
+	done.Token.Text = "done"
+	u.compileNode(methodCall(n, "__eq__", done, tNode(varNode, "@item")), e)
+	bpos := e.write(BRANCH, 0)
+	e.write(RETRACT, 1)
+	l.exits = append(l.exits, e.write(JUMP, 0))
+	// the item becomes the loop variable
+	bpos.place(e)
+	e.write(BOX, d+1)
+	e.bound[d+1] = nm
+	e.boxed = append(e.boxed[:len(e.boxed):len(e.boxed)], nm)
+	u.compileBlock(n.Child[2].Child, e)
+	e.write(RETRACT, 1)
+	e.write(JUMP, l.start)
+	for _, x := range l.exits {
+		x.place(e)
+	}
+	e.write(RETRACT, 1)
+	e.write(VALUE, 0)
+}
+
+// Copy a list of variables, leaving out some names.
+func hideVars(vs []string, ns... string) []string {
+	res := make([]string, len(vs))
+	for i, x := range vs {
+		if lookup(x, ns) == -1 {
+			res[i] = x
+		}
+	}
+	return res
+}
+
+// Call a method on behalf of a node.
+func methodCall(n *Node, m string, args... *Node) *Node {
+	t := n.Token
+	t.Text = m
+	c := &Node{Kind: callNode, Token: n.Token}
+	c.Add((&Node{Kind: lookNode, Token: t}).Add(args[0]))
+	c.Add(args[1:]...)
+	c.Parent = n
+	return c
+}
+
+func (u *Unit) compileBreak(n *Node, e compilerCtx) {
+	l := e.loop
+	if l == nil {
+		panic(Unexpected(n.Token))
+	}
+	u.leaveTry(e, false)
+	// leave any blocks inside the loop
+	if k := len(e.bound) - l.depth; k != 0 {
+		e.write(RETRACT, k)
+	}
+	if n.Kind == contNode {
+		e.write(JUMP, l.start)
+	} else {
+		l.exits = append(l.exits, e.write(JUMP, 0))
+	}
+}
+
+func (u *Unit) compileTry(n *Node, e compilerCtx) {
+	var fs []*Node
+	if n.Child[2] != nil {
+		fs = n.Child[2].Child
+	}
+	outer := e
+	d := len(e.bound)
+	var ends []compilerSym
+	// errors that no clause handles, including those that the host raises to
+	// stop the code, run the finally block on their way out
+	var rpos compilerSym
+	handlers := 1
+	if fs != nil {
+		rpos = e.write(FINALLY, 0)
+		handlers++
+	}
+	// try <block>
+	hpos := e.write(TRY, 0)
+	e.try = &tryCtx{fs, handlers, e.loop, outer.try}
+	u.compileBlock(n.Child[0].Child, e)
+	for i := 0; i < handlers; i++ {
+		e.write(UNTRY)
+	}
+	if fs != nil {
+		u.compileBlock(fs, outer)
+	}
+	ends = append(ends, e.write(JUMP, 0))
+	// the error is kept on the stack while it is handled
+	hpos.place(e)
+	e.write(PUSH)
+	e.write(BOX, d)
+	e.bound = append(hideVars(e.bound, "@err"), "@err")
+	e.boxed = append(e.boxed[:len(e.boxed):len(e.boxed)], "@err")
+	// finally has to run even if a handler fails
+	e.try = outer.try
+	if fs != nil {
+		e.try = &tryCtx{fs, 1, e.loop, outer.try}
+	}
+	fe := e
+	fe.try = outer.try
+	// catch <name>: <class> <block>
+	for _, c := range n.Child[1].Child {
+		var next compilerSym
+		if c.Child[1] != nil {
+			u.compileNode(methodCall(c, "is", tNode(varNode, "@err"), c.Child[1]), e)
+			next = e.write(BRANCH, 0)
+		}
+		nm := c.Child[0].Token.Text
+		ce := e
+		ce.bound = hideVars(e.bound, nm)
+		ce.bound[d] = nm
+		ce.boxed = append(e.boxed[:len(e.boxed):len(e.boxed)], nm)
+		u.compileBlock(c.Child[2].Child, ce)
+		if fs != nil {
+			e.write(UNTRY)
+			u.compileBlock(fs, fe)
+		}
+		e.write(RETRACT, 1)
+		ends = append(ends, e.write(JUMP, 0))
+		if c.Child[1] == nil {
+			break
+		}
+		next.place(e)
+	}
+	// no handler matched
+	e.write(BOUND, d)
+	e.write(UNBOX)
+	e.write(THROW)
+	if fs != nil {
+		rpos.place(e)
+		e.write(PUSH)
+		re := outer
+		re.bound = append(outer.bound[:d:d], "@rethrow")
+		u.compileBlock(fs, re)
+		e.write(BOUND, d)
+		e.write(RETRACT, 1)
+		e.write(THROW)
+	}
+	for _, x := range ends {
+		x.place(e)
+	}
+	e.write(VALUE, 0)
+}
+
+// Remove the handlers set up by try statements that control is leaving, and
+// run their finally blocks, innermost first. If all is false, only those
+// inside the innermost loop are left.
+func (u *Unit) leaveTry(e compilerCtx, all bool) {
+	for t := e.try; t != nil && (all || t.loop == e.loop); t = t.outer {
+		for i := 0; i < t.handlers; i++ {
+			e.write(UNTRY)
+		}
+		if t.finally != nil {
+			fe := e
+			fe.try = t.outer
+			u.compileBlock(t.finally, fe)
+		}
+	}
+}
+
+func (u *Unit) compileMatch(n *Node, e compilerCtx) {
+	// the value is kept on the stack while the cases are tried
+	u.compileNode(n.Child[0], e)
+	e.write(PUSH)
+	e.bound = append(hideVars(e.bound, "@match"), "@match")
+	var ends []compilerSym
+	for _, c := range n.Child[1:] {
+		pat, guard, body := c.Child[0], c.Child[1], c.Child[2]
+		// case <pattern>
+		bound := caseVars(pat)
+		checkUniq(bound)
+		l := len(e.bound)
+		for i, x := range bound {
+			u.compileVal(&Node{Data: Wrap(x)}, e)
+			e.write(PUSH)
+			e.write(UNDEFINE, l+i)
+		}
+		ce := e
+		ce.bound = append(hideVars(e.bound, bound...), bound...)
+		ce.boxed = append(e.boxed[:len(e.boxed):len(e.boxed)], bound...)
+		f := &failCtx{depth: len(ce.bound)}
+		u.compileCase(pat, tNode(varNode, "@match"), ce, f)
+		// if <guard>
+		if guard != nil {
+			u.compileNode(guard, ce)
+			f.fail(ce)
+		}
+		// then <block>
+		u.compileBlock(body.Child, ce)
+		e.write(RETRACT, len(bound))
+		ends = append(ends, e.write(JUMP, 0))
+		f.place(ce)
+		e.write(RETRACT, len(bound))
+	}
+	// no case applied
+	u.compileSrc(n, e)
+	fail := tNode(varNode, "MatchError")
+	fail = kNode(callNode).Add(fail, tNode(varNode, "@match"))
+	u.compileNode(kNode(callNode).Add(tNode(varNode, "throw"), fail), e)
+	for _, x := range ends {
+		x.place(e)
+	}
+	e.write(RETRACT, 1)
+	e.write(VALUE, 0)
+}
+
+// Go to the next case if the test just made came out false.
+func (f *failCtx) fail(e compilerCtx) {
+	d := len(e.bound) - f.depth
+	for len(f.exits) <= d {
+		f.exits = append(f.exits, nil)
+	}
+	f.exits[d] = append(f.exits[d], e.write(BRANCH, 0))
+}
+
+// Clear up after a failed test. Control only reaches here by failing.
+func (f *failCtx) place(e compilerCtx) {
+	for d := len(f.exits)-1; d >= 0; d-- {
+		for _, x := range f.exits[d] {
+			x.place(e)
+		}
+		if d != 0 {
+			e.write(RETRACT, 1)
+		}
+	}
+}
+
+// The items of an array literal, if n is one (see arrParser).
+func arrayItems(n *Node) ([]*Node, bool) {
+	if n.Kind != callNode || n.Token.Text != "[" || len(n.Child) != 1 ||
+	   n.Child[0].Kind != fnNode {
+		return nil, false
+	}
+	return n.Child[0].Child[2].Child[1:], true
+}
+
+// The variables a case pattern binds. Anything that is not a name, "_" or a
+// shape of some kind is a value to compare with.
+func caseVars(pat *Node) []string {
+	var res []string
+	switch pat.Kind {
+	case varNode:
+		nm := pat.Token.Text
+		if c := nm[0]; c >= 'A' && c <= 'Z' {
+			// most likely a class, which the variable would hide
+			panic(fmt.Errorf("%s(%d): capitalised name in a pattern: %s, use %s() to match its instances",
+				pat.Token.File, pat.Token.Line, nm, nm))
+		}
+		if nm != "_" {
+			res = append(res, nm)
+		}
+	case callNode:
+		if alts := caseAlts(pat); alts != nil {
+			res = caseVars(alts[0])
+			want := varSet(res)
+			for _, x := range alts[1:] {
+				if varSet(caseVars(x)) != want {
+					panic(fmt.Errorf("%s(%d): alternatives bind different variables",
+						pat.Token.File, pat.Token.Line))
+				}
+			}
+			break
+		}
+		xs, ok := arrayItems(pat)
+		if !ok {
+			switch pat.Token.Text {
+			case "{", ":", "(":
+				xs = pat.Child[1:]
+			}
+		}
+		for _, x := range xs {
+			switch {
+			case x.Kind == restNode || x.Kind == kwNode:
+				x = x.Child[0]
+			case pat.Token.Text == "{" && x.Kind == callNode && x.Token.Text == ":":
+				x = x.Child[2]
+			}
+			res = append(res, caseVars(x)...)
+		}
+	}
+	return res
+}
+
+// The alternatives of an either pattern, which is written as a bitwise or.
+func caseAlts(pat *Node) []*Node {
+	if pat.Kind != callNode || pat.Token.Text != "|" || len(pat.Child) != 2 {
+		return nil
+	}
+	left := pat.Child[0].Child[0]
+	res := caseAlts(left)
+	if res == nil {
+		res = []*Node{left}
+	}
+	return append(res, pat.Child[1])
+}
+
+func varSet(vs []string) string {
+	res := append([]string{}, vs...)
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+// Test the value given by val against a case pattern, binding variables to
+// parts of it. val may be evaluated several times.
+func (u *Unit) compileCase(pat, val *Node, e compilerCtx, f *failCtx) {
+	switch pat.Kind {
+	case varNode:
+		if pat.Token.Text != "_" {
+			u.compileNode(val, e)
+			e.write(PUSH)
+			e.write(BOUND, lookup(pat.Token.Text, e.bound))
+			e.write(DEFINE)
+			e.write(UPDATE)
+		}
+		return
+	case callNode:
+		if alts := caseAlts(pat); alts != nil {
+			// <pattern> | <pattern> ...
+			var ends []compilerSym
+			for _, x := range alts[:len(alts)-1] {
+				af := &failCtx{depth: len(e.bound)}
+				u.compileCase(x, val, e, af)
+				ends = append(ends, e.write(JUMP, 0))
+				af.place(e)
+			}
+			u.compileCase(alts[len(alts)-1], val, e, f)
+			for _, x := range ends {
+				x.place(e)
+			}
+			return
+		}
+		if xs, ok := arrayItems(pat); ok {
+			u.compileShape(pat, xs, val, e, f)
+			return
+		}
+		switch pat.Token.Text {
+		case "{", ":":
+			u.compileShape(pat, pat.Child[1:], val, e, f)
+			return
+		case "(":
+			u.compileInstance(pat, val, e, f)
+			return
+		}
+	}
+	u.compileNode(methodCall(pat, "__eq__", val, pat), e)
+	f.fail(e)
+}
+
+// Hold on to a value while testing its parts.
+func (u *Unit) caseTemp(pat, val *Node, e compilerCtx) (compilerCtx, *Node) {
+	u.compileNode(val, e)
+	e.write(PUSH)
+	e.bound = append(hideVars(e.bound, "@case"), "@case")
+	x := &Node{Kind: varNode, Token: pat.Token}
+	x.Token.Text = "@case"
+	return e, x
+}
+
+// Test an array, hash or pair pattern.
+func (u *Unit) compileShape(pat *Node, xs []*Node, val *Node, e compilerCtx, f *failCtx) {
+	e, val = u.caseTemp(pat, val, e)
+	shape, l := shapeHash, len(xs)
+	switch pat.Token.Text {
+	case "[":
+		shape = shapeArray
+		if l != 0 && xs[l-1].Kind == restNode {
+			shape = shapeArrayRest
+			l--
+		}
+	case ":":
+		shape = shapePair
+	}
+	u.compileNode(val, e)
+	e.write(MATCH, shape, l)
+	f.fail(e)
+	for i, x := range xs {
+		var v *Node
+		switch {
+		case x.Kind == restNode:
+			v = methodCall(pat, "slice", val, vNode(i))
+			x = x.Child[0]
+		case shape == shapeHash:
+			key := vNode(x.Token.Text)
+			if x.Kind == callNode && x.Token.Text == ":" {
+				key, x = x.Child[1], x.Child[2]
+			} else if x.Kind != varNode {
+				panic(Unexpected(x.Token))
+			}
+			u.compileNode(methodCall(pat, "contains", val, key), e)
+			f.fail(e)
+			v = methodCall(pat, "__aget__", val, key)
+		case shape == shapePair:
+			v = &Node{Kind: lookNode, Token: pat.Token}
+			v.Token.Text = []string{"left", "right"}[i]
+			v.Add(val)
+			v.Parent = pat
+		default:
+			v = methodCall(pat, "__aget__", val, vNode(i))
+		}
+		u.compileCase(x, v, e, f)
+	}
+	e.write(RETRACT, 1)
+}
+
+// Test a class pattern. Positional parts match the public fields of the class
+// in order, and keyword parts match properties by name.
+func (u *Unit) compileInstance(pat, val *Node, e compilerCtx, f *failCtx) {
+	cls := pat.Child[0]
+	e, val = u.caseTemp(pat, val, e)
+	u.compileNode(methodCall(pat, "is", val, cls), e)
+	f.fail(e)
+	var xs []*Node
+	for _, x := range pat.Child[1:] {
+		if x.Kind == kwNode {
+			v := &Node{Kind: lookNode, Token: x.Token}
+			v.Add(val)
+			v.Parent = pat
+			u.compileCase(x.Child[0], v, e, f)
+		} else {
+			xs = append(xs, x)
+		}
+	}
+	if len(xs) != 0 {
+		u.compileNode(cls, e)
+		e.write(PUSH)
+		u.compileNode(val, e)
+		u.compileSrc(pat, e)
+		e.write(FIELDS, len(xs))
+		e.write(PUSH)
+		fe := e
+		fe.bound = append(hideVars(e.bound, "@fields"), "@fields")
+		for i, x := range xs {
+			v := methodCall(pat, "__aget__", tNode(varNode, "@fields"), vNode(i))
+			u.compileCase(x, v, fe, f)
+		}
+		e.write(RETRACT, 1)
+	}
+	e.write(RETRACT, 1)
+}
+
 func (u *Unit) compileVal(n *Node, e compilerCtx) {
 	e.write(VALUE, u.getVal(n.Data.(*Object)))
 }
@@ -518,21 +1298,31 @@ func (u *Unit) compileFn(n *Node, e compilerCtx) {
 	// prepare the environment
 	bound := nodeStrs(args.Child)
 	checkUniq(bound)
-	freeNodes := closedVars(body, e)
+	code := append(defaultVals(args), body...)
+	freeNodes := closedVars(code, e)
 	free := nodeStrs(freeNodes)
-	boxed := boxedVars(body, bound, e)
-	f := compilerCtx{bound, free, boxed, e.class, new([]uint16), 0}
+	boxed := boxedVars(code, bound, e)
+	f := compilerCtx{bound, free, boxed, e.class, new([]uint32), 0, new([]srcPos), nil, nil}
+	u.compileSrc(n, f)
 	u.compileProlog(args, f)
-	if len(body) != 0 {
-		u.writeSrc(body[0], f)
+	if !isImmediate(n) && isGenerator(body) {
+		f.write(GENERATE)
 	}
 	// compile the function body
 	u.compileBlock(body, f)
 	f.write(VALUE, 0)
 	f.write(RETURN)
+	if u.opt > 0 {
+		*f.block, *f.src = u.peephole(*f.block, 0, *f.src)
+	}
 	// store the block
 	ix := len(u.b)
 	u.b = append(u.b, *f.block)
+	for len(u.fn) < ix {
+		u.fn = append(u.fn, "")
+	}
+	u.fn = append(u.fn, fnName(n))
+	u.addSrc(ix, *f.src)
 	// emit closure code
 	for _, x := range freeNodes {
 		u.compileLookup(x, e)
@@ -541,21 +1331,119 @@ func (u *Unit) compileFn(n *Node, e compilerCtx) {
 	e.write(CLOSE, ix, len(free))
 }
 
+// Whether a function body yields, outside of any functions defined inside it.
+func isGenerator(body []*Node) bool {
+	res := false
+	for _, x := range body {
+		x.Scan(func(n *Node) bool {
+			if n.Kind == yieldNode {
+				res = true
+			}
+			return n.Kind != fnNode || isImmediate(n)
+		})
+	}
+	return res
+}
+
+// The generator function a yield belongs to, if any. 
+func yieldsFrom(n *Node) *Node {
+	for cur := n; cur.Parent != nil; cur = cur.Parent {
+		f := cur.Parent
+		if f.Kind == fnNode && !isImmediate(f) {
+			if cur == f.Child[0] {
+				// default values are worked out before the generator starts
+				return nil
+			}
+			return f
+		}
+	}
+	return nil
+}
+
+// Whether a function is called as soon as it is defined, as happens with array
+// literals. Such a function yields on behalf of the one it is in.
+func isImmediate(n *Node) bool {
+	p := n.Parent
+	return p != nil && p.Kind == callNode && p.Child[0] == n
+}
+
+// The name a function is defined with, if any. A function called as soon as it
+// is defined goes by the name of the one it is in.
+func fnName(n *Node) string {
+	if isImmediate(n) {
+		for cur := n.Parent; cur != nil; cur = cur.Parent {
+			if cur.Kind == fnNode {
+				return fnName(cur)
+			}
+		}
+		return ""
+	}
+	p := n.Parent
+	if p != nil && p.Kind == invalidNode {
+		// property accessors
+		p = p.Parent
+	}
+	if p == nil || len(p.Child) < 2 || p.Child[0] == n {
+		return ""
+	}
+	switch p.Kind {
+	case varNode, fnNode, propNode:
+		return p.Child[0].Token.Text
+	}
+	return ""
+}
+
+// The expressions giving the default values of a function's arguments.
+func defaultVals(args *Node) []*Node {
+	var res []*Node
+	for _, x := range args.Child {
+		if len(x.Child) != 0 {
+			res = append(res, x.Child[0])
+		}
+	}
+	return res
+}
+
 func (u *Unit) compileProlog(args *Node, f compilerCtx) {
-	var desc fdesc
+	var desc FnArgs
 	if args.Data != nil {
-		desc = args.Data.(fdesc)
+		desc = args.Data.(FnArgs)
 	}
-	l := len(args.Child)
-	if desc.rest {
-		f.write(PROLOG_REST, l-desc.opt-1, l-1)
-	} else if desc.opt != 0 {
-		f.write(PROLOG_OPT, l-desc.opt, l)
-	} else {
-		f.write(PROLOG, l)
+	names := nodeStrs(args.Child)
+	if len(names) == 0 {
+		f.write(PROLOG, 0)
+		return
+	}
+	// arguments may be passed by keyword, so the prolog needs their names
+	m, flags := len(names), 0
+	if desc.Kw {
+		m--
+		flags |= 2
 	}
-	for i, x := range nodeStrs(args.Child) {
-		if f.isBoxed(x) {
+	if desc.Rest {
+		m--
+		flags |= 1
+	}
+	ops := []int{m-desc.Opt, m, flags}
+	for _, x := range names[:m] {
+		ops = append(ops, u.getAccessor(x))
+	}
+	f.write(PROLOG_KW, ops...)
+	for i, x := range args.Child {
+		if i >= m-desc.Opt && i < m {
+			// a default value may refer to the arguments before it
+			skip := f.write(DEFAULT, 0, i)
+			d := f
+			d.bound = hideVars(f.bound, names[i:]...)
+			if len(x.Child) == 0 {
+				u.compileVal(&Node{Data: False}, d)
+			} else {
+				u.compileNode(x.Child[0], d)
+			}
+			f.write(BIND, i)
+			skip.place(f)
+		}
+		if f.isBoxed(names[i]) {
 			f.write(BOX, i)
 		}
 	}
@@ -569,12 +1457,13 @@ func (u *Unit) compileBlock(n []*Node, e compilerCtx) {
 	for _, x := range n {
 		if x.Kind == defNode {
 			for _, y := range x.Child {
-				name := y.Child[0].Token.Text
-				p := lookup(name, outer)
-				if p != -1 {
-					outer[p] = ""
+				for _, name := range patternVars(y.Child[0]) {
+					p := lookup(name, outer)
+					if p != -1 {
+						outer[p] = ""
+					}
+					bound = append(bound, name)
 				}
-				bound = append(bound, name)
 			}
 		}
 	}
@@ -599,6 +1488,13 @@ func (u *Unit) compileCall(n *Node, loc func(), e compilerCtx) {
 		fpos = e.write(FRAME, 0)
 	}
 	as := n.Child[1:]
+	// keyword arguments follow the others
+	var kws []int
+	for _, x := range as {
+		if x.Kind == kwNode {
+			kws = append(kws, u.getAccessor(x.Token.Text))
+		}
+	}
 	u.compileArgs(as, e)
 	if loc != nil {
 		loc()
@@ -619,10 +1515,15 @@ func (u *Unit) compileCall(n *Node, loc func(), e compilerCtx) {
 			u.compileNode(m, e)
 		}
 	}
+	u.compileSrc(n, e)
 	if t {
 		e.write(SHUFFLE, len(as))
 	}
-	e.write(CALL, len(as))
+	if kws != nil {
+		e.write(CALLK, append([]int{len(as)-len(kws), len(kws)}, kws...)...)
+	} else {
+		e.write(CALL, len(as))
+	}
 	if !t {
 		fpos.place(e)
 	}
@@ -641,6 +1542,14 @@ func (u *Unit) compileRet(n *Node, e compilerCtx) {
 		panic(Unexpected(n.Token))
 	}
 	u.compileNode(n.Child[0], e)
+	if e.try != nil {
+		// hold on to the result while leaving try statements
+		e.write(PUSH)
+		d := len(e.bound)
+		e.bound = append(e.bound[:d:d], "@ret")
+		u.leaveTry(e, true)
+		e.write(BOUND, d)
+	}
 	e.write(RETURN)
 }
 
@@ -649,15 +1558,29 @@ func (u *Unit) compileClass(n *Node, e compilerCtx) {
 	e.class = ns
 	u.compileVal(&Node{Data: new(skelObj).init(es)}, e)
 	e.write(PUSH)
-	if n.Child[2] == nil {
+	ts := n.Child[3].Child
+	switch {
+	case n.Data == true:
+		// the members of a trait are only found by name, as where they are
+		// depends on what the trait is mixed into
+		e.class = nil
+		u.compileArgs(ts, e)
+		e.write(TRAIT, len(ts))
+	case n.Child[2] == nil:
 		e.write(GLOBAL, u.getGlobal("Object"))
 		e.write(UNBOX)
-	} else {
+	default:
+		// the ancestor may turn out to be a trait, so always mix in
 		u.compileNode(n.Child[2], e)
+		e.write(PUSH)
+		u.compileArgs(ts, e)
+		e.write(MIXIN, len(ts))
 	}
-	if n.Child[1] == nil {
+	switch {
+	case n.Data == true:
+	case n.Child[1] == nil:
 		e.write(EXTENDA, u.getAccessor(""))
-	} else {
+	default:
 		e.write(EXTEND)
 	}
 	i, l := 1, 0
@@ -703,31 +1626,44 @@ func (u *Unit) compileSpec(n *Node, e compilerCtx) ([]string, []Slot, []*Node) {
 	names := []string{}
 	spec := []*Node{}
 	es := []Slot{{Name: name}}
-	for _, d := range n.Child[3:] {
+	trait := n.Data == true
+	for _, d := range n.Child[4:] {
 		for _, x := range d.Child {
-			names = append(names, x.Child[0].Token.Text)
-			k := Field
-			switch x.Kind {
-			case fnNode:
-				k = Method
-			case propNode:
-				k = Property
+			if x.Child[0].Kind == patNode {
+				panic(Unexpected(x.Child[0].Token))
 			}
 			t := x.Child[0].Token
+			if trait && x.Data.(SlotVis) == Private {
+				panic(fmt.Errorf("private member in trait: %s.%s", name, t.Text))
+			}
+			names = append(names, t.Text)
+			f := Flags(Field, x.Data.(SlotVis))
+			switch {
+			case x.Kind == fnNode && x.Child[1] == nil:
+				// required by a trait
+				f = Flags(Method, Public) | abstractSlot
+			case x.Kind == fnNode:
+				f = Flags(Method, x.Data.(SlotVis))
+			case x.Kind == propNode:
+				f = Flags(Property, x.Data.(SlotVis))
+			}
 			es = append(es, Slot {
-				Flags: Flags(k, x.Data.(SlotVis)),
-				access: uint16(u.getAccessor(t.Text)),
-				next: uint16(e.static(t.Text)),
+				Flags: f,
+				access: operand(u.getAccessor(t.Text)),
+				next: operand(e.static(t.Text)),
 			})
 			spec = append(spec, x.Child[1])
 		}
 	}
 	checkUniq(names)
+	if trait {
+		return names, es, spec
+	}
 	for i, x := range e.class {
 		es = append(es, Slot{
 			Flags: Flags(Marker, Private),
-			access: uint16(u.getAccessor(x)),
-			next: uint16(i),
+			access: operand(u.getAccessor(x)),
+			next: operand(i),
 		})
 		names = append(names, x)
 	}
@@ -739,6 +1675,7 @@ func (u *Unit) compileLook(n *Node, e compilerCtx) {
 		panic(Unexpected(n.Token))
 	}
 	u.compileNode(n.Child[0], e)
+	u.compileSrc(n, e)
 	t := n.Token
 	e.write(GET, u.getAccessor(t.Text), e.static(t.Text))
 }
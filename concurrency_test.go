@@ -0,0 +1,144 @@
+package ts_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+// These tests are meant to be run with the race detector, as in
+// "go test -race".
+
+// Many processes spawned from a script define classes and globals, and share a
+// hash and, under a mutex, an array.
+func TestSpawn(t *testing.T) {
+	i := ts.New()
+	x, err := run(t, i, 20*time.Second, `
+		def sync = loadExtension("sync");
+		def finished = sync.Channel();
+		def lock = sync.Mutex();
+		def results = [];
+		def seen = {};
+		def start(n)
+			sync.spawn(fn()
+				class Point()
+					def create(x)
+						this.x = x;
+					end;
+					def x;
+				end;
+				eval("def spawned" + n.toString() + " = " + n.toString() + ";");
+				seen[n] = Point(n).x;
+				lock.with(fn() results.push(n); end);
+				finished.send(n);
+			end);
+		end;
+		def startAll(n)
+			if n < 50 then
+				start(n);
+				startAll(n + 1);
+			end;
+		end;
+		def finishAll(n)
+			if n < 50 then
+				finished.receive();
+				finishAll(n + 1);
+			end;
+		end;
+		startAll(0);
+		finishAll(0);
+		[results.size, seen.size, seen[7], spawned7];
+	`, ts.Limits{})
+	expect(t, "spawn", x, err, "[50, 50, 7, 7]")
+}
+
+// Interpreters may be created while others are running code.
+func TestNewWhileRunning(t *testing.T) {
+	const src = `
+		def sum(n)
+			if n == 0 then
+				return 0;
+			end;
+			return sum(n - 1) + [n, n].size + "ab".size + n - 1;
+		end;
+		sum(200);
+	`
+	var wg sync.WaitGroup
+	for j := 0; j < 8; j++ {
+		wg.Add(1)
+		go func(j int) {
+			defer wg.Done()
+			var i *ts.Interpreter
+			if j%2 == 0 {
+				i = ts.New()
+			} else {
+				i = ts.NewSandboxed(ts.Sandbox{
+					Builtins: []string{"done", "catch", "throw"},
+				})
+			}
+			x, err := run(t, i, 20*time.Second, src, ts.Limits{})
+			expect(t, "new", x, err, "20700")
+		}(j)
+	}
+	wg.Wait()
+}
+
+// Limits may be changed while code is running.
+func TestSetLimitsWhileRunning(t *testing.T) {
+	i := ts.New()
+	var wg sync.WaitGroup
+	for j := 0; j < 4; j++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 20; k++ {
+				i.SetLimits(ts.Limits{Instructions: int64(1000000 + k)})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 20; k++ {
+				x, err := run(t, i, 20*time.Second, "[1, 2].size;", ts.Limits{})
+				expect(t, "limits", x, err, "2")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Globals may be defined and read from Go while scripts define their own.
+func TestDefineWhileRunning(t *testing.T) {
+	i := ts.New()
+	i.Define("shared", ts.Wrap(0))
+	var wg sync.WaitGroup
+	for j := 0; j < 4; j++ {
+		wg.Add(2)
+		go func(j int) {
+			defer wg.Done()
+			for k := 0; k < 50; k++ {
+				n := fmt.Sprintf("host%d_%d", j, k)
+				i.Define(n, ts.Wrap(k))
+				if !i.Defined(n) || i.Get(n).ToInt() != int64(k) {
+					t.Errorf("%s: not defined", n)
+				}
+				i.Set("shared", ts.Wrap(k))
+				i.Get("shared")
+				i.ListDefined()
+			}
+		}(j)
+		go func(j int) {
+			defer wg.Done()
+			for k := 0; k < 20; k++ {
+				src := fmt.Sprintf("def script%d_%d = %d; script%d_%d + 1;", j, k, k, j, k)
+				x, err := run(t, i, 20*time.Second, src, ts.Limits{})
+				expect(t, "define", x, err, fmt.Sprint(k+1))
+			}
+		}(j)
+	}
+	wg.Wait()
+	if !i.Defined("host3_49") || !i.Defined("script3_19") {
+		t.Error("globals missing")
+	}
+}
@@ -0,0 +1,363 @@
+package ts
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+/*******************************************************************************
+
+	Controlling running code
+
+*******************************************************************************/
+
+// Governs the code run by a call to ExecLimits(): the process the call starts,
+// the processes that Go code starts on its behalf and those on goroutines
+// started with Go(). Calls may nest, in which case the enclosing controls also
+// apply.
+//
+// Go code is told which control it is working for by the context it is given,
+// which is the control itself. It passes that context on when it calls back
+// into the interpreter.
+type control struct {
+	ctx context.Context // that the control was made for
+	done <-chan struct{}
+	parent *control
+	lim Limits
+	stack, frames int // the most the code may use, counting nested processes
+	depth, calls int // the stack and frames of the processes it is nested in
+	nested bool // whether a process made it for the code that it calls
+	counting bool // whether this control or an enclosing one limits allocation
+	insns, allocs atomic.Int64
+}
+
+// How many instructions a process runs between checks on its control.
+const pollInterval = 1024
+
+// The key under which a context carries its control.
+type controlKey struct{}
+
+// The control that a context carries, or nil.
+func controlIn(ctx context.Context) *control {
+	c, _ := ctx.Value(controlKey{}).(*control)
+	return c
+}
+
+// The control for code that Go code runs on behalf of ctx. A context that may
+// be cancelled, but does not belong to a control, gets one of its own within
+// any control it was made from.
+func controlFor(ctx context.Context) *control {
+	c := controlIn(ctx)
+	if ctx.Done() == nil || c != nil && ctx.Done() == c.done {
+		return c
+	}
+	return newControl(ctx, c, Limits{})
+}
+
+// Make a control for code running under ctx, within some limits and those of
+// the control it is nested in, if any.
+func newControl(ctx context.Context, parent *control, l Limits) *control {
+	c := &control{ctx: ctx, done: ctx.Done(), parent: parent, lim: l}
+	c.stack, c.frames = l.Stack, l.Frames
+	c.counting = l.Allocs != 0
+	if parent != nil {
+		c.depth, c.calls = parent.depth, parent.calls
+		if c.stack != 0 {
+			c.stack += c.depth
+		}
+		if c.frames != 0 {
+			c.frames += c.calls
+		}
+		c.stack = minLimit(c.stack, parent.stack)
+		c.frames = minLimit(c.frames, parent.frames)
+		c.counting = c.counting || parent.counting
+	}
+	return c
+}
+
+func (i *Interpreter) controlled(ctx context.Context, u *Unit, l Limits) *Object {
+	parent := controlIn(ctx)
+	if parent == nil {
+		l = l.within(i.getLimits())
+	}
+	// a context carrying a control was made from that control, so the
+	// enclosing context still applies
+	c := newControl(ctx, parent, l)
+	c.check()
+	return i.exec(u, c)
+}
+
+// Run a function on a new goroutine, under the control carried by ctx. Code
+// that the function runs in the interpreter, passing on the context it is
+// given, stops when the code that ctx governs is asked to stop, and counts
+// against its limits. Extensions should start goroutines that call back into
+// the interpreter this way: the context given to a Go function by the
+// interpreter is only good for the length of the call, as it records how deeply
+// the call is nested.
+//
+// When the code is asked to stop, goes beyond its limits or calls exit() in a
+// sandbox, only the function stops. The channel returned receives the error
+// that the function raised, or nil, once it has finished.
+func (i *Interpreter) Go(ctx context.Context, f func(ctx context.Context)) <-chan error {
+	if c := controlIn(ctx); c != nil {
+		// the goroutine has a stack of its own
+		t := newControl(c.ctx, c.owner(), Limits{})
+		t.depth, t.calls = 0, 0
+		ctx = t
+	}
+	res := make(chan error, 1)
+	go func() {
+		var err error
+		defer func() {
+			res <- err
+			close(res)
+		}()
+		defer catchError(&err)
+		f(ctx)
+	}()
+	return res
+}
+
+// The control that a nested control was made from.
+func (c *control) owner() *control {
+	if c.nested {
+		return c.parent
+	}
+	return c
+}
+
+// A control is the context of the code it governs.
+func (c *control) Deadline() (time.Time, bool) {
+	return c.ctx.Deadline()
+}
+
+func (c *control) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *control) Err() error {
+	return c.ctx.Err()
+}
+
+func (c *control) Value(key interface{}) interface{} {
+	if key == (controlKey{}) {
+		return c
+	}
+	return c.ctx.Value(key)
+}
+
+// Panic if the code that ctx governs ought to stop. Primitives that block
+// should select on ctx.Done() and call Check() when it is closed.
+func (i *Interpreter) Check(ctx context.Context) {
+	if c := controlFor(ctx); c != nil {
+		c.check()
+	}
+}
+
+// The context to give Go code that the process calls.
+func (p *process) ctx() context.Context {
+	if c := p.nested(); c != nil {
+		return c
+	}
+	return context.Background()
+}
+
+// The control for code that the process calls, which counts the stack and
+// frames that the process is using. The process keeps it, as it only lasts as
+// long as the call.
+func (p *process) nested() *control {
+	c := p.ctl
+	if c == nil || c.stack == 0 && c.frames == 0 {
+		return c
+	}
+	n := &p.sub
+	n.ctx, n.done, n.parent = c.ctx, c.done, c.owner()
+	n.stack, n.frames = c.stack, c.frames
+	// the call being made takes a place on the stack, even if it takes no
+	// arguments
+	n.depth, n.calls = c.depth + len(p.s) + 1, c.calls + len(p.frames)
+	n.nested, n.counting = true, c.counting
+	return n
+}
+
+// The tighter of two sets of limits.
+func (l Limits) within(m Limits) Limits {
+	return Limits{
+		Instructions: minLimit(l.Instructions, m.Instructions),
+		Stack: minLimit(l.Stack, m.Stack),
+		Frames: minLimit(l.Frames, m.Frames),
+		Allocs: minLimit(l.Allocs, m.Allocs),
+	}
+}
+
+func minLimit[T int | int64](a, b T) T {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// Panics if the code under c ought to stop.
+func (c *control) check() {
+	select {
+	case <-c.done:
+		panic(&stopped{context.Cause(c.ctx)})
+	default:
+	}
+}
+
+// Take up to n instructions from the budgets of c and its ancestors, returning
+// how many were granted. Panics if any of those budgets has run out, which it
+// then stays.
+func (c *control) take(n int64) int64 {
+	grant := n
+	for x := c; x != nil; x = x.parent {
+		max := x.lim.Instructions
+		if max == 0 {
+			continue
+		}
+		left := max - x.insns.Add(n) + n
+		if left <= 0 {
+			panic(&LimitError{"instructions", max})
+		}
+		if left < grant {
+			grant = left
+		}
+	}
+	c.give(n - grant)
+	return grant
+}
+
+// Return unused instructions to the budgets of c and its ancestors.
+func (c *control) give(n int64) {
+	for x := c; x != nil && n != 0; x = x.parent {
+		if x.lim.Instructions != 0 {
+			x.insns.Add(-n)
+		}
+	}
+}
+
+// Count n objects as allocated under c and its ancestors. Panics if that is
+// more than any of them allow.
+func (c *control) allocated(n int64) {
+	for x := c; x != nil && x.counting; x = x.parent {
+		max := x.lim.Allocs
+		if x.allocs.Add(n) > max && max != 0 {
+			panic(&LimitError{"allocations", max})
+		}
+	}
+}
+
+// Put the process under a control. Its stack and frames count along with those
+// of the processes it is nested in.
+func (p *process) control(c *control) {
+	p.ctl = c
+	p.maxStack, p.maxFrames = 0, 0
+	if c == nil {
+		return
+	}
+	if c.stack != 0 {
+		p.maxStack = c.stack - c.depth
+		if p.maxStack <= 0 {
+			panic(&LimitError{"stack", int64(c.stack)})
+		}
+	}
+	if c.frames != 0 {
+		p.maxFrames = c.frames - c.calls
+		if p.maxFrames <= 0 {
+			panic(&LimitError{"frames", int64(c.frames)})
+		}
+	}
+}
+
+// Called when a process has run out of instructions to run before it next
+// checks with its control.
+func (p *process) refuel() {
+	c := p.ctl
+	if c == nil {
+		p.fuel = pollInterval
+		return
+	}
+	c.check()
+	p.fuel = c.take(pollInterval)
+}
+
+// Give back any instructions the process has not used.
+func (p *process) release() {
+	if p.ctl != nil {
+		p.ctl.give(p.fuel)
+	}
+	p.fuel = 0
+}
+
+// Raised when the code under a control ought to stop. It wraps the cause given
+// by the control's context.
+type stopped struct {
+	err error
+}
+
+func (e *stopped) Error() string {
+	return e.err.Error()
+}
+
+func (e *stopped) Unwrap() error {
+	return e.err
+}
+
+// Whether a value raised in running code comes from the host asking the code
+// to stop, from the code going beyond its limits or from exit() in a sandbox,
+// rather than from an error in the code itself. Script handlers do not catch
+// these.
+func isHostError(e interface{}) bool {
+	var err error
+	switch v := e.(type) {
+	case *Object:
+		// a Go error is held by the Error that it becomes
+		if v.Is(ErrorClass) {
+			err, _ = v.data.(error)
+		}
+	case error:
+		err = v
+	}
+	if err == nil {
+		return false
+	}
+	var s *stopped
+	var l *LimitError
+	var x *ExitError
+	return errors.As(err, &s) || errors.As(err, &l) || errors.As(err, &x)
+}
+
+// Count an object the process has made against its control.
+func (p *process) allocatedOne() {
+	if c := p.ctl; c != nil && c.counting {
+		c.allocated(1)
+	}
+}
+
+// Return a value from a call into Go. The objects that Go code allocates
+// cannot be told apart from those that other code allocates at the same time,
+// so a call counts as making one object if it gives a new one.
+func (p *process) retGo(x *Object) {
+	if c := p.ctl; c != nil && c.counting && p.isNew(x) {
+		c.allocated(1)
+	}
+	p.ret(x)
+}
+
+// Whether an object may have been made by the Go function the process is
+// calling, rather than being given to it or shared.
+func (p *process) isNew(x *Object) bool {
+	switch x {
+	case nil, Nil, True, False, Done, p.t:
+		return false
+	}
+	for _, y := range p.s[p.b:] {
+		if x == y {
+			return false
+		}
+	}
+	return !isCached(x)
+}
@@ -0,0 +1,114 @@
+package ts_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+// Each call is governed by its own context and limits, even when the calls
+// share an interpreter.
+func TestControlPerCall(t *testing.T) {
+	i := ts.New()
+	const forever = "while true do end;"
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, err := run(t, i, 50*time.Millisecond, forever, ts.Limits{})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("with a deadline: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		x, err := run(t, i, 20*time.Second, `
+			def f()
+				def n = 0;
+				while n < 100000 do
+					n = n + 1;
+				end;
+				return n;
+			end;
+			f();
+		`, ts.Limits{})
+		expect(t, "without a deadline", x, err, "100000")
+	}()
+	wg.Wait()
+	_, err := run(t, i, 50*time.Millisecond, forever, ts.Limits{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("after another call: %v", err)
+	}
+}
+
+
+// Cancelling a call ends the tasks it spawned, rather than the program, and
+// waiting for such a task raises the cancellation.
+func TestSpawnCancelled(t *testing.T) {
+	i := ts.New()
+	u := new(ts.Unit)
+	err := u.CompileErr(strings.NewReader(`
+		def sync = loadExtension("sync");
+		def started = sync.Channel();
+		def task = sync.spawn(fn()
+			started.send(true);
+			while true do end;
+		end);
+		started.receive();
+	`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := i.ExecContext(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	// the spawned loop is still running
+	cancel()
+	x, err := run(t, i, 20*time.Second, "task.wait();", ts.Limits{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("waiting for the task: %v, %v", x, err)
+	}
+}
+
+// Errors raised by a spawned task are raised again by waiting for it, and may
+// be caught.
+func TestSpawnError(t *testing.T) {
+	i := ts.New()
+	x, err := run(t, i, 20*time.Second, `
+		def sync = loadExtension("sync");
+		def ok = sync.spawn(fn() return 1 + 2; end);
+		def bad = sync.spawn(fn() throw("oops"); end);
+		def caught = catch(fn() bad.wait(); end);
+		[ok.wait(), caught.msg];
+	`, ts.Limits{})
+	expect(t, "spawn error", x, err, "[3, oops]")
+}
+
+// Script code that Go code calls back into stays under the control of the call
+// that called the Go code.
+func TestCallbackCancelled(t *testing.T) {
+	i := ts.New()
+	_, err := run(t, i, 50*time.Millisecond, `
+		class Slow()
+			def __lt__(x)
+				while true do end;
+			end;
+		end;
+		sort([Slow(), Slow(), Slow()]);
+	`, ts.Limits{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("sorting: %v", err)
+	}
+	_, err = run(t, i, 50*time.Millisecond, `
+		def f() = f.apply([]);
+		f();
+	`, ts.Limits{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("applying: %v", err)
+	}
+}
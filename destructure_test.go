@@ -0,0 +1,49 @@
+package ts_test
+
+import (
+	"testing"
+)
+
+func TestDestructuring(t *testing.T) {
+	expectAll(t, []scriptTest{
+		{"array", "def [a, b, rest*] = [1, 2, 3, 4]; [a, b, rest];", "[1, 2, [3, 4]]"},
+		{"empty rest", "def [a, rest*] = [1]; [a, rest];", "[1, []]"},
+		{"pair", `def k : v = "x": 1; [k, v];`, "[x, 1]"},
+		{"hash", `def {name, age} = {"name": "Bob", "age": 42}; [name, age];`, "[Bob, 42]"},
+		{"nested", "def [a, [b, c]] = [1, [2, 3]]; [a, b, c];", "[1, 2, 3]"},
+		{"string", `def [a, b] = "xy"; [a, b];`, "[x, y]"},
+		{"call result", "def f() = [1, 2]; def [x, y] = f(); x + y;", "3"},
+		{"assign", "def a = 1, b = 2; [a, b] = [b, a]; [a, b];", "[2, 1]"},
+		{"variables", "def [a, b] = [1, 2]; a = 5; [a, b];", "[5, 2]"},
+		{"parameter", "def f([a, b], c) = a + b + c; f([1, 2], 3);", "6"},
+		{"callback", "map([1: 2, 3: 4], fn(k : v) = k + v);", "[3, 7]"},
+		{"for", "def r = []; for [a, b] in [[1, 2], [3, 4]] do r.push(b); end; r;", "[2, 4]"},
+		{"caught", `
+			def e = catch(fn() def [a, b] = 1; end);
+			e.msg;
+		`, "cannot destructure Integer: not a sequence"},
+		{"try", "def r;\ntry\n\tdef {x} = 5;\ncatch e\n\tr = e.line;\nend;\nr;", "3"},
+	})
+}
+
+func TestDestructuringErrors(t *testing.T) {
+	expectErrors(t, []scriptTest{
+		{"too many", "def [a, b] = [1, 2, 3];", "cannot destructure Array: 3 items, expected 2"},
+		{"too few", "def [a, b] = [1];", "cannot destructure Array: 1 items, expected 2"},
+		{"rest", "def [a, b*] = [];", "0 items, expected at least 1"},
+		{"not a sequence", "def [a] = 1;", "cannot destructure Integer: not a sequence"},
+		{"not a pair", `
+			def o = class()
+				def left = 1;
+				def right = 2;
+			end();
+			def l : r = o;
+		`, "cannot destructure Object: not a pair"},
+		{"not a hash", "def {a} = [1];", "not a keyed collection"},
+		{"missing key", `def {name} = {"age": 1};`, "missing value: name"},
+		{"parameter", "def f([a, b]) = a + b; f(1);", "cannot destructure Integer: not a sequence"},
+		{"arity", "def f([a, b]) = a + b; f();", "wrong number of arguments 0"},
+		{"undefined", "def x; [x, y] = [1, 2];", "undefined variable: y"},
+		{"hash syntax", `def {a: x} = {"a": 1};`, "expected , or }"},
+	})
+}
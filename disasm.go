@@ -0,0 +1,314 @@
+package ts
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	. "github.com/bobappleyard/ts/bytecode"
+)
+
+/*******************************************************************************
+
+	Unit inspection
+
+*******************************************************************************/
+
+// An instruction in one of a unit's blocks, with its operands decoded.
+type Instr struct {
+	Offset int // where the instruction starts in its block
+	Op uint32 // one of the opcodes in the bytecode package
+	Args []Operand
+	File string // where the instruction came from; Line is 0 if not known
+	Line, Col int
+}
+
+// An operand of an instruction. N is the operand as it appears in the code, and
+// what it refers to depends on the kind of operand.
+type Operand struct {
+	Kind OperandKind
+	N int
+}
+
+type OperandKind byte
+
+const (
+	CountOperand OperandKind = iota // a number of things, or flags
+	TargetOperand // an offset into the same block
+	ValueOperand // an index into Values()
+	GlobalOperand // an index into Globals()
+	AccessorOperand // an index into Accessors()
+	BlockOperand // another block, holding the code of a function
+	LocalOperand // a variable in the frame
+	FreeOperand // a variable captured by a closure
+	SlotOperand // a static slot in the enclosing class, or -1 if there is none
+	ShapeOperand // the kind of pattern a value is taken apart with
+)
+
+// What the operands of each instruction refer to. The extra operands of CALLK
+// and PROLOG_KW are accessors.
+var operandKinds = [...][]OperandKind {
+	JUMP: {TargetOperand},
+	BRANCH: {TargetOperand},
+	VALUE: {ValueOperand},
+	ACCESSOR: {AccessorOperand},
+	BOUND: {LocalOperand},
+	FREE: {FreeOperand},
+	GLOBAL: {GlobalOperand},
+	BOX: {LocalOperand},
+	UNDEFINE: {LocalOperand},
+	FRAME: {TargetOperand},
+	SHUFFLE: {CountOperand},
+	RETRACT: {CountOperand},
+	CALL: {CountOperand},
+	CLOSE: {BlockOperand, CountOperand},
+	CLOSEM: {BlockOperand, CountOperand},
+	PROLOG: {CountOperand},
+	PROLOG_OPT: {CountOperand, CountOperand},
+	PROLOG_REST: {CountOperand, CountOperand},
+	EXTENDA: {AccessorOperand},
+	FINISH: {CountOperand},
+	GET: {AccessorOperand, SlotOperand},
+	GETM: {AccessorOperand, SlotOperand},
+	SET: {AccessorOperand, SlotOperand},
+	SUPER: {SlotOperand},
+	SOURCE: {ValueOperand, CountOperand},
+	TRY: {TargetOperand},
+	FINALLY: {TargetOperand},
+	CALLK: {CountOperand, CountOperand},
+	PROLOG_KW: {CountOperand, CountOperand, CountOperand},
+	DEFAULT: {TargetOperand, LocalOperand},
+	BIND: {LocalOperand},
+	SHAPE: {ShapeOperand, CountOperand},
+	MATCH: {ShapeOperand, CountOperand},
+	FIELDS: {CountOperand},
+	MIXIN: {CountOperand},
+	TRAIT: {CountOperand},
+	PUSHV: {ValueOperand},
+	PUSHB: {LocalOperand},
+	GLOBALU: {GlobalOperand},
+	BOUNDU: {LocalOperand},
+}
+
+var shapeNames = [...]string {
+	shapeArray: "array",
+	shapeArrayRest: "array...",
+	shapePair: "pair",
+	shapeHash: "hash",
+}
+
+// A slot in a class skeleton, as found among a unit's values.
+type SkelSlot struct {
+	Name string
+	Flags SlotFlags
+	Static int // the slot with the same name in the enclosing class, or -1
+}
+
+// The number of blocks of code in the unit. Block 0 holds the top level code,
+// and each of the others holds the code of a function.
+func (u *Unit) Blocks() int {
+	return len(u.b)
+}
+
+// The name that the function whose code is in a block was defined with. It is
+// empty for block 0 and for anonymous functions.
+func (u *Unit) BlockName(block int) string {
+	if block >= len(u.fn) {
+		return ""
+	}
+	return u.fn[block]
+}
+
+// The names of the globals the unit refers to, by index.
+func (u *Unit) Globals() []string {
+	return append([]string(nil), u.gn...)
+}
+
+// The names of the accessors the unit refers to, by index.
+func (u *Unit) Accessors() []string {
+	return append([]string(nil), u.an...)
+}
+
+// The constant values the unit refers to, by index. These include class
+// skeletons, which may be taken apart with Skeleton().
+func (u *Unit) Values() []*Object {
+	return append([]*Object(nil), u.v...)
+}
+
+// Take apart a class skeleton among the unit's values. Gives the name of the
+// class and its slots, or ok == false if the value is not a skeleton.
+func (u *Unit) Skeleton(v int) (name string, slots []SkelSlot, ok bool) {
+	x := u.v[v]
+	if x.c != skeletonClass {
+		return "", nil, false
+	}
+	e := x.skelData()
+	for _, s := range e[1:] {
+		static := -1
+		if s.next != slotUnknown {
+			static = int(s.next)
+		}
+		slots = append(slots, SkelSlot{
+			Name: u.an[s.access],
+			Flags: s.Flags,
+			Static: static,
+		})
+	}
+	return e[0].Name, slots, true
+}
+
+// Decode the code in one of the unit's blocks. Panics if the code is not valid.
+func (u *Unit) Code(block int) []Instr {
+	var res []Instr
+	c := u.b[block]
+	for p := 0; p < len(c); {
+		w := Width(c[p:])
+		op := c[p]
+		in := Instr{Offset: p, Op: op}
+		in.File, in.Line, in.Col = u.Position(block, p)
+		for j, x := range c[p+1:p+w] {
+			k := AccessorOperand
+			if j < len(operandKinds[op]) {
+				k = operandKinds[op][j]
+			}
+			n := int(x)
+			if k == SlotOperand && x == slotUnknown {
+				n = -1
+			}
+			in.Args = append(in.Args, Operand{k, n})
+		}
+		res = append(res, in)
+		p += w
+	}
+	return res
+}
+
+/*******************************************************************************
+
+	Disassembler
+
+*******************************************************************************/
+
+// Write a listing of the unit's code, in a form meant for people to read. Each
+// block is listed in turn, with the name of each instruction and what its
+// operands refer to. The source line is noted wherever it changes. Class
+// skeletons are listed with their slots.
+func (u *Unit) Disassemble(w io.Writer) {
+	for b := range u.b {
+		if n := u.BlockName(b); n != "" {
+			fmt.Fprintf(w, "block %d: %s\n", b, n)
+		} else {
+			fmt.Fprintf(w, "block %d:\n", b)
+		}
+		file, line := "", 0
+		for _, in := range u.Code(b) {
+			if in.Line != 0 && (in.File != file || in.Line != line) {
+				file, line = in.File, in.Line
+				fmt.Fprintf(w, "    ; %s:%d\n", file, line)
+			}
+			fmt.Fprintf(w, "%6d  %s", in.Offset, Name(in.Op))
+			for j, a := range in.Args {
+				sep := ", "
+				if j == 0 {
+					sep = strings.Repeat(" ", 12-len(Name(in.Op)))
+				}
+				fmt.Fprint(w, sep, u.describe(a))
+			}
+			fmt.Fprintln(w)
+			for _, a := range in.Args {
+				if a.Kind == ValueOperand {
+					u.listSkeleton(w, a.N)
+				}
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func (u *Unit) describe(a Operand) string {
+	switch a.Kind {
+	case TargetOperand:
+		return fmt.Sprintf("-> %d", a.N)
+	case ValueOperand:
+		return fmt.Sprintf("%d (%s)", a.N, u.literal(u.v[a.N]))
+	case GlobalOperand:
+		return fmt.Sprintf("%d (%s)", a.N, u.gn[a.N])
+	case AccessorOperand:
+		return fmt.Sprintf("%d (.%s)", a.N, u.an[a.N])
+	case BlockOperand:
+		if n := u.BlockName(a.N); n != "" {
+			return fmt.Sprintf("block %d (%s)", a.N, n)
+		}
+		return fmt.Sprintf("block %d", a.N)
+	case LocalOperand:
+		return fmt.Sprintf("local %d", a.N)
+	case FreeOperand:
+		return fmt.Sprintf("free %d", a.N)
+	case SlotOperand:
+		if a.N == -1 {
+			return "slot ?"
+		}
+		return fmt.Sprintf("slot %d", a.N)
+	case ShapeOperand:
+		if a.N < len(shapeNames) {
+			return shapeNames[a.N]
+		}
+	}
+	return strconv.Itoa(a.N)
+}
+
+// Constants are described without calling any methods, as the unit may not
+// have been linked to an interpreter.
+func (u *Unit) literal(x *Object) string {
+	switch x.c {
+	case StringClass:
+		return strconv.Quote(x.ToString())
+	case IntClass:
+		return strconv.FormatInt(x.ToInt(), 10)
+	case FltClass:
+		return strconv.FormatFloat(x.ToFloat(), 'g', -1, 64)
+	case BigIntClass:
+		return toBig(x).String()
+	case skeletonClass:
+		return "skeleton " + x.skelData()[0].Name
+	}
+	switch x {
+	case Nil:
+		return "nil"
+	case True:
+		return "true"
+	case False:
+		return "false"
+	}
+	return "<" + x.c.n + ">"
+}
+
+var slotKindNames = [...]string {
+	Field: "field",
+	Method: "method",
+	Property: "property",
+	Marker: "marker",
+}
+
+func (u *Unit) listSkeleton(w io.Writer, v int) {
+	_, slots, ok := u.Skeleton(v)
+	if !ok {
+		return
+	}
+	for _, s := range slots {
+		vis := "public"
+		if s.Flags.Vis() == Private {
+			vis = "private"
+		}
+		req := ""
+		if s.Flags & abstractSlot != 0 {
+			req = " required"
+		}
+		static := ""
+		if s.Static != -1 {
+			static = fmt.Sprintf(" (slot %d)", s.Static)
+		}
+		fmt.Fprintf(w, "                 %-8s %-7s %s%s%s\n", slotKindNames[s.Flags.Kind()],
+		            vis, s.Name, req, static)
+	}
+}
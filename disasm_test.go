@@ -0,0 +1,159 @@
+package ts_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"github.com/bobappleyard/ts"
+	"github.com/bobappleyard/ts/bytecode"
+)
+
+const inspected = `def greet(n) = "hi " + n;
+class P() def name; private def age = 3; def go() = this.name; end;
+if greet(1) then 2.5; else 12345678901234567890123; end;
+f(a = 1);`
+
+func compiled(t *testing.T, src string) *ts.Unit {
+	u := new(ts.Unit)
+	if err := u.CompileErr(strings.NewReader(src), "test"); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// The listing names instructions and what their operands refer to.
+func TestDisassemble(t *testing.T) {
+	for _, loaded := range []bool{false, true} {
+		u := compiled(t, inspected)
+		if loaded {
+			u = reload(t, u)
+		}
+		var buf bytes.Buffer
+		u.Disassemble(&buf)
+		listing := buf.String()
+		for _, want := range []string{
+			"block 0:\n",
+			"block 1: greet\n",
+			"block 2: go\n",
+			"    ; test:3\n",
+			"CLOSE       block 1 (greet), 0",
+			"GLOBAL      2 (P)",
+			`VALUE       3 ("hi ")`,
+			"VALUE       7 (2.5)",
+			"VALUE       8 (12345678901234567890123)",
+			"VALUE       0 (nil)",
+			"GETM        1 (.__add__), slot ?",
+			"GET         2 (.name), slot 0",
+			"BRANCH      -> 52",
+			"BOUND       local 0",
+			"CALLK       0, 1, 5 (.a)",
+			"PROLOG_KW   1, 1, 0, 0 (.n)",
+			"VALUE       4 (skeleton P)\n" +
+			"                 field    public  name\n" +
+			"                 field    private age\n" +
+			"                 method   private go\n",
+		} {
+			if !strings.Contains(listing, want) {
+				t.Errorf("loaded %v: listing lacks %q:\n%s", loaded, want, listing)
+			}
+		}
+	}
+}
+
+// The same information can be walked from Go.
+func TestInspect(t *testing.T) {
+	u := compiled(t, inspected)
+	if u.Blocks() != 3 || u.BlockName(0) != "" || u.BlockName(1) != "greet" {
+		t.Errorf("blocks: %d %q %q", u.Blocks(), u.BlockName(0), u.BlockName(1))
+	}
+	if got := fmt.Sprint(u.Globals(), u.Accessors()); got != "[greet Object P f] [n __add__ name age go a]" {
+		t.Errorf("names: %s", got)
+	}
+	name, slots, ok := u.Skeleton(4)
+	if !ok || name != "P" || len(slots) != 3 || slots[1].Name != "age" ||
+	   slots[1].Flags.Vis() != ts.Private || slots[2].Flags.Kind() != ts.Method {
+		t.Errorf("skeleton: %s %+v %v", name, slots, ok)
+	}
+	if _, _, ok := u.Skeleton(3); ok {
+		t.Error("a string is a skeleton")
+	}
+	if s := u.Values()[3].ToString(); s != "hi " {
+		t.Errorf("value: %q", s)
+	}
+	var ops []string
+	var branch ts.Instr
+	for _, in := range u.Code(0) {
+		ops = append(ops, bytecode.Name(in.Op))
+		if in.Op == bytecode.BRANCH {
+			branch = in
+		}
+	}
+	if got := strings.Join(ops[:5], " "); got != "CLOSE PUSH GLOBAL DEFINE UPDATE" {
+		t.Errorf("ops: %s", got)
+	}
+	want := ts.Instr{Offset: 42, Op: bytecode.BRANCH, Args: []ts.Operand{{ts.TargetOperand, 52}}, File: "test", Line: 3, Col: 9}
+	if fmt.Sprint(branch) != fmt.Sprint(want) {
+		t.Errorf("got %+v, want %+v", branch, want)
+	}
+	for _, in := range u.Code(2) {
+		if in.Op == bytecode.GET && fmt.Sprint(in.Args) != fmt.Sprint([]ts.Operand{{ts.AccessorOperand, 2}, {ts.SlotOperand, 0}}) {
+			t.Errorf("GET: %+v", in.Args)
+		}
+		if in.Op == bytecode.PROLOG && in.Line != 0 {
+			t.Errorf("prologue placed at %d", in.Line)
+		}
+	}
+	if bytecode.Name(200) != "<200>" {
+		t.Errorf("name: %s", bytecode.Name(200))
+	}
+}
+
+// A saved unit of "1;" whose first instruction has been replaced.
+func corrupted(t *testing.T, op uint32) *ts.Unit {
+	u := compiled(t, "1;")
+	var buf bytes.Buffer
+	u.Save(&buf)
+	b := buf.Bytes()
+	// the code follows the 6 byte start of the header and ten 32 bit counts
+	binary.LittleEndian.PutUint32(b[46:], op)
+	v := new(ts.Unit)
+	if !v.Load(bytes.NewReader(b)) {
+		t.Fatal("not a unit")
+	}
+	return v
+}
+
+func TestInspectErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		f func()
+		want string
+	}{
+		{"bad instruction", func() {
+			corrupted(t, 200).Code(0)
+		}, "bad instruction: 200"},
+		{"truncated", func() {
+			corrupted(t, bytecode.CLOSE).Code(0)
+		}, "truncated instruction"},
+		{"listing", func() {
+			corrupted(t, 200).Disassemble(new(bytes.Buffer))
+		}, "bad instruction: 200"},
+		{"no block", func() {
+			compiled(t, "1;").Code(5)
+		}, "out of range"},
+	}
+	for _, test := range tests {
+		got := func() (msg string) {
+			defer func() {
+				msg = fmt.Sprint(recover())
+			}()
+			test.f()
+			return "no panic"
+		}()
+		if !strings.Contains(got, test.want) {
+			t.Errorf("%s: got %s, want %q", test.name, got, test.want)
+		}
+	}
+}
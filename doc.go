@@ -26,11 +26,41 @@ The basic arithmetic and comparative operators work as expected.
 
 	1 + 3           // 4
 	3 / 2           // 1.5
+	7 ~/ 2          // 3
+	7 % 2           // 1
+	2 ** 10         // 1024
 	12 * 4 - 6      // 42
 	5 >= 4          // true
 	5 == 4 + 2      // false
 
-Supported operations are "+", "-", "*", "/", "==", "!=", "<", ">", "<=", ">=".
+Supported operations are "+", "-", "*", "/", "~/" (integer division, as "//"
+begins a comment), "%", "**", "==", "!=", "<", ">", "<=", ">=". Integers also
+support the bitwise operations "&", "|", "^", "~", "<<" and ">>".
+
+	6 & 3           // 2
+	1 << 4          // 16
+
+Integers have no fixed size. Results that do not fit in 64 bits are held as
+BigInts, a kind of Integer, and become ordinary Integers again once they fit.
+A shift or a power whose result would have more than 16777216 bits raises an
+error instead.
+
+	2 ** 100        // 1267650600228229401496703205376
+	2 ** 100 ~/ 2 ** 98     // 4
+
+Division of Integers gives a Float. For exact results, make a Rational. Once
+one operand is Rational, "+", "-", "*", "/" and "**" give Rationals too,
+except that a whole result is an Integer.
+
+	Rational(1, 3) + 1      // 4/3
+	Rational(1, 3) / 2      // 1/6
+	Rational("0.25")        // 1/4
+	Rational(1, 3) * 3      // 1, an Integer
+
+From loosest to tightest, the operators bind as: "||" and "&&"; "==" and "!=";
+the other comparisons; "|"; "^"; "&"; "<<" and ">>"; "+" and "-"; "*", "/",
+"~/" and "%"; the prefix operators; "**". Each operator calls a method on its
+left operand, such as "__add__" or "__mod__", so classes may define them.
 
 Names follow the C syntax convention: Letters or "_" to begin, letters, digits
 or "_" after that.
@@ -80,6 +110,18 @@ Two strings may be concatenated using "+".
 
 	"Hello, " + "world!\n" // gives the same value as before
 
+An expression enclosed in "${" and "}" inside a string is evaluated, and the
+result of calling "toString" on it takes its place. Write "\$" for a "$" that
+would otherwise begin one.
+
+	"${name} has ${n + 1} items"  // name.toString() + " has " + (n + 1).toString() + " items"
+
+Strings enclosed in backquotes may run over several lines, and have neither
+escape sequences nor expressions.
+
+	`a "raw"
+	string`
+
 Arrays are series of objects enclosed in brackets and separated with ",".
 
 	[1, 2, 3]
@@ -129,6 +171,147 @@ e.g.
 
 This prints "a is less than five".
 
+Loops
+
+A while loop evaluates its block for as long as an expression evaluates to
+"true".
+
+	while <expression> do <block> end
+
+A for loop evaluates its block once for each item in an iterable, with a
+variable bound to that item.
+
+	for <name> in <expression> do <block> end
+
+The expression's "__iter__" method is called to get an iterator. The iterator's
+"next" method is then called before each pass through the loop, and the loop
+stops when it returns "done". Each pass gets a fresh variable, so functions
+defined in the block see the item they were defined with.
+
+Inside a loop, "break" leaves the innermost loop and "continue" starts its next
+pass. Neither may be used outside of a loop, or in a function defined inside one.
+
+e.g.
+
+	def i = 0;
+	while true do
+		i = i + 1;
+		if i == 2 then
+			continue;
+		end;
+		if i > 3 then
+			break;
+		end;
+		print(i);
+	end;
+	for x in ["a", "b"] do
+		print(x);
+	end;
+
+This prints "1", "3", "a" and "b" on consecutive lines.
+
+Handling Errors
+
+Errors are raised with the "throw" function. A try statement evaluates a block
+and handles any errors raised while it runs.
+
+	try
+		<block>
+	catch <name>: <expression>
+		<block>
+	catch <name>
+		<block>
+	finally
+		<block>
+	end
+
+The "catch" clauses are tried in order. A clause with an expression handles
+errors for which "is" returns "true" when called with the value of that
+expression, usually a class derived from "Error". A clause without one handles
+any error. The clause's block is evaluated with the name bound to the error.
+Values that are not errors are wrapped in an "Error" when they are thrown. If no
+clause handles an error it carries on to the next try statement out.
+
+The "finally" block is evaluated however control leaves the statement: when the
+block finishes, when an error is raised, or when "return", "break" or "continue"
+jump out of it. There must be at least one "catch" or a "finally".
+
+Neither "catch" clauses nor the "catch" function handle the errors raised when
+the program running the code asks it to stop, when the code goes beyond the
+limits that program has placed on it, or when "exit" is called in a sandbox.
+These pass through "finally" blocks on their way out, though such a block stops
+as well if it needs more of whatever the code has run out of.
+
+e.g.
+
+	class NotFound(Error)
+	end;
+	try
+		throw(NotFound("no such thing"));
+	catch e: NotFound
+		print(e.msg);
+	finally
+		print("done");
+	end;
+
+This prints "no such thing" and then "done".
+
+Matching
+
+A match statement compares a value with a series of patterns, and evaluates the
+block of the first case whose pattern fits.
+
+	match <expr>
+	case <pattern> then
+		<block>
+	case <pattern> if <expr> then
+		<block>
+	end
+
+A pattern may be:
+
+	_                        // anything
+	<name>                   // anything, which the name is bound to
+	[<pattern>, ...]         // a sequence with that many items
+	[<pattern>, <pattern>*]  // a sequence with at least that many items
+	{<name>, <expr>: <pattern>, ...}  // a keyed collection with those keys
+	<pattern> : <pattern>    // a pair
+	<class>(<pattern>, ..., <name> = <pattern>, ...)  // an instance of a class
+	<pattern> | <pattern>    // either pattern
+	<expr>                   // a value equal to that of the expression
+
+A keyed collection is one, such as a hash, that is not a sequence and has a
+"contains" method. The patterns of a class pattern are matched against the
+public fields of the class, starting with those of its ancestors, and then
+against the properties named by the keywords. A class pattern with no patterns,
+such as "Number()", matches any instance of the class. A name on its own is
+always bound, so one that begins with a capital letter, such as "Number", is
+refused rather than hiding the class. All the alternatives of an either pattern
+must bind the same names. As "|" binds more tightly than ":", pairs among the
+alternatives must be put in brackets. The names a case binds are defined in its
+block and in the expression after "if". The case only applies if that
+expression evaluates to "true". If no case applies a "MatchError" is raised,
+with the value as its "value" field.
+
+e.g.
+
+	class Vector()
+		def x, y;
+		def create(x, y)
+			this.x = x;
+			this.y = y;
+		end;
+	end;
+	match [Vector(0, 1), "a": 2]
+	case [Vector(0, y), _ : n] if n > 1 then
+		print(y, n);
+	case [] | [_] then
+		print("short");
+	end;
+
+This prints "1 2". As "match" is also an ordinary name, a value that begins with
+"(" or "[" must be entirely enclosed by its first bracket.
+
 Variables And Scope
 
 Variables allow you to store state and refer to the results of expresssions.
@@ -173,6 +356,39 @@ altered in place rather than shadowed.
 
 This prints "2 2".
 
+An operator followed by "=" updates a variable, property or member with the
+result of the operation.
+
+	a += 1;        // a = a + 1;
+	x.y[i] *= 2;   // x.y and i are only evaluated once
+
+A value may be taken apart as it is assigned to several variables at once. In
+place of the name, write a pattern:
+
+	[<pattern>, ...]         // an array, or any sequence, with that many items
+	[<pattern>, <name>*]     // the rest of the items, as an array
+	{<name>, ...}            // a hash, with the names as keys
+	<pattern> : <pattern>    // a pair
+
+Each pattern may be a name or another pattern. Items are got with "__aget__" and
+the halves of a pair with "left" and "right". A value of the wrong shape raises
+an error.
+
+e.g.
+
+	def [a, b, rest*] = [1, 2, 3, 4];
+	def {name, age} = {"name": "Bob", "age": 42};
+	[a, b] = [b, a];
+
+This defines "a" as 1, "b" as 2, "rest" as [3, 4], "name" as "Bob" and "age" as
+42, and then swaps "a" and "b". When updating, the pattern may also contain
+places such as "x.y" or "x[0]". Function arguments and the variable of a for
+loop may also be patterns.
+
+	for key : val in ["a": 1, "b": 2] do
+		print(key, val);
+	end;
+
 Functions
 
 Functions are an important part of TranScript. They are first-class and are 
@@ -235,6 +451,35 @@ e.g.
 		return fn(x) = f(g(x));
 	end;
 
+An argument may instead be given a default value by following its name with "="
+and an expression. The expression is evaluated when the function is called
+without that argument, and may refer to the arguments before it.
+
+e.g.
+
+	def steps(to, from = 0, step = 1)
+		...
+	end;
+
+If the last argument is preceded by "**" then it is a hash of any keyword
+arguments that are not caught by other argument names.
+
+Arguments may be passed by name, by following the name with "=" and the value.
+These keyword arguments come after the others.
+
+e.g.
+
+	steps(10, step = 2);
+	steps(from = 5, to = 10);
+
+A function given keyword arguments it does not expect raises an error. So does
+a function given the same argument both by position and by keyword.
+
+Functions have an "apply" method that takes an array of arguments and,
+optionally, a hash of keyword arguments.
+
+	f.apply([1, 2], {"step": 2});
+
 <body> is either "=" followed by an expression, or a block terminated with 
 "end".
 
@@ -297,6 +542,35 @@ people call "closures," others call "lexical scope."
 	a(1);                   // 2
 	a(4);                   // 6
 
+Generators
+
+A function whose body contains a "yield" expression is a generator. Calling it
+evaluates none of its body. Instead it returns an iterator, and each call to the
+iterator's "next" method runs the body until the next "yield", which suspends
+the function and makes the value of its expression the result of "next". When
+the body finishes, "next" returns "done". A "yield" without an expression
+yields "nil". Errors raised in the body are raised by "next", and "finally"
+blocks run as usual.
+
+	yield <expr>
+
+e.g.
+
+	def naturals()
+		def i = 0;
+		while true do
+			yield i;
+			i = i + 1;
+		end;
+	end;
+	for x in take(3, naturals()) do
+		print(x);
+	end;
+
+Prints "0", "1" and "2" on consecutive lines. A "yield" belongs to the function
+definition that encloses it, so an inner function does not make the outer one a
+generator.
+
 Objects and Classes
 
 Objects are collections of slots. They support three basic operations: property
@@ -322,7 +596,8 @@ To define a class, use the "class" keyword
 
 The <ancestor> is an expression that evaluates to a class, and is used for 
 inheritance. Any members defined on the ancestor are also defined on this class.
-It may be omitted, in which case "Object" is used.
+It may be omitted, in which case "Object" is used. It may be followed by a list
+of traits (see below).
 
 The <class body> is a series of "def" statements punctuated with ";".
 Definitions that look like variables correspond to properties and definitions
@@ -384,6 +659,48 @@ e.g.
 
 Prints "1".
 
+Traits
+
+Traits collect behaviour that classes in unrelated hierarchies share. A trait is
+defined like a class, and may list the traits it includes:
+
+	trait <trait name>(<traits>)
+		<trait body>
+	end;
+
+The parentheses may be left out if there are none. Besides "def" statements, a
+trait body may contain "require" statements. These name methods that the trait
+does not define, but that its own methods may call.
+
+e.g.
+
+	trait Comparable
+		require compare;
+		def __lt__(x) = this.compare(x) < 0;
+		def __gt__(x) = this.compare(x) > 0;
+	end;
+
+Traits are mixed into classes by listing them after the ancestor. If the first
+thing listed is a trait, then the ancestor is "Object".
+
+	class Money(Comparable)
+		def amount;
+		def create(a) this.amount = a; end;
+		def compare(o) = this.amount - o.amount;
+	end;
+
+The members of a trait override those of the ancestor, and the class's own
+members override those of the trait, so "super" in a class method may refer to a
+trait's method. Where several traits are mixed in, later ones override earlier
+ones. It is an error for two traits to define the same member, unless one
+includes the other or the class defines that member too. It is also an error for
+a class not to define a method that one of its traits requires.
+
+An object is an instance of the traits mixed into its class, so
+"Money(5).is(Comparable)" and "Money.inheritsFrom(Comparable)" are both true.
+Traits cannot be instantiated. Their members are all public, and "super" cannot
+be used in their methods.
+
 Packages and Programs
 
 Evaluation of programs is controlled by the client of the library. In the
@@ -437,5 +754,52 @@ be searched.
 Packages are only loaded and evaluated once during the lifetime of the
 interpreter.
 
+Syntax Trees
+
+Code may work on other code through its structure, rather than by putting
+strings together for "eval". The "ast" package parses source into trees of
+nodes, and compiles trees back into functions.
+
+e.g.
+
+	import ast;
+	def tree = ast.parse("1 + 2;")[0];
+	print(tree);
+	tree.children[0].text = "__mul__";
+	print(ast.eval(tree));
+
+Prints "(call "+" (look "__add__" (val 1)) (val 2))" and then "2". Each node has
+a kind, which is one of the names in "ast.kinds", along with text, value,
+children, file, line and col fields. Nodes may be made with "ast.Node(kind, text,
+children)". The kinds of node, and what their fields hold, are described with
+the GroupNode to SuperNode constants in this package.
+
+Concurrency
+
+Code may run on several goroutines at once, for instance through the "spawn"
+function in the sync extension, or when the client of the library calls into
+the interpreter from more than one goroutine. The interpreter itself may be
+shared like this: global variables, classes and packages may be defined and
+looked up concurrently.
+
+Values are shared between goroutines as they are in Go. Hashes take a lock on
+each access, so "h[k] = v" in one goroutine and "h[k]" in another is safe,
+though a series of accesses is not done as a unit. Arrays, objects and
+variables, including global variables, are not protected at all. If one
+goroutine may change one of these while another uses it, the code must
+synchronise access itself, using a mutex or a channel from the sync extension.
+
+e.g.
+
+	def sync = loadExtension("sync");
+	def lock = sync.Mutex();
+	def results = [];
+	sync.spawn(fn()
+		lock.with(fn() results.push(1); end);
+	end);
+
+Anything else is a data race. A race may lose updates, raise errors or even
+crash the program.
+
 */
 package ts
@@ -3,6 +3,7 @@ package ts
 import (
 	"errors"
 	"fmt"
+	"github.com/bobappleyard/ts/parse"
 )
 
 var (
@@ -13,6 +14,163 @@ func ArgError(c int) error {
 	return fmt.Errorf("wrong number of arguments %d", c)
 }
 
+func KeywordError(n string) error {
+	return fmt.Errorf("unexpected keyword argument: %s", n)
+}
+
 func TypeError(x *Object) error {
 	return fmt.Errorf("wrong type: %s", x)
 }
+
+// The Go form of an error raised while compiling or running TranScript code.
+type ScriptError struct {
+	Object *Object // an instance of ErrorClass, or nil if no interpreter had been made
+	Msg, File string
+	Line, Col int
+	Err error // the Go error underlying this one, if any
+	Trace []StackFrame // innermost call first
+}
+
+func (e *ScriptError) Error() string {
+	if e.Line == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s(%d): %s", e.File, e.Line, e.Msg)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// A call that was in progress when an error was raised.
+type StackFrame struct {
+	Function string
+	Class string // empty for functions defined outside of a class
+	File string
+	Line, Col int
+}
+
+func (f StackFrame) String() string {
+	n := f.Function
+	if f.Class != "" {
+		n = f.Class + "." + n
+	}
+	if f.Line == 0 {
+		return n
+	}
+	return fmt.Sprintf("%s(%d): %s", f.File, f.Line, n)
+}
+
+func stackFrame(o *Object) StackFrame {
+	var res StackFrame
+	if n := frameClass.Get(o, 0); n.c == StringClass {
+		res.Function = n.ToString()
+	}
+	if c := frameClass.Get(o, 1); c.c == ClassClass {
+		res.Class = c.ToClass().n
+	}
+	if f := frameClass.Get(o, 2); f.c == StringClass {
+		res.File = f.ToString()
+	}
+	if l := frameClass.Get(o, 3); l.c == IntClass {
+		res.Line = int(l.ToInt())
+	}
+	if c := frameClass.Get(o, 4); c.c == IntClass {
+		res.Col = int(c.ToInt())
+	}
+	return res
+}
+
+// Raised when running code goes beyond one of the Limits placed on it.
+type LimitError struct {
+	Limit string
+	Max int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("limit exceeded: %s (%d)", e.Limit, e.Max)
+}
+
+// Raised by exit() in a sandboxed interpreter.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// Convert a value recovered from a panic in the compiler or the runtime into a
+// ScriptError. Returns nil if x is nil.
+func ToError(x interface{}) *ScriptError {
+	switch v := x.(type) {
+	case nil:
+		return nil
+	case *ScriptError:
+		return v
+	case *Object:
+		if v == nil {
+			return nil
+		}
+		if !v.Is(ErrorClass) {
+			return ToError(ErrorClass.New(v))
+		}
+		res := &ScriptError{Object: v}
+		res.Msg = ErrorClass.Get(v, 0).String()
+		if f := ErrorClass.Get(v, 1); f.c == StringClass {
+			res.File = f.ToString()
+		}
+		if l := ErrorClass.Get(v, 2); l.c == IntClass {
+			res.Line = int(l.ToInt())
+		}
+		if c := ErrorClass.Get(v, 4); c.c == IntClass {
+			res.Col = int(c.ToInt())
+		}
+		if err, ok := v.data.(error); ok {
+			res.Err = err
+		}
+		if t := ErrorClass.Get(v, 3); t.c == ArrayClass {
+			for _, f := range t.ToArray() {
+				if f.c == frameClass {
+					res.Trace = append(res.Trace, stackFrame(f))
+				}
+			}
+		}
+		return res
+	case error:
+		var perr *parse.Error
+		if ErrorClass.m == nil {
+			// the built-in classes are filled in by the first interpreter, and
+			// code may be parsed or compiled before there is one
+			res := &ScriptError{Msg: v.Error(), Err: v}
+			if errors.As(v, &perr) {
+				res.Msg, res.File = perr.Msg, perr.File
+				res.Line, res.Col = perr.Line, perr.Col
+			}
+			return res
+		}
+		res := ToError(ErrorClass.New(Wrap(v.Error())))
+		res.Object.data = v
+		res.Err = v
+		if errors.As(v, &perr) {
+			res.Msg, res.File = perr.Msg, perr.File
+			res.Line, res.Col = perr.Line, perr.Col
+			ErrorClass.Set(res.Object, 0, Wrap(perr.Msg))
+			ErrorClass.Set(res.Object, 1, Wrap(perr.File))
+			ErrorClass.Set(res.Object, 2, Wrap(perr.Line))
+			ErrorClass.Set(res.Object, 4, Wrap(perr.Col))
+		}
+		return res
+	}
+	if ErrorClass.m == nil {
+		return ToError(errors.New(fmt.Sprint(x)))
+	}
+	return ToError(ErrorClass.New(Wrap(fmt.Sprint(x))))
+}
+
+// Call from a deferred function to turn a panic into an error.
+func catchError(err *error) {
+	if e := recover(); e != nil {
+		*err = ToError(e)
+	}
+}
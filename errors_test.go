@@ -0,0 +1,189 @@
+package ts_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+	"github.com/bobappleyard/ts/parse"
+)
+
+// Check that err is a ScriptError placed at a line of a file, with the message
+// and the Error object that scripts would see.
+func expectScriptError(t *testing.T, name string, err error, file string, line int, msg string) *ts.ScriptError {
+	var e *ts.ScriptError
+	if !errors.As(err, &e) {
+		t.Errorf("%s: got %v", name, err)
+		return nil
+	}
+	if e.File != file || e.Line != line || !strings.Contains(e.Msg, msg) {
+		t.Errorf("%s: got %s(%d): %s", name, e.File, e.Line, e.Msg)
+	}
+	if e.Object == nil || !e.Object.Is(ts.ErrorClass) {
+		t.Errorf("%s: got object %v", name, e.Object)
+	}
+	return e
+}
+
+// The entry points that return errors give the value when there is no error.
+func TestErrOk(t *testing.T) {
+	i := ts.New()
+	x, err := i.EvalErr("1 + 2;")
+	if err != nil || x.String() != "3" {
+		t.Errorf("eval: got %v, %v", x, err)
+	}
+	u := new(ts.Unit)
+	if err := u.CompileErr(strings.NewReader("def ok = 4;"), "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.ExecErr(u); err != nil || i.Get("ok").String() != "4" {
+		t.Errorf("exec: got %v", err)
+	}
+}
+
+// Errors raised while compiling and while running are placed where they were
+// raised.
+func TestErrPosition(t *testing.T) {
+	i := ts.New()
+	_, err := i.EvalErr(`throw("boom");`)
+	expectScriptError(t, "eval", err, "unknown", 1, "boom")
+
+	u := new(ts.Unit)
+	err = u.CompileErr(strings.NewReader("def x = 1;\ndef y = ;"), "test")
+	e := expectScriptError(t, "compile", err, "test", 2, "expected")
+	var perr *parse.Error
+	if e != nil && !errors.As(e, &perr) {
+		t.Errorf("compile: %v does not wrap a parse error", e)
+	}
+
+	u = new(ts.Unit)
+	u.Compile(strings.NewReader("def x = 1;\n\nx.nothing;"), "test")
+	_, err = i.ExecErr(u)
+	expectScriptError(t, "exec", err, "test", 3, "undefined")
+
+	l := ts.NewScanner(strings.NewReader("1;\n+;"), "test")
+	u = new(ts.Unit)
+	if more, err := u.CompileStmtErr(l); !more || err != nil {
+		t.Errorf("statement: got %v, %v", more, err)
+	}
+	_, err = u.CompileStmtErr(l)
+	expectScriptError(t, "statement", err, "test", 2, "")
+
+	p := filepath.Join(t.TempDir(), "bad.ts")
+	if err := os.WriteFile(p, []byte("def a = 1;\nthrow(\"loaded\");\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	expectScriptError(t, "load", i.LoadErr(p), p, 2, "loaded")
+}
+
+// Go errors come through as the error that the ScriptError wraps.
+func TestErrFromGo(t *testing.T) {
+	i := ts.New()
+	failed := errors.New("failed")
+	i.Define("fail", ts.Wrap(func(o, x *ts.Object) *ts.Object {
+		panic(failed)
+	}))
+	_, err := i.EvalErr("fail(1);")
+	if !errors.Is(err, failed) {
+		t.Errorf("got %v", err)
+	}
+	if err := i.LoadErr("no such file"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("load: got %v", err)
+	}
+	if _, err := i.ImportErr("no such package"); err == nil {
+		t.Error("import: no error")
+	}
+}
+
+// Anything recovered from a panic converts to a ScriptError.
+func TestToError(t *testing.T) {
+	ts.New()
+	if ts.ToError(nil) != nil {
+		t.Error("nil: not nil")
+	}
+	if e := ts.ToError("text"); e.Msg != "text" || !e.Object.Is(ts.ErrorClass) {
+		t.Errorf("string: got %#v", e)
+	}
+	failed := errors.New("failed")
+	if e := ts.ToError(failed); e.Msg != "failed" || e.Err != failed || e.Object == nil {
+		t.Errorf("error: got %#v", e)
+	}
+	if e := ts.ToError(ts.Wrap(3)); e.Msg != "3" || !e.Object.Is(ts.ErrorClass) {
+		t.Errorf("object: got %#v", e)
+	}
+	e := ts.ToError(failed)
+	if ts.ToError(e) != e || ts.ToError(e.Object).Err != failed {
+		t.Error("not kept")
+	}
+	if e.Error() != "failed" {
+		t.Errorf("got %q", e.Error())
+	}
+}
+
+// Errors raised by a function before it runs its body are placed at the call.
+func TestPrologueErrorPosition(t *testing.T) {
+	const defs = `
+		def f(a) = a;
+		def g(a, b = 1) = a;
+		def h(**k) = k;
+	`
+	tests := []struct {
+		name, src, want string
+	}{
+		{"too few", "f();", "test(6): wrong number of arguments 0"},
+		{"too many", "h(1, 2);", "test(6): wrong number of arguments 2"},
+		{"defaults", "g();", "test(6): wrong number of arguments 0"},
+		{"keyword", "f(1, b = 2);", "test(6): unexpected keyword argument: b"},
+		{"bad keyword", "g(1, c = 2);", "test(6): unexpected keyword argument: c"},
+		{"anonymous", "(fn(x) = x)();", "test(6): wrong number of arguments 0"},
+		{"apply", "f.apply([]);", "test(6): wrong number of arguments 0"},
+		{"nested", "def k() = [f()];\n\t\tk();", "test(6): wrong number of arguments 0"},
+	}
+	for _, test := range tests {
+		_, err := run(t, ts.New(), 20*time.Second, defs + "\n\t\t" + test.src, ts.Limits{})
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.want)
+		}
+	}
+}
+
+// Scripts see the position when they catch the error.
+func TestPrologueErrorCaught(t *testing.T) {
+	x, err := run(t, ts.New(), 20*time.Second, `
+		def f(a) = a;
+		def e = catch(fn()
+			f(1, 2);
+		end);
+		[e.line, e.msg];
+	`, ts.Limits{})
+	expect(t, "caught", x, err, "[4, wrong number of arguments 2]")
+}
+
+// Tokens are placed by line and by column, counted in characters.
+func TestColumns(t *testing.T) {
+	tests := []struct {
+		name, src string
+		line, col int
+	}{
+		{"first", "x;", 1, 1},
+		{"indented", "\t\tx;", 1, 3},
+		{"second line", "1;\n  x;", 2, 3},
+		{"runes", `"ééé"; x;`, 1, 8},
+		{"after string", "`a\nbc`; x;", 2, 6},
+		{"long line", strings.Repeat("1; ", 10000) + "x;", 1, 30001},
+	}
+	for _, test := range tests {
+		ns, err := ts.Parse(test.src)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		tok := ns[len(ns)-1].Token
+		if tok.Line != test.line || tok.Col != test.col {
+			t.Errorf("%s: got %d:%d, want %d:%d", test.name, tok.Line, tok.Col, test.line, test.col)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package ast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"github.com/bobappleyard/ts"
+	"github.com/bobappleyard/ts/parse"
+)
+
+func init() {
+	ts.RegisterExtension("ast", pkg)
+}
+
+// Raise an error from the parser or the compiler as it was raised, so that it
+// keeps its position in the source.
+func raise(err error) {
+	if se, ok := err.(*ts.ScriptError); ok {
+		panic(se.Object)
+	}
+	panic(err)
+}
+
+func pkg(it *ts.Interpreter) map[string] *ts.Object {
+	kind := it.Accessor("kind")
+	text := it.Accessor("text")
+	value := it.Accessor("value")
+	children := it.Accessor("children")
+	file := it.Accessor("file")
+	line := it.Accessor("line")
+	col := it.Accessor("col")
+
+	Node := ts.ObjectClass.Extend(it, "Node", 0, []ts.Slot {
+		ts.FSlot("kind", ts.Nil),
+		ts.FSlot("text", ""),
+		ts.FSlot("value", ts.Nil),
+		ts.FSlot("children", ts.Nil),
+		ts.FSlot("file", ""),
+		ts.FSlot("line", 0),
+		ts.FSlot("col", 0),
+		ts.MSlot("create", func(o *ts.Object, args []*ts.Object) *ts.Object {
+			if len(args) == 0 || len(args) > 3 {
+				panic(fmt.Errorf("wrong number of arguments: %d", len(args)))
+			}
+			if ts.NodeKindNamed(args[0].ToString()) == -1 {
+				panic(fmt.Errorf("unknown node kind: %s", args[0]))
+			}
+			o.Set(kind, args[0])
+			if len(args) > 1 {
+				o.Set(text, args[1])
+			}
+			cs := ts.Wrap([]*ts.Object{})
+			if len(args) > 2 {
+				cs = args[2]
+			}
+			o.Set(children, cs)
+			return ts.Nil
+		}),
+		ts.MSlot("toString", func(o *ts.Object) *ts.Object {
+			var b strings.Builder
+			var show func(o *ts.Object)
+			show = func(o *ts.Object) {
+				if o == ts.Nil {
+					b.WriteString("nil")
+					return
+				}
+				b.WriteString("(" + o.Get(kind).ToString())
+				if o.Get(kind).ToString() == "val" {
+					b.WriteString(" " + literal(o.Get(value)))
+				} else if t := o.Get(text).ToString(); t != "" {
+					b.WriteString(" " + strconv.Quote(t))
+				}
+				for _, x := range o.Get(children).ToArray() {
+					b.WriteString(" ")
+					show(x)
+				}
+				b.WriteString(")")
+			}
+			show(o)
+			return ts.Wrap(b.String())
+		}),
+	})
+
+	// Go nodes to objects and back. The data of a node becomes its value.
+	var toObject func(n *parse.Node) *ts.Object
+	toObject = func(n *parse.Node) *ts.Object {
+		if n == nil {
+			return ts.Nil
+		}
+		cs := make([]*ts.Object, len(n.Child))
+		for i, x := range n.Child {
+			cs[i] = toObject(x)
+		}
+		o := Node.New(ts.Wrap(ts.NodeKindName(n.Kind)), ts.Wrap(n.Token.Text), ts.Wrap(cs))
+		switch d := n.Data.(type) {
+		case *ts.Object:
+			o.Set(value, d)
+		case ts.SlotVis:
+			o.Set(value, ts.Wrap(visNames[d]))
+		case ts.FnArgs:
+			o.Set(value, ts.Wrap(map[string] interface{} {
+				"opt": d.Opt,
+				"rest": d.Rest,
+				"kw": d.Kw,
+			}))
+		case bool:
+			o.Set(value, ts.Wrap(d))
+		}
+		o.Set(file, ts.Wrap(n.Token.File))
+		o.Set(line, ts.Wrap(n.Token.Line))
+		o.Set(col, ts.Wrap(n.Token.Col))
+		return o
+	}
+
+	var fromObject func(o *ts.Object) *parse.Node
+	fromObject = func(o *ts.Object) *parse.Node {
+		if o == ts.Nil {
+			return nil
+		}
+		if !o.Is(Node) {
+			panic(fmt.Errorf("not a syntax tree: %s", o))
+		}
+		n := new(parse.Node)
+		n.Kind = ts.NodeKindNamed(o.Get(kind).ToString())
+		if n.Kind == -1 {
+			panic(fmt.Errorf("unknown node kind: %s", o.Get(kind)))
+		}
+		n.Token.Text = o.Get(text).ToString()
+		n.Token.File = o.Get(file).ToString()
+		n.Token.Line = int(o.Get(line).ToInt())
+		n.Token.Col = int(o.Get(col).ToInt())
+		v := o.Get(value)
+		switch n.Kind {
+		case ts.ValNode:
+			n.Data = v
+		case ts.VarNode, ts.FnNode, ts.PropNode:
+			if v != ts.Nil {
+				n.Data = visOf(v)
+			}
+		case ts.GroupNode:
+			if v != ts.Nil {
+				n.Data = argsOf(v)
+			}
+		case ts.ClassNode:
+			if v == ts.True {
+				n.Data = true
+			}
+		}
+		for _, x := range o.Get(children).ToArray() {
+			n.Add(fromObject(x))
+		}
+		return n
+	}
+
+	return map[string] *ts.Object {
+		"Node": Node.Object(),
+		"kinds": ts.Wrap(kindNames()),
+		"parse": ts.Wrap(func(o, src, f *ts.Object) *ts.Object {
+			r := strings.NewReader(src.ToString())
+			ns, err := ts.ParseReader(r, f.ToString())
+			if err != nil {
+				raise(err)
+			}
+			res := make([]*ts.Object, len(ns))
+			for i, n := range ns {
+				res[i] = toObject(n)
+			}
+			return ts.Wrap(res)
+		}),
+		"compile": ts.Wrap(func(o, n *ts.Object) *ts.Object {
+			f, err := it.CompileNode(fromObject(n))
+			if err != nil {
+				raise(err)
+			}
+			return f
+		}),
+	}
+}
+
+var visNames = map[ts.SlotVis] string {
+	ts.Public: "public",
+	ts.Private: "private",
+}
+
+func visOf(v *ts.Object) ts.SlotVis {
+	for x, s := range visNames {
+		if v.ToString() == s {
+			return x
+		}
+	}
+	panic(fmt.Errorf("unknown visibility: %s", v))
+}
+
+func argsOf(v *ts.Object) ts.FnArgs {
+	var m map[string] *ts.Object
+	if err := ts.Unwrap(v, &m); err != nil {
+		panic(err)
+	}
+	var res ts.FnArgs
+	if x := m["opt"]; x != nil {
+		res.Opt = int(x.ToInt())
+	}
+	res.Rest = m["rest"] == ts.True
+	res.Kw = m["kw"] == ts.True
+	return res
+}
+
+func kindNames() []*ts.Object {
+	var res []*ts.Object
+	for k := 0; ts.NodeKindName(k) != ""; k++ {
+		res = append(res, ts.Wrap(ts.NodeKindName(k)))
+	}
+	return res
+}
+
+// Constants are shown as they would be written, where they can be.
+func literal(x *ts.Object) string {
+	if x.Is(ts.StringClass) {
+		return strconv.Quote(x.ToString())
+	}
+	return x.String()
+}
@@ -1,6 +1,7 @@
 package ext
 
 import (
+	_ "github.com/bobappleyard/ts/ext/ast"
 	_ "github.com/bobappleyard/ts/ext/sync"
 	_ "github.com/bobappleyard/ts/ext/system"
 	_ "github.com/bobappleyard/ts/ext/web"
@@ -1,7 +1,9 @@
 package sync
 
 import (
-	"sync"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/bobappleyard/ts"
 )
 
@@ -9,51 +11,126 @@ func init() {
 	ts.RegisterExtension("sync", pkg)
 }
 
+func arity(args []*ts.Object, n int) {
+	if len(args) != n {
+		panic(ts.ArgError(len(args)))
+	}
+}
+
+// A function run by spawn(), once it has finished.
+type task struct {
+	done chan bool
+	res *ts.Object
+	err error
+}
+
 func pkg(itpr *ts.Interpreter) map[string] *ts.Object {
+	// Blocking operations give up if the code running them is asked to stop,
+	// so the mutex is a channel rather than a sync.Mutex.
+	lock := func(ctx context.Context, m chan bool) {
+		select {
+		case m <- true:
+		case <-ctx.Done():
+			itpr.Check(ctx)
+		}
+	}
+
 	MutexClass := ts.ObjectClass.Extend(itpr, "Mutex", ts.UserData, []ts.Slot {
 		ts.MSlot("create", func(o *ts.Object) *ts.Object {
-			o.SetUserData(new(sync.Mutex))
+			o.SetUserData(make(chan bool, 1))
 			return ts.Nil
 		}),
-		ts.MSlot("lock", func(o *ts.Object) *ts.Object {
-			o.UserData().(*sync.Mutex).Lock()
+		ts.MSlot("lock", func(ctx context.Context, o *ts.Object, args []*ts.Object) *ts.Object {
+			arity(args, 0)
+			lock(ctx, o.UserData().(chan bool))
 			return ts.Nil
 		}),
 		ts.MSlot("unlock", func(o *ts.Object) *ts.Object {
-			o.UserData().(*sync.Mutex).Unlock()
+			select {
+			case <-o.UserData().(chan bool):
+			default:
+				panic(fmt.Errorf("unlock of unlocked mutex"))
+			}
 			return ts.Nil
 		}),
-		ts.MSlot("with", func(o, f *ts.Object) *ts.Object {
-			m := o.UserData().(*sync.Mutex)
-			m.Lock()
-			defer m.Unlock()
-			return f.Call(nil)
+		ts.MSlot("with", func(ctx context.Context, o *ts.Object, args []*ts.Object) *ts.Object {
+			arity(args, 1)
+			m := o.UserData().(chan bool)
+			lock(ctx, m)
+			defer func() {
+				<-m
+			}()
+			return args[0].CallContext(ctx, nil)
 		}),
 	})
-	
+
 	ChanClass := ts.ObjectClass.Extend(itpr, "Channel", ts.UserData, []ts.Slot {
 		ts.MSlot("create", func(o *ts.Object) *ts.Object {
 			o.SetUserData(make(chan *ts.Object))
 			return ts.Nil
 		}),
-		ts.MSlot("send", func(o, x *ts.Object) *ts.Object {
-			o.UserData().(chan *ts.Object) <- x
+		ts.MSlot("send", func(ctx context.Context, o *ts.Object, args []*ts.Object) *ts.Object {
+			arity(args, 1)
+			select {
+			case o.UserData().(chan *ts.Object) <- args[0]:
+			case <-ctx.Done():
+				itpr.Check(ctx)
+			}
 			return ts.Nil
 		}),
-		ts.MSlot("receive", func(o *ts.Object) *ts.Object {
-			return <- o.UserData().(chan *ts.Object)
+		ts.MSlot("receive", func(ctx context.Context, o *ts.Object, args []*ts.Object) *ts.Object {
+			arity(args, 0)
+			select {
+			case x := <-o.UserData().(chan *ts.Object):
+				return x
+			case <-ctx.Done():
+				itpr.Check(ctx)
+			}
+			return ts.Nil
+		}),
+	})
+
+	// Waiting for a task gives what its function returned, or raises the error
+	// the function raised. A task stopped by the host raises that.
+	TaskClass := ts.ObjectClass.Extend(itpr, "Task", ts.UserData|ts.Final, []ts.Slot {
+		ts.MSlot("wait", func(ctx context.Context, o *ts.Object, args []*ts.Object) *ts.Object {
+			arity(args, 0)
+			t := o.UserData().(*task)
+			select {
+			case <-t.done:
+			case <-ctx.Done():
+				itpr.Check(ctx)
+			}
+			// raise the error the task raised, rather than one made from it
+			var se *ts.ScriptError
+			if errors.As(t.err, &se) {
+				panic(se.Object)
+			}
+			if t.err != nil {
+				panic(t.err)
+			}
+			return t.res
 		}),
 	})
-	
+
 	return map[string] *ts.Object {
-		"spawn": ts.Wrap(func(o, f *ts.Object) *ts.Object {
-			go f.Call(nil)
-			return ts.Nil
+		"spawn": ts.Wrap(func(ctx context.Context, o *ts.Object, args []*ts.Object) *ts.Object {
+			arity(args, 1)
+			f := args[0]
+			t := &task{done: make(chan bool), res: ts.Nil}
+			errs := itpr.Go(ctx, func(ctx context.Context) {
+				t.res = f.CallContext(ctx, nil)
+			})
+			go func() {
+				t.err = <-errs
+				close(t.done)
+			}()
+			res := TaskClass.New()
+			res.SetUserData(t)
+			return res
 		}),
 		"Mutex": MutexClass.Object(),
 		"Channel": ChanClass.Object(),
 	}
 }
 
-
-
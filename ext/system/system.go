@@ -1,6 +1,7 @@
 package system
 
 import (
+	"context"
 	"io"
 	"os"
 	"strings"
@@ -23,48 +24,59 @@ func pkg(itpr *ts.Interpreter) map[string] *ts.Object {
 	
 	File = ts.ObjectClass.Extend(itpr, "File", 0, []ts.Slot {
 		ts.FSlot("path", ts.Nil),
-		ts.MSlot("read", func(o, f *ts.Object) *ts.Object {
-			fl, err := os.Open(File.Get(o, 0).ToString())
+		ts.MSlot("read", func(ctx context.Context, o *ts.Object, args []*ts.Object) *ts.Object {
+			if len(args) != 1 {
+				panic(ts.ArgError(len(args)))
+			}
+			fl, err := itpr.OpenFile(File.Get(o, 0).ToString(), os.O_RDONLY, 0)
 			if err != nil {
 				panic(err)
 			}
 			defer fl.Close()
-			return f.Call(nil, newStream(fl))
+			return args[0].CallContext(ctx, nil, newStream(fl))
 		}),
 		ts.MSlot("create", func(o, p *ts.Object) *ts.Object {
 			File.Set(o, 0, p)
 			return ts.Nil
 		}),
 		ts.MSlot("exists", func(o *ts.Object) *ts.Object {
-			_, err := os.Stat(File.Get(o, 0).ToString())
+			_, err := itpr.Stat(File.Get(o, 0).ToString())
 			return ts.Wrap(err == nil)
 		}),
 		ts.MSlot("open", func(o, f, p *ts.Object) *ts.Object {
 			path := File.Get(o, 0).ToString()
 			flags, perm := int(f.ToInt()), os.FileMode(int(p.ToInt()))
-			fl, err := os.OpenFile(path, flags, perm)
+			fl, err := itpr.OpenFile(path, flags, perm)
 			if err != nil {
 				panic(err)
 			}
 			return newStream(fl)
 		}),
-		ts.MSlot("write", func(o, f *ts.Object) *ts.Object {
-			fl, err := os.Create(File.Get(o, 0).ToString())
+		ts.MSlot("write", func(ctx context.Context, o *ts.Object, args []*ts.Object) *ts.Object {
+			if len(args) != 1 {
+				panic(ts.ArgError(len(args)))
+			}
+			path := File.Get(o, 0).ToString()
+			flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+			fl, err := itpr.OpenFile(path, flags, 0666)
 			if err != nil {
 				panic(err)
 			}
 			defer fl.Close()
-			return f.Call(nil, newStream(fl))
+			return args[0].CallContext(ctx, nil, newStream(fl))
 		}),
-		ts.MSlot("append", func(o, f *ts.Object) *ts.Object {
+		ts.MSlot("append", func(ctx context.Context, o *ts.Object, args []*ts.Object) *ts.Object {
+			if len(args) != 1 {
+				panic(ts.ArgError(len(args)))
+			}
 			path := File.Get(o, 0).ToString()
 			flags := os.O_WRONLY | os.O_APPEND | os.O_CREATE
-			fl, err := os.OpenFile(path, flags, 0666)
+			fl, err := itpr.OpenFile(path, flags, 0666)
 			if err != nil {
 				panic(err)
 			}
 			defer fl.Close()
-			return f.Call(nil, newStream(fl))
+			return args[0].CallContext(ctx, nil, newStream(fl))
 		}),
 	})
 	
@@ -105,18 +117,22 @@ func pkg(itpr *ts.Interpreter) map[string] *ts.Object {
 	})
 
 	env := map[*ts.Object]*ts.Object {}
-	for _, x := range os.Environ() {
-		ss := strings.Split(x, "=")
+	for _, x := range itpr.Environ() {
+		ss := strings.SplitN(x, "=", 2)
 		env[ts.Wrap(ss[0])] = ts.Wrap(ss[1])
 	}
 	
-	return map[string] *ts.Object {
-		"input": newStream(os.Stdin),
-		"output": newStream(os.Stdout),
+	res := map[string] *ts.Object {
 		"File": File.Object(),
-		"args": ts.Wrap(os.Args),
+		"args": ts.Wrap(itpr.Args()),
 		"env": ts.Wrap(env),
 	}
+	// a sandbox may withhold these
+	if in, out := itpr.Stdio(); in != nil {
+		res["input"] = newStream(in)
+		res["output"] = newStream(out)
+	}
+	return res
 }
 
 
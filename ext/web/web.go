@@ -1,6 +1,7 @@
 package web
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"net/http"
@@ -9,8 +10,22 @@ import (
 	_ "github.com/bobappleyard/ts/ext/system"
 )
 
+// Implements http.Handler with the serveHTTP() method of an object.
+type handler struct {
+	serve func(http.ResponseWriter, *http.Request)
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r)
+}
+
 func init() {
 	ts.RegisterExtension("web", pkg)
+	ts.RegisterInterface(func(m *ts.Methods) http.Handler {
+		var h handler
+		m.Bind("serveHTTP", &h.serve)
+		return h
+	})
 }
 
 func pkg(itpr *ts.Interpreter) map[string] *ts.Object {
@@ -89,7 +104,11 @@ func pkg(itpr *ts.Interpreter) map[string] *ts.Object {
 	}
 
 	return map[string] *ts.Object {
-		"serve": ts.Wrap(func(o, p, f *ts.Object) *ts.Object {
+		"serve": ts.Wrap(func(ctx context.Context, o *ts.Object, args []*ts.Object) *ts.Object {
+			if len(args) != 2 {
+				panic(ts.ArgError(len(args)))
+			}
+			p, f := args[0], args[1]
 			port := ":" + strconv.Itoa(int(p.ToInt()))
 			hnd := func(w http.ResponseWriter, r *http.Request) {
 				defer func() {
@@ -97,9 +116,16 @@ func pkg(itpr *ts.Interpreter) map[string] *ts.Object {
 						fmt.Println("web.serve:", e)
 					}
 				}()
-				f.Call(nil, wrapResp(w), wrapReq(r))
+				f.CallContext(ctx, nil, wrapResp(w), wrapReq(r))
 			}
-			http.ListenAndServe(port, http.HandlerFunc(hnd))
+			// the server stops when the code that started it is asked to
+			srv := &http.Server{Addr: port, Handler: http.HandlerFunc(hnd)}
+			stop := context.AfterFunc(ctx, func() {
+				srv.Close()
+			})
+			defer stop()
+			srv.ListenAndServe()
+			itpr.Check(ctx)
 			return ts.Nil
 		}),
 		"get": ts.Wrap(func(o, u *ts.Object) *ts.Object {
@@ -0,0 +1,174 @@
+package ts_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+func TestGenerators(t *testing.T) {
+	expectAll(t, []scriptTest{
+		{"items", `
+			def g()
+				yield 1;
+				yield;
+			end;
+			def it = g();
+			[it.next(), it.next(), it.next() == done, it.next() == done];
+		`, "[1, nil, true, true]"},
+		{"arguments", `
+			def g(a, b)
+				yield a;
+				yield b;
+			end;
+			def r = [];
+			for x in g(1, 2) do
+				r.push(x);
+			end;
+			r;
+		`, "[1, 2]"},
+		{"lazy", `
+			def r = [];
+			def g()
+				r.push("started");
+				yield 1;
+			end;
+			def it = g();
+			def before = r.size;
+			it.next();
+			[before, r];
+		`, "[0, [started]]"},
+		{"infinite", `
+			def naturals()
+				def i = 0;
+				while true do
+					yield i;
+					i = i + 1;
+				end;
+			end;
+			slurp(take(3, naturals()));
+		`, "[0, 1, 2]"},
+		{"independent", `
+			def g()
+				yield 1;
+				yield 2;
+			end;
+			def a = g(), b = g();
+			[a.next(), a.next(), b.next()];
+		`, "[1, 2, 1]"},
+		{"iterator", "def g() yield 1; end; g().is(Iterator);", "true"},
+		{"yield value", `
+			def g()
+				def x = yield 1;
+				yield x;
+			end;
+			def it = g();
+			[it.next(), it.next()];
+		`, "[1, nil]"},
+		{"return", `
+			def g(x)
+				return x;
+				yield 1;
+			end;
+			g(3).next() == done;
+		`, "true"},
+		{"method", `
+			class A()
+				def x = 7;
+				def items()
+					yield this.x;
+				end;
+			end;
+			A().items().next();
+		`, "7"},
+		{"fn", "def g = fn(x) yield x * 2; end; g(4).next();", "8"},
+		{"inner fn", `
+			def g()
+				def f()
+					yield 1;
+				end;
+				return 5;
+			end;
+			g();
+		`, "5"},
+		{"finally", `
+			def r = [];
+			def g()
+				try
+					yield 1;
+				finally
+					r.push("f");
+				end;
+			end;
+			def it = g();
+			[it.next(), it.next() == done, r];
+		`, "[1, true, [f]]"},
+		{"catch inside", `
+			def g()
+				try
+					yield 1;
+					throw("boom");
+				catch e
+					yield "caught " + e.msg;
+				end;
+			end;
+			def it = g();
+			[it.next(), it.next(), it.next() == done];
+		`, "[1, caught boom, true]"},
+		{"catch outside", `
+			def g()
+				yield 1;
+				throw("in gen");
+			end;
+			def it = g();
+			it.next();
+			def e = catch(fn() = it.next());
+			[e.msg, it.next() == done];
+		`, "[in gen, true]"},
+	})
+}
+
+func TestGeneratorErrors(t *testing.T) {
+	expectErrors(t, []scriptTest{
+		{"top level", "yield 1;", "unexpected yield"},
+		{"arity", "def g(a) yield a; end; g();", "wrong number of arguments 0"},
+		{"next arity", "def g() yield 1; end; g().next(1);", "wrong number of arguments 1"},
+		{"raised", `
+			def g()
+				yield 1;
+				throw("in gen");
+			end;
+			def it = g();
+			it.next();
+			it.next();
+		`, "in gen"},
+		{"reentered", `
+			def it;
+			def g()
+				yield it.next();
+			end;
+			it = g();
+			it.next();
+		`, "generator is already running"},
+	})
+}
+
+// A generator runs under the limits of the code asking for its items, and its
+// catch clauses do not stop it going beyond them.
+func TestGeneratorLimit(t *testing.T) {
+	_, err := run(t, ts.New(), 20*time.Second, `
+		def g()
+			try
+				while true do end;
+			catch e
+				yield "caught";
+			end;
+		end;
+		g().next();
+	`, ts.Limits{Instructions: 10000})
+	var l *ts.LimitError
+	if !errors.As(err, &l) {
+		t.Errorf("got %v, want a limit", err)
+	}
+}
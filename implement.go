@@ -0,0 +1,203 @@
+package ts
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*******************************************************************************
+
+	Implementing Go interfaces
+
+*******************************************************************************/
+
+// Looks up the methods of an object on behalf of an adapter registered with
+// RegisterInterface().
+type Methods struct {
+	o *Object
+	missing []string
+}
+
+// The object whose methods are being looked up.
+func (m *Methods) Object() *Object {
+	return m.o
+}
+
+// Store a Go function that calls the named method in the variable that f
+// points to. Arguments are converted with Wrap() and results with Unwrap(), as
+// for any function Unwrap() produces. A property is read by calling the
+// function. If f is nil the method need only exist. A missing method is noted,
+// to be reported by Implement(). Panics if f is not a pointer to a function.
+func (m *Methods) Bind(name string, f interface{}) {
+	e := m.o.findSlot(name)
+	if e == nil {
+		m.missing = append(m.missing, name)
+		return
+	}
+	if f == nil {
+		return
+	}
+	v := reflect.ValueOf(f)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Func {
+		panic(fmt.Errorf("cannot bind %s to %T", name, f))
+	}
+	x := m.o.get(nil, nil, e)
+	if e.Flags.Kind() == Property {
+		// read the property on each call
+		x = Wrap(func(_ *Object) *Object {
+			return m.o.get(nil, nil, e)
+		})
+	}
+	v.Elem().Set(unwrapFunc(x, v.Elem().Type()))
+}
+
+var interfaces = struct {
+	sync.RWMutex
+	adapters map[reflect.Type] func(*Methods) interface{}
+}{adapters: map[reflect.Type] func(*Methods) interface{}{}}
+
+// Inform the system how to implement the interface I using an object's
+// methods. Go cannot add methods to a type at run time, so the adapter returns
+// a value of some type written for the purpose, whose methods call the
+// functions bound by Methods.Bind(). For instance:
+//
+//	type processor struct {
+//		process func(string) (string, error)
+//	}
+//
+//	func (p processor) Process(s string) (string, error) {
+//		return p.process(s)
+//	}
+//
+//	ts.RegisterInterface(func(m *ts.Methods) Processor {
+//		var p processor
+//		m.Bind("process", &p.process)
+//		return p
+//	})
+//
+// Adapters are provided for io.Closer and the interfaces that embed it along
+// with io.Reader and io.Writer, and sort.Interface. The web extension provides
+// one for http.Handler. Adapters may be registered at any time, and one
+// registered for an interface replaces any registered before.
+func RegisterInterface[I any](adapter func(*Methods) I) {
+	interfaces.Lock()
+	defer interfaces.Unlock()
+	interfaces.adapters[reflect.TypeOf((*I)(nil)).Elem()] = func(m *Methods) interface{} {
+		return adapter(m)
+	}
+}
+
+// Get a Go value of the interface type I that calls the methods of o. This is
+// the first of:
+//
+// 	* o's user data, if that implements I.
+// 	* a value built by the adapter registered for I with RegisterInterface().
+// 	* o itself, if *Object implements I. Objects implement io.Reader and
+// 	  io.Writer through their readBuffer() and writeBuffer() methods, and
+// 	  fmt.Stringer and error through toString().
+//
+// Returns an error naming the methods o lacks, rather than failing when they
+// are called. Other interfaces, including those the host defines, cannot be
+// implemented until an adapter is registered for them.
+func Implement[I any](o *Object) (I, error) {
+	var res I
+	t := reflect.TypeOf((*I)(nil)).Elem()
+	if t.Kind() != reflect.Interface {
+		return res, fmt.Errorf("not an interface: %s", t)
+	}
+	if x, ok := o.data.(I); ok {
+		return x, nil
+	}
+	interfaces.RLock()
+	adapter := interfaces.adapters[t]
+	interfaces.RUnlock()
+	if adapter != nil {
+		m := &Methods{o: o}
+		x := adapter(m)
+		if len(m.missing) != 0 {
+			return res, fmt.Errorf("%s does not implement %s: missing %s",
+				className(o), t, strings.Join(m.missing, ", "))
+		}
+		return x.(I), nil
+	}
+	if x, ok := interface{}(o).(I); ok {
+		return x, nil
+	}
+	return res, fmt.Errorf("cannot implement %s: no adapter registered", t)
+}
+
+// Find a public slot by name on the object's class or its ancestors.
+func (o *Object) findSlot(n string) *Slot {
+	for c := o.c; c != nil; c = c.a {
+		for i := range c.e {
+			e := &c.e[i]
+			if e.Name == n && e.Flags.Kind() != Marker && e.Flags.Vis() == Public {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+type closer struct {
+	*Object
+	close func() error
+}
+
+func (c closer) Close() error {
+	return c.close()
+}
+
+type sortable struct {
+	len func() int
+	less func(i, j int) bool
+	swap func(i, j int)
+}
+
+func (s sortable) Len() int {
+	return s.len()
+}
+
+func (s sortable) Less(i, j int) bool {
+	return s.less(i, j)
+}
+
+func (s sortable) Swap(i, j int) {
+	s.swap(i, j)
+}
+
+func init() {
+	bindCloser := func(m *Methods) closer {
+		c := closer{Object: m.Object()}
+		m.Bind("close", &c.close)
+		return c
+	}
+	RegisterInterface(func(m *Methods) io.Closer {
+		return bindCloser(m)
+	})
+	RegisterInterface(func(m *Methods) io.ReadCloser {
+		m.Bind("readBuffer", nil)
+		return bindCloser(m)
+	})
+	RegisterInterface(func(m *Methods) io.WriteCloser {
+		m.Bind("writeBuffer", nil)
+		return bindCloser(m)
+	})
+	RegisterInterface(func(m *Methods) io.ReadWriteCloser {
+		m.Bind("readBuffer", nil)
+		m.Bind("writeBuffer", nil)
+		return bindCloser(m)
+	})
+	RegisterInterface(func(m *Methods) sort.Interface {
+		var s sortable
+		m.Bind("size", &s.len)
+		m.Bind("less", &s.less)
+		m.Bind("swap", &s.swap)
+		return s
+	})
+}
+
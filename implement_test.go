@@ -0,0 +1,98 @@
+package ts_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+	_ "github.com/bobappleyard/ts/ext/web"
+)
+
+type processor interface {
+	Process(s string) (string, error)
+}
+
+type proc struct {
+	process func(string) (string, error)
+}
+
+func (p proc) Process(s string) (string, error) {
+	return p.process(s)
+}
+
+func eval(t *testing.T, i *ts.Interpreter, src string) *ts.Object {
+	x, err := run(t, i, 20*time.Second, src, ts.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+// Scripts serve HTTP requests through the adapter for http.Handler, which the
+// web extension provides.
+func TestImplementHandler(t *testing.T) {
+	i := ts.New()
+	o := eval(t, i, `
+		class Greeter()
+			def serveHTTP(w, r)
+				w.writeHeader(201);
+				w.write(("hello " + r.url.path).toBuffer());
+			end;
+		end;
+		Greeter();
+	`)
+	h, err := ts.Implement[http.Handler](o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/world", nil))
+	if w.Code != 201 || w.Body.String() != "hello /world" {
+		t.Errorf("got %d %q", w.Code, w.Body.String())
+	}
+	if _, err := ts.Implement[http.Handler](eval(t, i, "Object();")); err == nil {
+		t.Error("implemented http.Handler without serveHTTP")
+	}
+}
+
+// Interfaces the host defines need an adapter, which may be registered while
+// other interfaces are being implemented.
+func TestImplementRegistered(t *testing.T) {
+	i := ts.New()
+	o := eval(t, i, `
+		class Doubler()
+			def process(s) = s + s;
+		end;
+		Doubler();
+	`)
+	_, err := ts.Implement[processor](o)
+	if err == nil || !strings.Contains(err.Error(), "no adapter registered") {
+		t.Fatalf("before registering: %v", err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		ts.RegisterInterface(func(m *ts.Methods) processor {
+			var p proc
+			m.Bind("process", &p.process)
+			return p
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		ts.Implement[io.Closer](o)
+	}()
+	wg.Wait()
+	p, err := ts.Implement[processor](o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := p.Process("abc"); s != "abcabc" || err != nil {
+		t.Errorf("got %q, %v", s, err)
+	}
+}
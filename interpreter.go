@@ -1,10 +1,15 @@
 package ts
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"strings"
 	"encoding/binary"
 	. "github.com/bobappleyard/ts/bytecode"
@@ -40,6 +45,9 @@ type Class struct {
 	a, p *Class
 	e []Slot
 	m, f []*Object
+	t []*Class // the traits a trait includes
+	mix *Class // the trait a class was made from, if any
+	mixes map[*Class]*Class // classes made from a trait, by ancestor
 }
 
 const (
@@ -47,12 +55,13 @@ const (
 	Abstract
 	UserData
 	Anon
+	Trait
 )
 
 // Accessors refer to names that can be looked up on objects.
 type Accessor struct {
 	n string
-	e []Slot
+	e atomic.Pointer[[]Slot]
 	o *Object
 }
 
@@ -83,15 +92,36 @@ type Slot struct {
 	Flags SlotFlags
 	Value, Set *Object
 	Class *Class
-	offset, access, next uint16
+	offset, access, next uint32
 }
 
 // An interpreter provides a global environment and some methods to control
 // the general flow of execution.
+//
+// An interpreter may be used from several goroutines at once. Defining globals
+// and classes, looking up accessors and loading packages are all safe to do
+// concurrently. The values that running code shares are not protected in the
+// same way: see the package documentation.
 type Interpreter struct {
+	mu sync.RWMutex // guards o, a, limits and whether globals are defined
 	o map[string] *Object
 	a map[string] *Accessor
-	c []*Class
+	limits Limits
+	sandbox *sandbox
+}
+
+// Bounds on the resources that running code may use. A zero field means that
+// there is no bound on that resource.
+//
+// The objects counted are those the code makes: instances of classes,
+// functions, generators and the new values given by calls into Go, each of
+// which counts once however much the Go code allocated. Each call to
+// ExecLimits() counts its own.
+type Limits struct {
+	Instructions int64 // bytecode instructions executed
+	Stack int // values on the stacks of a process and those it is nested in
+	Frames int // frames on the stacks of a process and those it is nested in
+	Allocs int64 // objects allocated, approximately
 }
 
 // A unit represents some compiled code. 
@@ -99,9 +129,22 @@ type Unit struct {
 	v, g []*Object
 	a []*Accessor
 	gn, an []string
-	b [][]uint16
+	b [][]uint32
 	path, file string
-	line int
+	fn []string // the names functions were defined with, by block
+	src [][]srcPos // by block, in order of offset
+	opt int // how much to optimise code as it is compiled
+	ic [][]*cacheSite // by block and offset
+	i *Interpreter
+	vi, gi, ai tableIndex // for finding values, globals and accessors
+}
+
+// Where the code at an offset into a block came from. The position holds until
+// the next entry in the block's table.
+type srcPos struct {
+	offset int
+	file string
+	line, col int
 }
 
 // Dymamic scope record.
@@ -109,18 +152,35 @@ type frame struct {
 	t *Object
 	sc *Class
 	e []*Object
-	c []uint16
+	c []uint32
 	p, n, b int
 	u *Unit
+	k int // the block being run
+	id int // distinguishes calls to the same function
+}
+
+// Where to go when an error is raised, as set up by a try statement.
+type handler struct {
+	f frame
+	frames, stack int
+	finally bool // whether it runs a finally block rather than catch clauses
 }
 
 // A running computation.
 type process struct {
 	frame
-	v, file *Object
-	line int
+	v *Object
 	s []*Object
 	frames []frame
+	handlers []handler
+	traced *Object // the last error caught by a handler
+	gen *generator
+	kw map[string] *Object // keyword arguments to the call being made
+	calls int
+	ctl *control
+	sub control // for code that the process calls
+	fuel int64
+	maxStack, maxFrames int // left to the process by the processes it is nested in
 }
 
 /*******************************************************************************
@@ -178,18 +238,22 @@ func (i *Interpreter) Eval(s string) *Object {
 // Load a code file into the interpreter. May be in source or compiled form.
 // Panics on error.
 func (i *Interpreter) Load(p string) {
-	u := new(Unit)
-	u.path = p
 	f, err := os.Open(p)
 	if err != nil {
 		panic(err)
 	}
 	defer f.Close()
+	i.loadFile(nil, f, p)
+}
+
+func (i *Interpreter) loadFile(c *control, f *os.File, p string) {
+	u := new(Unit)
+	u.path = p
 	if !u.Load(f) {
 		f.Seek(0, 0)
 		u.Compile(f, p)
 	}
-	i.Exec(u)
+	i.execUnder(u, c)
 }
 
 // Import a package and return it.
@@ -200,21 +264,106 @@ func (i *Interpreter) Import(n string) *Object {
 
 // Run some compiled code. Panics on error.
 func (i *Interpreter) Exec(u *Unit) *Object {
+	return i.execUnder(u, nil)
+}
+
+// Run some code under a control, which is nil for code that Go code runs of
+// its own accord. The limits on the interpreter apply to that code.
+func (i *Interpreter) execUnder(u *Unit, c *control) *Object {
+	if c == nil && i.getLimits() != (Limits{}) {
+		return i.controlled(context.Background(), u, Limits{})
+	}
+	return i.exec(u, c)
+}
+
+func (i *Interpreter) exec(u *Unit, c *control) *Object {
 	u.link(i)
 	p := new(process).init()
+	p.control(c)
 	p.frame = frame{c: u.b[0], u: u}
 	p.run()
 	return p.v
 }
 
+// Evaluate an expression as Eval(), returning an error rather than panicking.
+func (i *Interpreter) EvalErr(s string) (x *Object, err error) {
+	defer catchError(&err)
+	return i.Eval(s), nil
+}
+
+// Load a code file as Load(), returning an error rather than panicking.
+func (i *Interpreter) LoadErr(p string) (err error) {
+	defer catchError(&err)
+	i.Load(p)
+	return nil
+}
+
+// Import a package as Import(), returning an error rather than panicking.
+func (i *Interpreter) ImportErr(n string) (x *Object, err error) {
+	defer catchError(&err)
+	return i.Import(n), nil
+}
+
+// Run some compiled code as Exec(), returning an error rather than panicking.
+func (i *Interpreter) ExecErr(u *Unit) (x *Object, err error) {
+	defer catchError(&err)
+	return i.Exec(u), nil
+}
+
+// Run some compiled code as Exec(), stopping when ctx is done. The context
+// governs the code that this call runs, including code that Go functions it
+// calls run in the interpreter when they pass on the context they are given,
+// and code on goroutines started with Go(). Other calls running at the same
+// time are not affected. Cancellation is reported as an error wrapping the
+// context's error.
+func (i *Interpreter) ExecContext(ctx context.Context, u *Unit) (*Object, error) {
+	return i.ExecLimits(ctx, u, Limits{})
+}
+
+// Run some compiled code as ExecContext(), within some limits. These apply 
+// along with the limits on the interpreter and those on any call to 
+// ExecLimits() that is already running. Going beyond a limit is reported as a
+// *LimitError.
+func (i *Interpreter) ExecLimits(ctx context.Context, u *Unit, l Limits) (x *Object, err error) {
+	defer catchError(&err)
+	return i.controlled(ctx, u, l), nil
+}
+
+// Evaluate an expression as Eval(), stopping when ctx is done.
+func (i *Interpreter) EvalContext(ctx context.Context, s string) (*Object, error) {
+	u := new(Unit)
+	if err := u.CompileErr(strings.NewReader(s), "unknown"); err != nil {
+		return nil, err
+	}
+	return i.ExecContext(ctx, u)
+}
+
+// Set the limits that apply whenever Go code calls into the interpreter. Code
+// that is already running keeps the limits that it started with.
+func (i *Interpreter) SetLimits(l Limits) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.limits = l
+}
+
+func (i *Interpreter) getLimits() Limits {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.limits
+}
+
 // Check whether a global variable is defined.
 func (i *Interpreter) Defined(n string) bool {
 	b := i.lookup(n)
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	return b.c == boxClass
 }
 
 // List the global variables
 func (i *Interpreter) ListDefined() []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	res := []string{}
 	for n, v := range i.o {
 		if v.c == boxClass {
@@ -226,9 +375,11 @@ func (i *Interpreter) ListDefined() []string {
 
 // List the available accessors.
 func (i *Interpreter) ListAccessors() []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	res := []string{}
 	for k, v := range i.a {
-		if len(v.e) != 0 {
+		if len(v.entries()) != 0 {
 			res = append(res, k)
 		}
 	}
@@ -239,6 +390,8 @@ func (i *Interpreter) ListAccessors() []string {
 // not exist.
 func (i *Interpreter) Get(n string) *Object {
 	o := i.lookup(n)
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	if o.c == undefinedClass {
 		panic(fmt.Errorf("undefined variable: %s", n))
 	}
@@ -249,6 +402,8 @@ func (i *Interpreter) Get(n string) *Object {
 // exist.
 func (i *Interpreter) Set(n string, v *Object) {
 	o := i.lookup(n)
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	if o.c == undefinedClass {
 		panic(fmt.Errorf("undefined variable: %s", n))
 	}
@@ -258,8 +413,10 @@ func (i *Interpreter) Set(n string, v *Object) {
 // Define a new global variable.
 func (i *Interpreter) Define(n string, v *Object) {
 	b := i.lookup(n)
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	b.c = boxClass
-	b.setBoxData(v)
+	b.data = v
 }
 
 /*******************************************************************************
@@ -283,14 +440,12 @@ func (o *Object) Read(b []byte) (n int, err error) {
 	if r, ok := o.UserData().(io.Reader); ok {
 		return r.Read(b)
 	}
-	e := o.c.IndexOf("readBuffer")
-	if e == -1 {
+	e := o.findSlot("readBuffer")
+	if e == nil {
 		return 0, Undefined
 	}
-	defer func() {
-		err = recover().(error)
-	}()
-	res := o.c.Call(o, e, Wrap(b))
+	defer catchError(&err)
+	res := o.callMethod(o.getMethod(nil, nil, e), []*Object{Wrap(b)})
 	if res == False {
 		return 0, io.EOF
 	}
@@ -303,14 +458,12 @@ func (o *Object) Write(b []byte) (n int, err error) {
 	if r, ok := o.UserData().(io.Writer); ok {
 		return r.Write(b)
 	}
-	e := o.c.IndexOf("writeBuffer")
-	if e == -1 {
+	e := o.findSlot("writeBuffer")
+	if e == nil {
 		return 0, Undefined
 	}
-	defer func() {
-		err = recover().(error)
-	}()
-	res := o.c.Call(o, e, Wrap(b))
+	defer catchError(&err)
+	res := o.callMethod(o.getMethod(nil, nil, e), []*Object{Wrap(b)})
 	if res == False {
 		return 0, io.EOF
 	}
@@ -331,13 +484,13 @@ func (o *Object) Defined(a *Accessor) bool {
 // Get the slot to which the Accessor corresponds from the object. Panics if the 
 // slot does not exist.
 func (o *Object) Get(a *Accessor) *Object {
-	return o.get(a, nil)
+	return o.get(nil, a, nil)
 }
 
 // Set the corresponding slot on o to x. Panics if the slot does not exist
 // or cannot be written to.
 func (o *Object) Set(a *Accessor, x *Object) {
-	o.set(a, nil, x)
+	o.set(nil, a, nil, x)
 }
 
 // Call a function or method. If a is nil, o is the function to be called.
@@ -348,33 +501,65 @@ func (o *Object) Set(a *Accessor, x *Object) {
 func (o *Object) Call(a *Accessor, args... *Object) *Object {
 	f := o
 	if a != nil {
-		f = o.getMethod(a, nil)
+		f = o.getMethod(nil, a, nil)
 	}
 	return o.callMethod(f, args)
 }
 
+// Call a function or method as Call(), on behalf of the code that ctx governs.
+// Go functions that the interpreter gives a context to should call back into it
+// this way, so that the code they run stops when the code that called them is
+// asked to stop, and counts against the same limits.
+func (o *Object) CallContext(ctx context.Context, a *Accessor, args... *Object) *Object {
+	c := controlFor(ctx)
+	f := o
+	if a != nil {
+		f = o.getMethod(c, a, nil)
+	}
+	return o.callFrom(c, f, args, nil)
+}
+
 func (c *Class) Get(o *Object, i int) *Object {
 	o.checkClass(o.Is(c))
-	return o.get(nil, &c.e[i])
+	return o.get(nil, nil, &c.e[i])
 }
 
 func (c *Class) Set(o *Object, i int, x *Object) {
 	o.checkClass(o.Is(c))
-	o.set(nil, &c.e[i], x)
+	o.set(nil, nil, &c.e[i], x)
 }
 
 func (c *Class) Call(o *Object, i int, args... *Object) *Object {
 	o.checkClass(o.Is(c))
-	return o.callMethod(o.getMethod(nil, &c.e[i]), args)
+	return o.callMethod(o.getMethod(nil, nil, &c.e[i]), args)
 }
 
 func (o *Object) callMethod(f *Object, args []*Object) *Object {
+	return o.callKeywords(f, args, nil)
+}
+
+func (o *Object) callKeywords(f *Object, args []*Object, kw map[string] *Object) *Object {
+	return o.callFrom(nil, f, args, kw)
+}
+
+// Call a function as callKeywords(), under a control. The Go code of a
+// primitive passes on the control of the process that called it, so that the
+// call is governed by the same context and limits.
+func (o *Object) callFrom(c *control, f *Object, args []*Object, kw map[string] *Object) *Object {
 	p := new(process).init()
+	p.control(c)
 	p.pushFrame(0)
 	for _, x := range args {
 		p.push(x)
 	}
 	p.n = len(args)
+	if len(kw) != 0 {
+		// the callee takes the keywords it binds out of the map
+		p.kw = make(map[string] *Object, len(kw))
+		for k, v := range kw {
+			p.kw[k] = v
+		}
+	}
 	p.t = o
 	f.funcData()(p)
 	p.run()
@@ -390,13 +575,14 @@ func (o *Object) bindMethod(m *Object) *Object {
 }
 
 // Internal get(): may have static class info provided for private access.
-func (o *Object) get(a *Accessor, e *Slot) *Object {
+// Methods that it calls run under the control c.
+func (o *Object) get(c *control, a *Accessor, e *Slot) *Object {
 	if e == nil {
 		e = a.lookup(o)
 	}
 	if e == nil {
 		ao := new(accObj).init(a)
-		return o.callMethod(o.c.m[_Object_getFailed], []*Object{ao})
+		return o.callFrom(c, o.c.m[_Object_getFailed], []*Object{ao}, nil)
 	}
 	switch e.Flags.Kind() {
 	case Field:
@@ -404,26 +590,26 @@ func (o *Object) get(a *Accessor, e *Slot) *Object {
 	case Method:
 		return o.bindMethod(o.c.m[e.offset])
 	case Property:
-		return o.getProperty(e)
+		return o.getProperty(c, e)
 	}
 	panic(fmt.Errorf("invalid location for reading"))
 }
 
 // Internal set(): may have static class info provided for private access.
-func (o *Object) set(a *Accessor, e *Slot, x *Object) {
+func (o *Object) set(c *control, a *Accessor, e *Slot, x *Object) {
 	if e == nil {
 		e = a.lookup(o)
 	}
 	if e == nil {
 		ao := new(accObj).init(a)
-		o.callMethod(o.c.m[_Object_setFailed], []*Object{ao, x})
+		o.callFrom(c, o.c.m[_Object_setFailed], []*Object{ao, x}, nil)
 		return
 	}
 	switch e.Flags.Kind() {
 	case Field:
 		o.f[e.offset] = x
 	case Property:
-		o.setProperty(e, x)
+		o.setProperty(c, e, x)
 	default:
 		panic(fmt.Errorf("invalid location for writing"))
 	}
@@ -431,7 +617,7 @@ func (o *Object) set(a *Accessor, e *Slot, x *Object) {
 
 // Internal get(): may have static class info provided for private access. 
 // Assumes slot is a method (and so has "this" provided to it).
-func (o *Object) getMethod(a *Accessor, e *Slot) *Object {
+func (o *Object) getMethod(c *control, a *Accessor, e *Slot) *Object {
 	if e == nil {
 		e = a.lookup(o)
 	}
@@ -444,33 +630,33 @@ func (o *Object) getMethod(a *Accessor, e *Slot) *Object {
 	case Method:
 		return o.c.m[e.offset]
 	case Property:
-		return o.getProperty(e)
+		return o.getProperty(c, e)
 	}
 	panic(fmt.Errorf("invalid location for calling"))
 }
 
 func (o *Object) methodMissing(a *Accessor) *Object {
 	ao := new(accObj).init(a)
-	return Wrap(func(o *Object, args []*Object) *Object {
+	return Wrap(func(ctx context.Context, o *Object, args []*Object) *Object {
 		args = append([]*Object{ao}, args...)
-		return o.callMethod(o.c.m[_Object_callFailed], args)
+		return o.callFrom(controlIn(ctx), o.c.m[_Object_callFailed], args, nil)
 	})
 }
 
-func (o *Object) getProperty(e *Slot) *Object {
+func (o *Object) getProperty(c *control, e *Slot) *Object {
 	m := o.c.m[e.offset]
 	if m == Nil {
 		panic(fmt.Errorf("invalid location for reading"))
 	}
-	return o.callMethod(m, nil)
+	return o.callFrom(c, m, nil, nil)
 }
 
-func (o *Object) setProperty(e *Slot, x *Object) {
+func (o *Object) setProperty(c *control, e *Slot, x *Object) {
 	m := o.c.m[e.offset+1]
 	if m == Nil {
 		panic(fmt.Errorf("invalid location for writing"))
 	}
-	o.callMethod(m, []*Object{x})
+	o.callFrom(c, m, []*Object{x}, nil)
 }
 
 /*******************************************************************************
@@ -494,9 +680,14 @@ func (c *Class) Name() string {
 	return c.n
 }
 
-// Get the class' ancestor.
+// Get the class' ancestor. Traits mixed into the class are skipped over. Traits
+// have no ancestor.
 func (c *Class) Ancestor() *Class {
-	return c.a
+	a := c.a
+	for a != nil && a.mix != nil {
+		a = a.a
+	}
+	return a
 }
 
 // Check if the object is an instance of c (or one of its descendants).
@@ -504,20 +695,34 @@ func (o *Object) Is(c *Class) bool {
 	return o.c.Is(c)
 }
 
-// Check if c is the same class as d, or one of d's descendants.
+// Check if c is the same class as d, or one of d's descendants. A class is also
+// d if d is a trait that has been mixed into it.
 func (c *Class) Is(d *Class) bool {
 	for cur := c; cur != nil; cur = cur.a {
-		if cur == d {
+		if cur == d || cur.mix == d && d != nil {
 			return true
 		}
+		if cur.flags & Trait != 0 {
+			for _, t := range cur.t {
+				if t.Is(d) {
+					return true
+				}
+			}
+		}
 	}
 	return false
 }
 
 // Create a descendant of c with the given name and a series of entries
-// describing the class' members. Panics if the class cannot be extended.
-func (c *Class) Extend(i *Interpreter, n string, flags int, e []Slot) *Class {
-	d := c.extend(n, flags, e)
+// describing the class' members, mixing in any traits given. Panics if the
+// class cannot be extended, if the traits conflict or if the class does not
+// define the methods the traits require.
+func (c *Class) Extend(i *Interpreter, n string, flags int, e []Slot, traits ...*Class) *Class {
+	names := make([]string, len(e))
+	for j := range e {
+		names[j] = e[j].Name
+	}
+	d := i.mixin(c, traits, names).extend(n, flags, e)
 	i.addClass(d)
 	return d
 }
@@ -573,12 +778,17 @@ func (c *Class) extend(n string, flags int, e []Slot) *Class {
 
 // This is the part that runs after the slots have been evaluated.
 func (i *Interpreter) addClass(c *Class) {
+	i.slotUnit(c.e).addClass(c)
+}
+
+// A unit with accessors for the names of some slots, which refer to them.
+func (i *Interpreter) slotUnit(e []Slot) *Unit {
 	u := new(Unit)
-	for i, x := range c.e {
-		c.e[i].access = uint16(u.getAccessor(x.Name))
+	for j, x := range e {
+		e[j].access = uint32(u.getAccessor(x.Name))
 	}
 	u.link(i)
-	u.addClass(c)
+	return u
 }
 
 var classLock = new(sync.Mutex)
@@ -587,6 +797,9 @@ func (u *Unit) addClass(c *Class) {
 	classLock.Lock()
 	defer classLock.Unlock()
 	u.clInner(c)
+	if c.flags & Abstract == 0 {
+		c.checkRequired()
+	}
 }
 
 func (u *Unit) clInner(c *Class) {
@@ -606,6 +819,24 @@ func (u *Unit) clInner(c *Class) {
 	}
 }
 
+// Make a class that has been added to another interpreter known to this one.
+// The class is left as it is, as code may be running that uses it: this
+// interpreter has its own copy of the class's slots, and its accessors are
+// given entries for those that the class defines.
+func (i *Interpreter) shareClass(c *Class) {
+	e := make([]Slot, len(c.e))
+	copy(e, c.e)
+	u := i.slotUnit(e)
+	classLock.Lock()
+	defer classLock.Unlock()
+	for _, x := range e {
+		// only public definitions go in the accessor
+		if x.Class == c && x.Flags.Kind() != Marker && x.Flags.Vis() == Public {
+			u.a[x.access].addEntry(x)
+		}
+	}
+}
+
 func (u *Unit) addSlot(c *Class, e *Slot) {
 	kind := e.Flags.Kind()
 	vis := e.Flags.Vis()
@@ -618,8 +849,9 @@ func (u *Unit) addSlot(c *Class, e *Slot) {
 	if kind == Field {
 		t = c.f
 	}
-	for i := range a.e {
-		f := &a.e[i]
+	es := a.entries()
+	for i := range es {
+		f := &es[i]
 		if c.Is(f.Class) {
 			// shadowing is where a name is defined that has already been
 			// defined in an ancestor class, and this definition is 
@@ -642,7 +874,7 @@ func (u *Unit) addSlot(c *Class, e *Slot) {
 		return
 	}
 	// add a new definition
-	e.offset = uint16(len(t))
+	e.offset = uint32(len(t))
 	e.Class = c
 	t = append(t, e.Value)
 	if kind == Property {
@@ -655,7 +887,7 @@ func (u *Unit) addSlot(c *Class, e *Slot) {
 	}
 	// only public definitions go in the accessor
 	if vis == Public {
-		a.e = append(a.e, *e)
+		a.addEntry(*e)
 	}
 }
 
@@ -692,10 +924,18 @@ func (i *Interpreter) Accessor(n string) *Accessor {
 	if n == "" {
 		return new(Accessor)
 	}
+	i.mu.RLock()
+	a := i.a[n]
+	i.mu.RUnlock()
+	if a != nil {
+		return a
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	if i.a == nil {
 		i.a = make(map[string] *Accessor)
 	}
-	a := i.a[n]
+	a = i.a[n]
 	if a == nil {
 		a = &Accessor{n: n}
 		a.o = new(accObj).init(a)
@@ -709,12 +949,29 @@ func (a *Accessor) Name() string {
 	return a.n
 }
 
+// The classes that define the accessor's name, and the slots they define for
+// it. Code may run while classes are being added, so the entries are never
+// changed in place, only replaced.
+func (a *Accessor) entries() []Slot {
+	if e := a.e.Load(); e != nil {
+		return *e
+	}
+	return nil
+}
+
+// Add an entry. The caller must hold classLock.
+func (a *Accessor) addEntry(e Slot) {
+	es := append(a.entries(), e)
+	a.e.Store(&es)
+}
+
 // Find the entry for the corresponding object. Returns that entry or nil if no 
 // entry can be found.
 func (a *Accessor) lookup(o *Object) *Slot {
 	c := o.c
-	for i := range a.e {
-		e := &a.e[i]
+	es := a.entries()
+	for i := range es {
+		e := &es[i]
 		if c.Is(e.Class) {
 			return e
 		}
@@ -724,7 +981,7 @@ func (a *Accessor) lookup(o *Object) *Slot {
 
 // For anonymous classes: lookup the appropriate skeleton class.
 func (a *Accessor) lookupa(c *Class) *Class {
-	for _, f := range a.e {
+	for _, f := range a.entries() {
 		if c == f.Class {
 			return f.Value.ToClass()
 		}
@@ -732,12 +989,12 @@ func (a *Accessor) lookupa(c *Class) *Class {
 	return nil
 }
 
-const slotUnknown = 0xffff
+const slotUnknown uint32 = 0xffffffff
 
 // For accessing when some information about the slot is statically known.
 func (p *process) lookups(m int) *Slot {
 	for cur := p.sc; cur != nil; cur = cur.p {
-		if m == slotUnknown {
+		if uint32(m) == slotUnknown {
 			break
 		}
 		e := &cur.e[m]
@@ -756,28 +1013,94 @@ func (p *process) lookups(m int) *Slot {
 *******************************************************************************/
 
 func (p *process) init() *process {
-	p.file = False
 	return p
 }
 
 func (p *process) run() {
 	defer func() {
+		p.release()
 		if e := recover(); e != nil {
-			panic(p.wrapError(e))
+			o := p.wrapError(e)
+			if o != p.traced {
+				o = p.addTrace(o)
+			}
+			panic(o)
+		}
+	}()
+	for p.steps() {
+	}
+}
+
+// Run the process until it finishes, or until an error is caught by a handler.
+// Returns whether an error was caught. Errors from the host asking the code to
+// stop pass by catch clauses, and are only caught to run finally blocks.
+func (p *process) steps() (caught bool) {
+	defer func() {
+		if len(p.handlers) != 0 {
+			if e := recover(); e != nil {
+				if isHostError(e) && !p.toFinally() {
+					panic(e)
+				}
+				p.catch(e)
+				caught = true
+			}
 		}
 	}()
 	for int(p.p) < len(p.c) {
+		if p.fuel == 0 {
+			p.refuel()
+		}
+		p.fuel--
 		p.step()
 	}
+	return false
+}
+
+// Drop the handlers inside the innermost one that runs a finally block.
+// Returns whether there is such a handler.
+func (p *process) toFinally() bool {
+	for l := len(p.handlers); l > 0; l-- {
+		if p.handlers[l-1].finally {
+			p.handlers = p.handlers[:l]
+			return true
+		}
+	}
+	p.handlers = nil
+	return false
+}
+
+// Pass an error to the innermost handler.
+func (p *process) catch(e interface{}) {
+	l := len(p.handlers)-1
+	h := p.handlers[l]
+	p.handlers = p.handlers[:l]
+	// the trace is taken from where the error was raised, even if a handler
+	// raises it again
+	o := p.wrapError(e)
+	if o != p.traced {
+		p.traced = p.addTrace(o)
+	}
+	p.frame = h.f
+	p.frames = p.frames[:h.frames]
+	p.s = p.s[:h.stack]
+	p.v = o
 }
 
 // Find a global variable. If one doesn't yet exist with that name, create a
 // global that has been marked as undefined.
 func (i *Interpreter) lookup(n string) *Object {
+	i.mu.RLock()
+	o := i.o[n]
+	i.mu.RUnlock()
+	if o != nil {
+		return o
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	if i.o == nil {
 		i.o = make(map[string] *Object)
 	}
-	o := i.o[n]
+	o = i.o[n]
 	if o == nil {
 		o = new(boxObj).init(Wrap(n))
 		o.c = undefinedClass
@@ -790,6 +1113,7 @@ func (p *process) parseArgs(vars... **Object) {
 	if p.n != len(vars) {
 		 panic(ArgError(p.n))
 	}
+	p.noKeywords()
 	for i, x := range p.s[p.b:] {
 		*vars[i] = x
 	}
@@ -800,6 +1124,9 @@ func (p *process) args() []*Object {
 }
 
 func (p *process) push(x *Object) {
+	if p.maxStack != 0 && len(p.s) >= p.maxStack {
+		panic(&LimitError{"stack", int64(p.ctl.stack)})
+	}
 	p.s = append(p.s, x)
 }
 
@@ -811,6 +1138,9 @@ func (p  *process) pop() *Object {
 }
 
 func (p *process) pushFrame(k int) {
+	if p.maxFrames != 0 && len(p.frames) >= p.maxFrames {
+		panic(&LimitError{"frames", int64(p.ctl.frames)})
+	}
 	f := p.frame
 	f.p = k
 	p.frames = append(p.frames, f)
@@ -834,6 +1164,14 @@ func (p *process) ret(x *Object) {
 	p.v = x
 }
 
+func (p *process) unbox() {
+	if p.v.c == undefinedClass {
+		s := p.v.boxData().ToString()
+		panic(fmt.Errorf("undefined variable: %s", s))
+	}
+	p.v = p.v.boxData()
+}
+
 func (p *process) next() int {
 	res := p.c[p.p]
 	p.p++
@@ -849,6 +1187,7 @@ func (p *process) close(block, n int, capture bool) {
 	e := make([]*Object, n)
 	copy(e, p.s[c:])
 	p.s = p.s[:c]
+	p.allocatedOne()
 	p.v = new(funcObj).init(func(p *process) {
 		p.c = b
 		p.p = 0 
@@ -858,30 +1197,94 @@ func (p *process) close(block, n int, capture bool) {
 		}
 		p.e = e
 		p.sc = sc
+		p.k = block
+		p.calls++
+		p.id = p.calls
 	})
 }
 
+// A call to a generator function, suspended between items.
+type generator struct {
+	p *process
+	f frame // where to carry on from
+	running bool
+}
+
+// Move the rest of the current call into a process of its own, returning an
+// iterator that runs it.
+func (p *process) generate() *Object {
+	g := new(generator)
+	q := new(process).init()
+	q.pushFrame(0)
+	q.s = make([]*Object, len(p.s) - p.b)
+	copy(q.s, p.s[p.b:])
+	q.calls = p.calls
+	q.gen = g
+	g.p = q
+	g.f = p.frame
+	g.f.b = 0
+	p.allocatedOne()
+	return &Object{c: GeneratorClass, data: g}
+}
+
+// Run the generator up to its next item, under the control of the process
+// asking for it. Returns Done once the call has finished.
+func (g *generator) next(from *process) *Object {
+	if g.running {
+		panic(fmt.Errorf("generator is already running"))
+	}
+	p := g.p
+	if p == nil {
+		return Done
+	}
+	p.control(from.nested())
+	g.running = true
+	defer func() {
+		g.running = false
+		if g.f.c == nil {
+			g.p = nil
+		}
+	}()
+	p.frame = g.f
+	g.f = frame{}
+	p.v = Nil
+	p.run()
+	if g.f.c == nil {
+		return Done
+	}
+	return p.v
+}
+
 func (p *process) extend(a *Accessor) {
 	s := p.pop()
 	e := s.skelData()
 	d := p.v.ToClass()
 	if a != nil {
+		classLock.Lock()
 		f := a.lookupa(d)
 		if f == nil {
-			f = d.extend(d.n, Anon, e[1:])
-			a.e = append(a.e, Slot{Class: d, Value: f.o})
+			f = d.extend(d.n, Anon, copySlots(e[1:]))
+			a.addEntry(Slot{Class: d, Value: f.o})
 		}
+		classLock.Unlock()
 		d = f
 	}
 	n := e[0].Name
 	if n == "" {
 		n = d.n
 	}
-	c := d.extend(n, 0, e[1:])
+	// the skeleton may be used by several processes at once
+	c := d.extend(n, 0, copySlots(e[1:]))
 	c.p = p.sc
 	p.sc = c
 }
 
+func copySlots(e []Slot) []Slot {
+	res := make([]Slot, len(e))
+	copy(res, e)
+	return res
+}
+
 func (p *process) finish(n int) {
 	l := len(p.s) - n
 	c := p.sc
@@ -901,8 +1304,14 @@ func (p *process) finish(n int) {
 			e[i].Value = spec[j]
 			j++
 		}
+		if e[i].Flags & abstractSlot != 0 {
+			e[i].Value = AbstractMethod(e[i].Name).Value
+		}
+	}
+	// traits only take their place in a class when they are mixed in
+	if c.flags & Trait == 0 {
+		p.u.addClass(c)
 	}
-	p.u.addClass(c)
 	p.v = c.o
 	p.sc = c.p
 }
@@ -930,21 +1339,311 @@ func (p *process) prolog(n, m int, rest bool) {
 	p.b = len(p.s)-m
 }
 
+// The kinds of pattern a value may be taken apart with.
+const (
+	shapeArray = iota // n items
+	shapeArrayRest // at least n items
+	shapePair
+	shapeHash
+)
+
+// Check that a value can be taken apart with a pattern.
+func checkShape(x *Object, k, n int) {
+	if err := shapeError(x, k, n); err != nil {
+		panic(err)
+	}
+}
+
+func shapeError(x *Object, k, n int) error {
+	switch k {
+	case shapeArray, shapeArrayRest:
+		if !x.Is(SequenceClass) {
+			return fmt.Errorf("cannot destructure %s: not a sequence", x.c.n)
+		}
+		l := int(CollectionClass.Get(x, 3).ToInt())
+		if l < n || k == shapeArray && l != n {
+			want := fmt.Sprint(n)
+			if k == shapeArrayRest {
+				want = "at least " + want
+			}
+			return fmt.Errorf("cannot destructure %s: %d items, expected %s", x.c.n, l, want)
+		}
+	case shapePair:
+		if x.c != PairClass {
+			return fmt.Errorf("cannot destructure %s: not a pair", x.c.n)
+		}
+	case shapeHash:
+		// sequences are indexed by position rather than by key
+		if !x.Is(CollectionClass) || x.Is(SequenceClass) || x.findSlot("contains") == nil {
+			return fmt.Errorf("cannot destructure %s: not a keyed collection", x.c.n)
+		}
+	}
+	return nil
+}
+
+// The values of the first n public fields defined by c, an ancestor of x's
+// class, in the order they were defined.
+func fieldValues(x *Object, c *Class, n int) *Object {
+	var cs []*Class
+	for ; c != nil; c = c.a {
+		if !c.FlagSet(Anon) {
+			cs = append(cs, c)
+		}
+	}
+	var res []*Object
+	seen := map[uint32] bool{}
+	for i := len(cs)-1; i >= 0 && len(res) < n; i-- {
+		for _, e := range cs[i].e {
+			if e.Flags.Kind() != Field || e.Flags.Vis() != Public || seen[e.offset] {
+				continue
+			}
+			seen[e.offset] = true
+			res = append(res, x.f[e.offset])
+			if len(res) == n {
+				break
+			}
+		}
+	}
+	if len(res) < n {
+		panic(fmt.Errorf("cannot match %s: %d fields, pattern has %d", cs[0].n, len(res), n))
+	}
+	return Wrap(res)
+}
+
+// Bind the arguments to a call, by position and by keyword. Of the m named
+// arguments the first n are required, and their names follow in the code. If
+// f has bit 0 set then extra arguments go in an array after the named ones, and
+// if it has bit 1 set then extra keywords go in a hash after that. Named
+// arguments that are not given are left nil, for DEFAULT to fill in.
+func (p *process) prologKw(n, m, f int) {
+	names := p.c[p.p:p.p+m]
+	p.p += m
+	kw := p.kw
+	p.kw = nil
+	if p.n < n && kw == nil || p.n > m && f&1 == 0 {
+		 panic(fmt.Errorf("wrong number of arguments %d", p.n))
+	}
+	b := len(p.s) - p.n
+	var rest []*Object
+	if p.n > m {
+		rest = make([]*Object, p.n-m)
+		copy(rest, p.s[b+m:])
+		p.s = p.s[:b+m]
+	}
+	for i := p.n; i < m; i++ {
+		p.push(nil)
+	}
+	args := p.s[b:]
+	if kw != nil {
+		for i, x := range names {
+			nm := p.u.a[x].n
+			if v, ok := kw[nm]; ok {
+				if args[i] != nil {
+					panic(fmt.Errorf("argument given twice: %s", nm))
+				}
+				args[i] = v
+				delete(kw, nm)
+			}
+		}
+		if len(kw) != 0 && f&2 == 0 {
+			panic(KeywordError(keywordNames(kw)[0]))
+		}
+		for i := range args[:n] {
+			if args[i] == nil {
+				panic(fmt.Errorf("missing argument: %s", p.u.a[names[i]].n))
+			}
+		}
+	}
+	if f&1 != 0 {
+		if rest == nil {
+			rest = []*Object{}
+		}
+		p.push(Wrap(rest))
+	}
+	if f&2 != 0 {
+		extra := map[*Object] *Object{}
+		for k, v := range kw {
+			extra[Wrap(k)] = v
+		}
+		p.push(Wrap(extra))
+	}
+	p.b = b
+}
+
+// Called by functions that do not take keyword arguments.
+func (p *process) noKeywords() {
+	if len(p.kw) != 0 {
+		panic(KeywordError(keywordNames(p.keywords())[0]))
+	}
+}
+
+func keywordNames(kw map[string] *Object) []string {
+	res := make([]string, 0, len(kw))
+	for k := range kw {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Take the keyword arguments to the call being made.
+func (p *process) keywords() map[string] *Object {
+	kw := p.kw
+	p.kw = nil
+	if kw == nil {
+		kw = map[string] *Object{}
+	}
+	return kw
+}
+
 func (p *process) wrapError(err interface{}) *Object {
-	if p.line == 0 {
+	o, ok := err.(*Object)
+	pos := p.pos()
+	if ge, isErr := err.(error); isErr && !ok {
+		// hold on to Go errors so that they may be inspected later
+		o = ErrorClass.New(Wrap(ge))
+		o.data = ge
+	} else if pos.line == 0 {
 		return Wrap(err)
+	} else if !ok || !o.Is(ErrorClass) {
+		o = ErrorClass.New(Wrap(err))
 	}
-	if o, ok := err.(*Object); ok && o.Is(ErrorClass) {
-		if ErrorClass.Get(o, 2).ToInt() == 0 {
-			ErrorClass.Set(o, 1, p.file)
-			ErrorClass.Set(o, 2, Wrap(p.line))
-		}
+	if pos.line != 0 && ErrorClass.Get(o, 2).ToInt() == 0 {
+		ErrorClass.Set(o, 1, Wrap(pos.file))
+		ErrorClass.Set(o, 2, Wrap(pos.line))
+		ErrorClass.Set(o, 4, Wrap(pos.col))
+	}
+	return o
+}
+
+// The most frames kept in the stack trace of an error. Calls further out are
+// counted in a last entry, "... n more".
+const maxTrace = 100
+
+// Add the process' frames to the stack trace of an error, innermost first. An
+// error that passes through several processes (from Go code calling back into
+// the interpreter) collects the frames of each.
+func (p *process) addTrace(o *Object) *Object {
+	if !o.Is(ErrorClass) {
 		return o
 	}
-	e := ErrorClass.New(Wrap(err))
-	ErrorClass.Set(e, 1, p.file)
-	ErrorClass.Set(e, 2, Wrap(p.line))
-	return e
+	var trace []*Object
+	if t := ErrorClass.Get(o, 3); t.c == ArrayClass {
+		trace = append(trace, t.ToArray()...)
+	}
+	more := 0
+	if l := len(trace); l != 0 {
+		if n, ok := trace[l-1].data.(int); ok && trace[l-1].c == frameClass {
+			more = n
+			trace = trace[:l-1]
+		}
+	}
+	add := func(f *frame) {
+		if len(trace) < maxTrace {
+			trace = append(trace, f.record())
+		} else {
+			more++
+		}
+	}
+	inner := &p.frame
+	if inner.u != nil {
+		add(inner)
+	}
+	for i := len(p.frames)-1; i >= 0; i-- {
+		f := &p.frames[i]
+		// a call may have been set up, but not yet made
+		if f.u == nil || f.sameActivation(inner) {
+			continue
+		}
+		add(f)
+		inner = f
+	}
+	if more != 0 {
+		trace = append(trace, moreFrames(more))
+	}
+	ErrorClass.Set(o, 3, Wrap(trace))
+	return o
+}
+
+// The entry that ends a stack trace that has been cut short. It holds the
+// number of frames left out.
+func moreFrames(n int) *Object {
+	o := frameClass.alloc()
+	o.data = n
+	frameClass.Set(o, 0, Wrap(fmt.Sprintf("... %d more", n)))
+	return o
+}
+
+func (f *frame) sameActivation(g *frame) bool {
+	return f.u == g.u && f.id == g.id
+}
+
+// Describe the frame for a stack trace.
+func (f *frame) record() *Object {
+	o := frameClass.alloc()
+	pos := f.pos()
+	cls := Nil
+	if f.sc != nil {
+		cls = f.sc.o
+	}
+	frameClass.Set(o, 0, Wrap(f.funcName()))
+	frameClass.Set(o, 1, cls)
+	frameClass.Set(o, 2, Wrap(pos.file))
+	frameClass.Set(o, 3, Wrap(pos.line))
+	frameClass.Set(o, 4, Wrap(pos.col))
+	return o
+}
+
+func (f *frame) funcName() string {
+	switch {
+	case f.k == 0:
+		return "<top level>"
+	case f.k < len(f.u.fn) && f.u.fn[f.k] != "":
+		return f.u.fn[f.k]
+	}
+	return "<anonymous>"
+}
+
+// Where in the source the frame has got to. The last instruction to be run
+// (or the call in progress) is the one before p.
+func (f *frame) pos() srcPos {
+	if f.u == nil {
+		return srcPos{}
+	}
+	return f.u.position(f.k, f.p-1)
+}
+
+// Where in the source the process has got to. Code that a function runs before
+// its body, such as checking its arguments, has no position of its own, so
+// errors that it raises are placed at the call.
+func (p *process) pos() srcPos {
+	pos := p.frame.pos()
+	for i := len(p.frames)-1; pos.line == 0 && i >= 0; i-- {
+		pos = p.frames[i].pos()
+	}
+	return pos
+}
+
+func (u *Unit) position(block, offset int) srcPos {
+	if block >= len(u.src) {
+		return srcPos{}
+	}
+	s := u.src[block]
+	i := sort.Search(len(s), func(i int) bool {
+		return s[i].offset > offset
+	})
+	if i == 0 {
+		return srcPos{}
+	}
+	return s[i-1]
+}
+
+// Find the source position of the code at an offset into one of the unit's
+// blocks, as used by a debugger or a disassembler. Block 0 holds the top level
+// code. The line is 0 if the position is not known.
+func (u *Unit) Position(block, offset int) (file string, line, col int) {
+	pos := u.position(block, offset)
+	return pos.file, pos.line, pos.col
 }
 
 func (p *process) step() {
@@ -995,11 +1694,7 @@ func (p *process) step() {
 		p.s[l] = b 
 		
 	case UNBOX:
-		if p.v.c == undefinedClass {
-			s := p.v.boxData().ToString()
-			panic(fmt.Errorf("undefined variable: %s", s))
-		}
-		p.v = p.v.boxData()
+		p.unbox()
 		
 	case UPDATE:
 		if p.v.c == undefinedClass {
@@ -1007,11 +1702,13 @@ func (p *process) step() {
 			panic(fmt.Errorf("undefined variable: %s", s))
 		}
 		p.v.setBoxData(p.pop())
-		p.v.c = boxClass
 		p.v = Nil
 		
 	case DEFINE:
+		// Go code may be looking at the globals
+		p.u.i.mu.Lock()
 		p.v.c = boxClass
+		p.u.i.mu.Unlock()
 		
 	case PUSH:
 		p.push(p.v)
@@ -1033,6 +1730,19 @@ func (p *process) step() {
 		
 	case CALL:
 		p.n = p.next()
+		p.kw = nil
+		p.v.funcData()(p)
+	
+	case CALLK:
+		n, k := p.next(), p.next()
+		l := len(p.s) - k
+		kw := make(map[string] *Object, k)
+		for _, x := range p.s[l:] {
+			kw[p.u.a[p.next()].n] = x
+		}
+		p.s = p.s[:l]
+		p.n = n
+		p.kw = kw
 		p.v.funcData()(p)
 		
 	case CLOSE:
@@ -1050,6 +1760,7 @@ func (p *process) step() {
 		if p.n != n {
 			 panic(fmt.Errorf("wrong number of arguments %d", p.n))
 		}
+		p.noKeywords()
 		p.b = len(p.s) - p.n
 		
 	case PROLOG_OPT:
@@ -1059,6 +1770,32 @@ func (p *process) step() {
 	case PROLOG_REST:
 		n, m := p.next(), p.next()
 		p.prolog(n, m, true)
+	
+	case PROLOG_KW:
+		n, m, f := p.next(), p.next(), p.next()
+		p.prologKw(n, m, f)
+	
+	case DEFAULT:
+		n, m := p.next(), p.next()
+		if p.s[p.b + m] != nil {
+			p.p = n
+		}
+	
+	case BIND:
+		n := p.next()
+		p.s[p.b + n] = p.v
+	
+	case SHAPE:
+		n, m := p.next(), p.next()
+		checkShape(p.v, n, m)
+	
+	case MATCH:
+		n, m := p.next(), p.next()
+		p.v = Wrap(shapeError(p.v, n, m) == nil)
+	
+	case FIELDS:
+		n := p.next()
+		p.v = fieldValues(p.v, p.pop().ToClass(), n)
 		
 	case EXTEND:
 		p.extend(nil)
@@ -1070,21 +1807,29 @@ func (p *process) step() {
 	case FINISH:
 		n := p.next()
 		p.finish(n)
+	
+	case MIXIN:
+		n := p.next()
+		p.v = p.mixin(n)
+	
+	case TRAIT:
+		n := p.next()
+		p.trait(n)
 		
 	case GET:
 		n, m := p.next(), p.next()
 		a := p.u.a[n]
-		p.v = p.v.get(a, p.lookups(m))
+		p.v = p.v.get(p.nested(), a, p.cached(a, m))
 		
 	case GETM:
 		n, m := p.next(), p.next()
 		a := p.u.a[n]
-		p.v = p.v.getMethod(a, p.lookups(m))
+		p.v = p.v.getMethod(p.nested(), a, p.cached(a, m))
 	
 	case SET:
 		n, m := p.next(), p.next()
 		a := p.u.a[n]
-		p.v.set(a, p.lookups(m), p.pop())
+		p.v.set(p.nested(), a, p.cached(a, m), p.pop())
 		p.v = Nil
 		
 	case THIS:
@@ -1095,7 +1840,7 @@ func (p *process) step() {
 		
 	case SUPER:
 		n := p.next()
-		if n == slotUnknown {
+		if uint32(n) == slotUnknown {
 			panic(fmt.Errorf("only use super with methods you have overridden"))
 		}
 		c := p.sc
@@ -1104,18 +1849,51 @@ func (p *process) step() {
 		if a.FlagSet(Anon) {
 			a = a.a
 		}
-		if e.offset >= uint16(len(a.m)) {
+		if e.offset >= uint32(len(a.m)) {
 			panic(fmt.Errorf("not present on ancestor: %s.%s", c.n, e.Name))
 		}
 		p.v = a.m[e.offset]
 	
-	case SOURCE:
-		n, m := p.next(), p.next()
-		if n != 0 {
-			p.file = p.u.v[n]
-		}
-		p.line = m
-//		fmt.Println(p.file, p.line)
+	case TRY, FINALLY:
+		n := p.next()
+		h := handler{p.frame, len(p.frames), len(p.s), op == FINALLY}
+		h.f.p = n
+		p.handlers = append(p.handlers, h)
+		
+	case UNTRY:
+		p.handlers = p.handlers[:len(p.handlers)-1]
+		
+	case THROW:
+		panic(p.v)
+		
+	case GENERATE:
+		p.ret(p.generate())
+		
+	case YIELD:
+		// stop running until the generator is resumed
+		p.gen.f = p.frame
+		p.frame = frame{}
+	
+	// pairs of instructions combined by the optimiser
+	case PUSHV:
+		n := p.next()
+		p.v = p.u.v[n]
+		p.push(p.v)
+	
+	case PUSHB:
+		n := p.next()
+		p.v = p.s[int(p.b + n)]
+		p.push(p.v)
+	
+	case GLOBALU:
+		n := p.next()
+		p.v = p.u.g[n]
+		p.unbox()
+	
+	case BOUNDU:
+		n := p.next()
+		p.v = p.s[int(p.b + n)]
+		p.unbox()
 	
 	default:
 		panic(fmt.Errorf("unrecognised opcode: %d", op))
@@ -1134,22 +1912,32 @@ func (u *Unit) Copy() *Unit {
 	res := *u
 	res.g = make([]*Object, len(u.g))
 	res.a = make([]*Accessor, len(u.a))
+	res.ic = nil
+	res.vi, res.gi, res.ai = tableIndex{}, tableIndex{}, tableIndex{}
 	return &res
 }
 
 func (u *Unit) link(i *Interpreter) {
+	u.i = i
 	for j, x := range u.gn {
 		u.g[j] = i.lookup(x)
 	}
 	for j, x := range u.an {
 		u.a[j] = i.Accessor(x)
 	}
+	u.linkCaches()
 }
 
+// Version 0 is the original format. Version 1 makes all the counts, positions
+// and code in the file 32 bits wide rather than 16, apart from the first three
+// fields of the header. It stores integer constants in 64 bits rather than 32,
+// adds big integer constants, and adds function names and tables of source
+// positions, which version 0 kept in the code. Version 0 files may still be
+// loaded.
 const (
 	magic1 = 0x4200
 	magic2 = 0x4353
-	version = 0
+	version = 1
 )
 
 const (
@@ -1163,6 +1951,7 @@ const (
 	hStrings
 	hInts
 	hFloats
+	hBigs // from version 1
 	hSkeletons
 	hSkeletonSize
 	hSize
@@ -1182,9 +1971,18 @@ func write(w io.Writer, x interface{}) {
 	}	
 }
 
-func readBlock(r io.Reader, c int) []uint16 {
-	buf := make([]uint16, c)
-	read(r, buf)
+// Read c words, which are 16 bits wide in version 0.
+func readBlock(r io.Reader, c int, v uint32) []uint32 {
+	buf := make([]uint32, c)
+	if v >= 1 {
+		read(r, buf)
+		return buf
+	}
+	narrow := make([]uint16, c)
+	read(r, narrow)
+	for i, x := range narrow {
+		buf[i] = uint32(x)
+	}
 	return buf
 }
 
@@ -1209,26 +2007,83 @@ func writeString(w io.Writer, s string) {
 	write(w, byte(0))
 }
 
-func readHeader(r io.Reader) (header []uint16, ok bool) {
-	defer func() {
-		recover()
-		ok = false
-	}()
-	header = readBlock(r, hSize)
-	ok = header[hMagic1] == magic1 && 
-	     header[hMagic2] == magic2 &&
-	     header[hVersion] == version
-	return
+// Check that a count will fit in the file.
+func count(n int, what string) uint32 {
+	if int64(n) > math.MaxUint32 {
+		panic(fmt.Errorf("too many %s to save: %d", what, n))
+	}
+	return uint32(n)
+}
+
+// Read the header, laid out as for the current version. Returns false if the
+// file is not a compiled unit. Panics if it is one that cannot be loaded.
+func readHeader(r io.Reader) (header []uint32, ok bool) {
+	start := make([]uint16, hVersion+1)
+	if binary.Read(r, binary.LittleEndian, start) != nil ||
+	   start[hMagic1] != magic1 || start[hMagic2] != magic2 {
+		return nil, false
+	}
+	v := uint32(start[hVersion])
+	if v > version {
+		panic(fmt.Errorf("unsupported unit version: %d", v))
+	}
+	header = make([]uint32, hSize)
+	for i, x := range start {
+		header[i] = uint32(x)
+	}
+	if v >= 1 {
+		copy(header[hVersion+1:], readBlock(r, hSize-hVersion-1, v))
+	} else {
+		copy(header[hVersion+1:], readBlock(r, hBigs-hVersion-1, v))
+		copy(header[hBigs+1:], readBlock(r, hSize-hBigs-1, v))
+	}
+	return header, true
+}
+
+// In version 0, a slot that was not known statically was marked 0xffff.
+func widenSlot(x uint32) uint32 {
+	if x == 0xffff {
+		return slotUnknown
+	}
+	return x
+}
+
+// Bring a block of version 0 code up to date. Returns the source positions
+// found in it.
+func (u *Unit) upgrade(b []uint32) []srcPos {
+	var src []srcPos
+	file := ""
+	for p := 0; p < len(b); {
+		if b[p] == SOURCE {
+			if b[p+1] != 0 {
+				file = u.v[b[p+1]].ToString()
+			}
+			src = append(src, srcPos{p, file, int(b[p+2]), 0})
+			b[p], b[p+1], b[p+2] = NOP, NOP, NOP
+			p += 3
+			continue
+		}
+		switch b[p] {
+		case GET, GETM, SET:
+			b[p+2] = widenSlot(b[p+2])
+		case SUPER:
+			b[p+1] = widenSlot(b[p+1])
+		}
+		p += Width(b[p:])
+	}
+	return src
 }
 
 // Load a compiled file. Panics on error. Returns whether or not the unit is in
-// a valid format.
+// a valid format. Files saved in earlier versions of the format may be loaded,
+// but not ones saved in later versions.
 func (u *Unit) Load(r io.Reader) bool {
 	// header
 	header, ok := readHeader(r)
 	if !ok {
 		return false
 	}
+	v := header[hVersion]
 	globals := int(header[hGlobals])
 	Accessors := int(header[hAccessors])
 	blocks := int(header[hBlocks])
@@ -1236,9 +2091,10 @@ func (u *Unit) Load(r io.Reader) bool {
 	strings := int(header[hStrings])
 	ints := int(header[hInts])
 	floats := int(header[hFloats])
+	bigs := int(header[hBigs])
 	skeletons := int(header[hSkeletons])
 	skeletonSize := int(header[hSkeletonSize])
-	values := strings + ints  + floats + skeletons
+	values := strings + ints  + floats + bigs + skeletons
 	
 	// globals, Accessors
 	u.g = make([]*Object, globals)
@@ -1253,9 +2109,9 @@ func (u *Unit) Load(r io.Reader) bool {
 	}
 
 	// blocks
-	u.b = make([][]uint16, blocks)
-	cbuf := readBlock(r, code)
-	clens := readBlock(r, blocks)
+	u.b = make([][]uint32, blocks)
+	cbuf := readBlock(r, code, v)
+	clens := readBlock(r, blocks, v)
 	p := 0
 	for i, x := range clens {
 		n := p + int(x)
@@ -1268,16 +2124,22 @@ func (u *Unit) Load(r io.Reader) bool {
 	u.v[0] = Nil
 	u.v[1] = True
 	u.v[2] = False
-	vlocs := readBlock(r, values)
+	vlocs := readBlock(r, values, v)
 	p = 0
 	for i := 0; i < strings; i++ {
 		u.v[int(vlocs[i])] = Wrap(readString(r, 0))
 	}
 	p += strings
 	for i := 0; i < ints; i++ {
-		var ival int32
-		read(r, &ival)
-		u.v[int(vlocs[i+p])] = Wrap(int(ival))
+		var ival int64
+		if v < 1 {
+			var narrow int32
+			read(r, &narrow)
+			ival = int64(narrow)
+		} else {
+			read(r, &ival)
+		}
+		u.v[int(vlocs[i+p])] = Wrap(ival)
 	}
 	p += ints
 	for i := 0; i < floats; i++ {
@@ -1286,10 +2148,18 @@ func (u *Unit) Load(r io.Reader) bool {
 		u.v[int(vlocs[i+p])] = Wrap(fval)
 	}
 	p += floats
+	for i := 0; i < bigs; i++ {
+		x, ok := new(big.Int).SetString(readString(r, 0), 10)
+		if !ok {
+			panic(fmt.Errorf("bad integer constant"))
+		}
+		u.v[int(vlocs[i+p])] = wrapBig(x)
+	}
+	p += bigs
 	
 	// skeletons
-	sbuf := readBlock(r, skeletonSize)
-	slens := readBlock(r, skeletons)
+	sbuf := readBlock(r, skeletonSize, v)
+	slens := readBlock(r, skeletons, v)
 	for i := 0; i < skeletons; i++ {
 		l := int(slens[i])
 		es := make([]Slot, l+1)
@@ -1298,64 +2168,108 @@ func (u *Unit) Load(r io.Reader) bool {
 			es[j+1].Flags = SlotFlags(sbuf[3*j])
 			es[j+1].access = sbuf[3*j+1]
 			es[j+1].next = sbuf[3*j+2]
+			if v < 1 {
+				es[j+1].next = widenSlot(es[j+1].next)
+			}
 		}
 		u.v[int(vlocs[i+p])] = new(skelObj).init(es)
 		sbuf = sbuf[3*l:]
 	}
+	
+	// version 0 code refers to the values
+	u.fn = make([]string, blocks)
+	u.src = make([][]srcPos, blocks)
+	if v == 0 {
+		for i := range u.b {
+			u.src[i] = u.upgrade(u.b[i])
+		}
+		return true
+	}
+	
+	// function names and source positions
+	for i := range u.fn {
+		u.fn[i] = readString(r, 0)
+	}
+	files := make([]string, readBlock(r, 1, v)[0])
+	for i := range files {
+		files[i] = readString(r, 0)
+	}
+	plens := readBlock(r, blocks, v)
+	for i, l := range plens {
+		pbuf := make([]uint32, 4*int(l))
+		read(r, pbuf)
+		u.src[i] = make([]srcPos, l)
+		for j := range u.src[i] {
+			x := pbuf[4*j:]
+			u.src[i][j] = srcPos{int(x[0]), files[x[1]], int(x[2]), int(x[3])}
+		}
+	}
 	return true
 }
 
 // Save a compiled file. Panics on error.
 func (u *Unit) Save(w io.Writer) {
 	// header 
-	header := make([]uint16, hSize)
+	header := make([]uint32, hSize)
 	header[hMagic1] = magic1
 	header[hMagic2] = magic2
 	header[hVersion] = version
-	header[hGlobals] =  uint16(len(u.g))
-	header[hAccessors] = uint16(len(u.a))
+	header[hGlobals] = count(len(u.g), "globals")
+	header[hAccessors] = count(len(u.a), "accessors")
 	
 	// blocks
-	var cbuf []uint16
-	clens := make([]uint16, len(u.b))
+	var cbuf []uint32
+	clens := make([]uint32, len(u.b))
 	for i, x := range u.b {
-		clens[i] = uint16(len(x))
+		clens[i] = count(len(x), "instructions")
 		cbuf = append(cbuf, x...)
 	}
-	header[hBlocks] = uint16(len(u.b))
-	header[hCode] = uint16(len(cbuf))
+	header[hBlocks] = count(len(u.b), "blocks")
+	header[hCode] = count(len(cbuf), "instructions")
 	
 	// values
-	var stringPs, intPs, floatPs, skeletonPs []uint16
-	var strings, ints, floats, skeletons []interface{}
+	count(len(u.v), "values")
+	var stringPs, intPs, floatPs, bigPs, skeletonPs []uint32
+	var strings, ints, floats, bigs, skeletons []interface{}
 	skeletonSize := 0
 	for i, x := range u.v[3:] {
 		switch x.c {
 		case StringClass:
-			stringPs = append(stringPs, uint16(i+3))
+			stringPs = append(stringPs, uint32(i+3))
 			strings = append(strings, x.ToString())
 		case IntClass:
-			intPs = append(intPs, uint16(i+3))
+			intPs = append(intPs, uint32(i+3))
 			ints = append(ints, x.ToInt())
 		case FltClass:
-			floatPs = append(floatPs, uint16(i+3))
+			floatPs = append(floatPs, uint32(i+3))
 			floats = append(floats, x.ToFloat())
+		case BigIntClass:
+			bigPs = append(bigPs, uint32(i+3))
+			bigs = append(bigs, toBig(x).String())
 		case skeletonClass:
 			sk := x.skelData()
-			skeletonPs = append(skeletonPs, uint16(i+3))
+			skeletonPs = append(skeletonPs, uint32(i+3))
 			skeletons = append(skeletons, sk)
 			skeletonSize += (len(sk)-1)*3
+		default:
+			panic(fmt.Errorf("cannot save value: %s", x.c.n))
 		}
 	}
 	skeletonCount := len(skeletons)
-	header[hStrings] = uint16(len(strings))
-	header[hInts] = uint16(len(ints))
-	header[hFloats] = uint16(len(floats))
-	header[hSkeletons] = uint16(skeletonCount)
-	header[hSkeletonSize] = uint16(skeletonSize)
-
-	// write the header
-	write(w, header)
+	header[hStrings] = uint32(len(strings))
+	header[hInts] = uint32(len(ints))
+	header[hFloats] = uint32(len(floats))
+	header[hBigs] = uint32(len(bigs))
+	header[hSkeletons] = uint32(skeletonCount)
+	header[hSkeletonSize] = count(skeletonSize, "slots")
+
+	// write the header, which starts with the fields every version shares
+	start := make([]uint16, hVersion+1)
+	for i := range start {
+		start[i] = uint16(header[i])
+	}
+	write(w, start)
+	write(w, header[hVersion+1:])
 	
 	// globals and Accessors
 	for _, x := range u.gn {
@@ -1373,30 +2287,34 @@ func (u *Unit) Save(w io.Writer) {
 	write(w, stringPs)
 	write(w, intPs)
 	write(w, floatPs)
+	write(w, bigPs)
 	write(w, skeletonPs)
 	for _, x := range strings {
 		writeString(w, x.(string))
 	}
 	for _, x := range ints {
-		write(w, int32(x.(int64)))
+		write(w, x.(int64))
 	}
 	for _, x := range floats {
 		write(w, x.(float64))
 	}
+	for _, x := range bigs {
+		writeString(w, x.(string))
+	}
 	
 	// skeletons
 	ns := make([]string, skeletonCount)
-	sbuf := make([]uint16, 0, skeletonSize)
-	slens := make([]uint16, skeletonCount)
+	sbuf := make([]uint32, 0, skeletonSize)
+	slens := make([]uint32, skeletonCount)
 	for i, x := range skeletons {
 		es := x.([]Slot)
 		ns[i] = es[0].Name
 		l := len(es) - 1
-		slens[i] = uint16(l)
-		sk := make([]uint16, 3*l)
+		slens[i] = uint32(l)
+		sk := make([]uint32, 3*l)
 		for j := 0; j < l; j++ {
 			y := es[j+1]
-			sk[3*j] = uint16(y.Flags)
+			sk[3*j] = uint32(y.Flags)
 			sk[3*j+1] = y.access
 			sk[3*j+2] = y.next
 		}
@@ -1407,8 +2325,36 @@ func (u *Unit) Save(w io.Writer) {
 	for _, x := range ns {
 		writeString(w, x)
 	}
+	
+	// function names and source positions
+	for i := range u.b {
+		n := ""
+		if i < len(u.fn) {
+			n = u.fn[i]
+		}
+		writeString(w, n)
+	}
+	var files []string
+	var pbuf []uint32
+	plens := make([]uint32, len(u.b))
+	for i := range u.b {
+		if i >= len(u.src) {
+			continue
+		}
+		plens[i] = count(len(u.src[i]), "source positions")
+		for _, x := range u.src[i] {
+			f := lookup(x.file, files)
+			if f == -1 {
+				f = len(files)
+				files = append(files, x.file)
+			}
+			pbuf = append(pbuf, uint32(x.offset), uint32(f), uint32(x.line), uint32(x.col))
+		}
+	}
+	write(w, uint32(len(files)))
+	for _, x := range files {
+		writeString(w, x)
+	}
+	write(w, plens)
+	write(w, pbuf)
 }
-
-
-
-
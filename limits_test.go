@@ -0,0 +1,133 @@
+package ts_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+// Objects allocated by one call do not count against another's limit.
+func TestAllocsPerCall(t *testing.T) {
+	i := ts.New()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		x, err := run(t, i, 20*time.Second, `
+			def unlimited()
+				for n in range(100000) do
+					[n];
+				end;
+				return true;
+			end;
+			unlimited();
+		`, ts.Limits{})
+		expect(t, "unlimited", x, err, "true")
+	}()
+	go func() {
+		defer wg.Done()
+		x, err := run(t, i, 20*time.Second, `
+			def limited()
+				for n in range(500) do
+					[n];
+				end;
+				return true;
+			end;
+			limited();
+		`, ts.Limits{Allocs: 5000})
+		expect(t, "limited", x, err, "true")
+	}()
+	wg.Wait()
+	_, err := run(t, i, 20*time.Second, `
+		while true do
+			[1];
+		end;
+	`, ts.Limits{Allocs: 5000})
+	var le *ts.LimitError
+	if !errors.As(err, &le) || le.Limit != "allocations" {
+		t.Errorf("over the limit: %v", err)
+	}
+}
+
+// Budgets that run out stay spent, and scripts cannot catch the error.
+func TestLimitsNotCaught(t *testing.T) {
+	i := ts.New()
+	_, err := run(t, i, 20*time.Second, `
+		def f() = f();
+		def g()
+			catch(f);
+			return g();
+		end;
+		g();
+	`, ts.Limits{Instructions: 10000})
+	var le *ts.LimitError
+	if !errors.As(err, &le) || le.Limit != "instructions" {
+		t.Errorf("instructions: %v", err)
+	}
+	i.SetLimits(ts.Limits{Instructions: 10000})
+	_, err = i.EvalErr("def h() = h(); h();")
+	if !errors.As(err, &le) || le.Limit != "instructions" {
+		t.Errorf("interpreter limits: %v", err)
+	}
+}
+
+// Processes that Go code starts on behalf of a call count towards its stack and
+// frame limits, along with the processes they are nested in.
+func TestNestedLimits(t *testing.T) {
+	tests := []struct {
+		name, src string
+	}{
+		{"apply", "def f(n) = f.apply([n + 1]); f(0);"},
+		{"call", "def f(n) = f.__call__(n + 1); f(0);"},
+		{"property", `
+			class P()
+				def x get() return this.x; end;
+			end;
+			P().x;
+		`},
+		{"sort", `
+			def f(x) = sort([x, x]);
+			class Q()
+				def __lt__(o) = f(this);
+			end;
+			f(Q());
+		`},
+		{"new", `
+			class R()
+				def create() R(); end;
+			end;
+			R();
+		`},
+	}
+	limits := []ts.Limits{
+		{Frames: 1000},
+		{Stack: 10000},
+	}
+	i := ts.New()
+	for _, test := range tests {
+		for _, l := range limits {
+			_, err := run(t, i, 20*time.Second, test.src, l)
+			var le *ts.LimitError
+			if !errors.As(err, &le) {
+				t.Errorf("%s within %+v: %v", test.name, l, err)
+			}
+		}
+	}
+}
+
+// Code that stays within the limits is not stopped by them, however many calls
+// through Go it makes one after another.
+func TestNestedLimitsReleased(t *testing.T) {
+	i := ts.New()
+	x, err := run(t, i, 20*time.Second, `
+		def total = 0;
+		def add(n) total = total + n; end;
+		for n in range(5000) do
+			add.apply([n]);
+		end;
+		total;
+	`, ts.Limits{Frames: 50, Stack: 500})
+	expect(t, "released", x, err, "12497500")
+}
@@ -0,0 +1,140 @@
+package ts_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+func TestLoops(t *testing.T) {
+	expectAll(t, []scriptTest{
+		{"while", "def n = 0; while n < 3 do n = n + 1; end; n;", "3"},
+		{"while false", "def n = 0; while false do n = 1; end; n;", "0"},
+		{"while value", "def n = 0; while n < 3 do n = n + 1; end;", "nil"},
+		{"for", "def r = []; for x in [1, 2, 3] do r.push(x * 2); end; r;", "[2, 4, 6]"},
+		{"for range", "def t = 0; for x in range(5) do t = t + x; end; t;", "10"},
+		{"for hash", `def r = []; for k in {"a": 1} do r.push(k); end; r;`, "[a]"},
+		{"for pattern", "def r = []; for [a, b] in [[1, 2], [3, 4]] do r.push(a + b); end; r;", "[3, 7]"},
+		{"for iterator", `
+			def r = [], it = [1, 2, 3].__iter__();
+			for x in it do
+				r.push(x);
+				it.next();
+			end;
+			r;
+		`, "[1, 3]"},
+		{"break", "def n = 0; while true do n = n + 1; if n > 3 then break; end; end; n;", "4"},
+		{"continue", `
+			def r = [];
+			for x in range(6) do
+				if x % 2 == 0 then
+					continue;
+				end;
+				r.push(x);
+			end;
+			r;
+		`, "[1, 3, 5]"},
+		{"continue while", `
+			def n = 0, r = [];
+			while n < 5 do
+				n = n + 1;
+				if n == 2 then
+					continue;
+				end;
+				r.push(n);
+			end;
+			r;
+		`, "[1, 3, 4, 5]"},
+		{"nested break", `
+			def r = [];
+			for x in range(3) do
+				for y in range(3) do
+					if y > x then
+						break;
+					end;
+					r.push(x * 10 + y);
+				end;
+			end;
+			r;
+		`, "[0, 10, 11, 20, 21, 22]"},
+		{"return", "def f() for x in [1, 2] do return x; end; end; f();", "1"},
+		{"closures", `
+			def fs = [];
+			for x in range(3) do
+				fs.push(fn() = x);
+			end;
+			map(fs, fn(f) = f());
+		`, "[0, 1, 2]"},
+		{"break finally", `
+			def r = [];
+			for x in [1, 2] do
+				try
+					r.push(x);
+					break;
+				finally
+					r.push("f");
+				end;
+			end;
+			r;
+		`, "[1, f]"},
+		{"continue finally", `
+			def r = [];
+			for x in [1, 2] do
+				try
+					continue;
+				finally
+					r.push(x);
+				end;
+			end;
+			r;
+		`, "[1, 2]"},
+		{"catch in loop", `
+			def r = [];
+			for x in [1, 0, 2] do
+				try
+					r.push(2 ~/ x);
+				catch e
+					r.push("caught");
+				end;
+			end;
+			r;
+		`, "[2, caught, 1]"},
+	})
+}
+
+func TestLoopErrors(t *testing.T) {
+	expectErrors(t, []scriptTest{
+		{"break outside", "break;", "unexpected break"},
+		{"continue outside", "continue;", "unexpected continue"},
+		{"break in fn", "while true do fn() break; end; end;", "unexpected break"},
+		{"no do", "for x in 1, 2 do end;", "expected do"},
+		{"not iterable", "for x in 5 do end;", "undefined: Integer.__iter__"},
+		{"loop variable", "for x in [1] do end; x;", "undefined variable: x"},
+		{"bad pattern", `for k : v in {"a": 1} do end;`, "not a pair"},
+		{"arity", "def f(x) = x; for x in [1] do f(); end;", "wrong number of arguments 0"},
+		{"throw", `for x in [1, 2] do throw("boom"); end;`, "boom"},
+		{"caught", `
+			def it = class()
+				def __iter__() = this;
+				def next() = throw("bad next");
+			end();
+			throw(catch(fn() for x in it do end; end).msg + " caught");
+		`, "bad next caught"},
+	})
+}
+
+// A loop that runs out of instructions is not stopped by a catch clause.
+func TestLoopLimit(t *testing.T) {
+	_, err := run(t, ts.New(), 20*time.Second, `
+		try
+			while true do end;
+		catch e
+			throw("caught");
+		end;
+	`, ts.Limits{Instructions: 10000})
+	var l *ts.LimitError
+	if !errors.As(err, &l) {
+		t.Errorf("got %v, want a limit", err)
+	}
+}
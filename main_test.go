@@ -0,0 +1,73 @@
+package ts_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+	_ "github.com/bobappleyard/ts/ext/sync"
+)
+
+func TestMain(m *testing.M) {
+	// the prelude is found from the root of the repository
+	if os.Getenv("TSROOT") == "" {
+		root, _ := filepath.Abs(".")
+		os.Setenv("TSROOT", root)
+	}
+	os.Exit(m.Run())
+}
+
+// Run some code, giving up after a while so that a test that goes wrong does
+// not hang.
+func run(t *testing.T, i *ts.Interpreter, d time.Duration, src string, l ts.Limits) (*ts.Object, error) {
+	u := new(ts.Unit)
+	if err := u.CompileErr(strings.NewReader(src), "test"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return i.ExecLimits(ctx, u, l)
+}
+
+func expect(t *testing.T, name string, x *ts.Object, err error, want string) {
+	if err != nil {
+		t.Errorf("%s: %s", name, err)
+		return
+	}
+	if x.String() != want {
+		t.Errorf("%s: got %s, want %s", name, x, want)
+	}
+}
+
+// A script and what it should give: its value, or part of the error it fails
+// with.
+type scriptTest struct {
+	name, src, want string
+}
+
+// Run each script in an interpreter of its own, checking its value.
+func expectAll(t *testing.T, tests []scriptTest) {
+	for _, test := range tests {
+		x, err := run(t, ts.New(), 20*time.Second, test.src, ts.Limits{})
+		expect(t, test.name, x, err, test.want)
+	}
+}
+
+// Compile and run each script, checking that it fails.
+func expectErrors(t *testing.T, tests []scriptTest) {
+	for _, test := range tests {
+		u := new(ts.Unit)
+		err := u.CompileErr(strings.NewReader(test.src), "test")
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			_, err = ts.New().ExecLimits(ctx, u, ts.Limits{})
+			cancel()
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.want)
+		}
+	}
+}
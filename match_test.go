@@ -0,0 +1,107 @@
+package ts_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+// The first case whose pattern fits the value applies.
+func TestMatchShapes(t *testing.T) {
+	const defs = `
+		def kind(x)
+			match x
+			case [a] then
+				return "array of " + a.toString();
+			case {a} then
+				return "hash with " + a.toString();
+			case {"b": b, c} then
+				return "hash with " + (b + c).toString();
+			case a : b then
+				return "pair";
+			case _ then
+				return "other";
+			end;
+		end;
+	`
+	tests := []struct {
+		name, src, want string
+	}{
+		{"array", "kind([1]);", "array of 1"},
+		{"hash", `kind({"a": 2});`, "hash with 2"},
+		{"hash keys", `kind({"b": 1, "c": 2});`, "hash with 3"},
+		{"long array", "kind([1, 2]);", "other"},
+		{"array not hash", "kind([]);", "other"},
+		{"string not hash", `kind("ab");`, "other"},
+		{"missing key", `kind({"c": 1});`, "other"},
+		{"pair", "kind(1: 2);", "pair"},
+		{"number", "kind(1);", "other"},
+	}
+	for _, test := range tests {
+		x, err := run(t, ts.New(), 20*time.Second, defs + test.src, ts.Limits{})
+		expect(t, test.name, x, err, test.want)
+	}
+}
+
+// Values of the wrong shape cannot be taken apart.
+func TestUnpackShapes(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"array as hash", "def {a} = [1];", "cannot destructure Array: not a keyed collection"},
+		{"string as hash", `def {a} = "a";`, "cannot destructure String: not a keyed collection"},
+		{"short array", "def [a, b] = [1];", "cannot destructure Array: 1 items, expected 2"},
+		{"not a pair", "def a : b = 1;", "cannot destructure Integer: not a pair"},
+		{"no match", "match [1] case {a} then 1; end;", "no case matches [1]"},
+		{"caught", `
+			def e = catch(fn() def {a} = [1]; end);
+			throw(e.msg + " and caught");
+		`, "not a keyed collection and caught"},
+	}
+	for _, test := range tests {
+		_, err := run(t, ts.New(), 20*time.Second, test.src, ts.Limits{})
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.want)
+		}
+	}
+}
+
+// Class patterns test the class of the value. A bare name beginning with a
+// capital letter would hide a class rather than test for it, so it is refused.
+func TestMatchClasses(t *testing.T) {
+	const defs = `
+		class Point()
+			def x, y;
+			def create(x, y)
+				this.x = x;
+				this.y = y;
+			end;
+		end;
+		def kind(v)
+			match v
+			case Point(0, y) then
+				return "on the y axis at " + y.toString();
+			case Point(y = 0) then
+				return "on the x axis";
+			case Point() then
+				return "point";
+			case Number() then
+				return "number";
+			case number then
+				return "other " + number.toString();
+			end;
+		end;
+	`
+	expectAll(t, []scriptTest{
+		{"fields", defs + "kind(Point(0, 3));", "on the y axis at 3"},
+		{"keyword", defs + "kind(Point(2, 0));", "on the x axis"},
+		{"class", defs + "kind(Point(1, 1));", "point"},
+		{"built in", defs + "kind(2.5);", "number"},
+		{"lower case", defs + `kind("a");`, "other a"},
+	})
+	expectErrors(t, []scriptTest{
+		{"capitalised", "match 1\ncase Number then\n\t2;\nend;", "test(2): capitalised name in a pattern: Number"},
+		{"nested", "match [1]\ncase [X] then\n\t2;\nend;", "capitalised name in a pattern: X"},
+	})
+}
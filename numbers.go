@@ -0,0 +1,495 @@
+package ts
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+/*******************************************************************************
+
+	Numbers
+
+*******************************************************************************/
+
+// The kinds of number, from the most precise to the least.
+const (
+	intNum = iota
+	bigNum
+	ratNum
+	fltNum
+	notNum
+)
+
+func numKind(o *Object) int {
+	switch o.c {
+	case IntClass:
+		return intNum
+	case BigIntClass:
+		return bigNum
+	case RationalClass:
+		return ratNum
+	case FltClass:
+		return fltNum
+	}
+	return notNum
+}
+
+// An operation on two numbers, carried out on the most precise kind of number
+// that can hold both of them. Where there is no function for that kind, or the
+// function for int64 overflows, the next kind along is tried. Rationals are
+// only tried if one of the numbers is rational.
+type numOps struct {
+	i func(a, b int64) (*Object, bool)
+	b func(a, b *big.Int) *Object
+	r func(a, b *big.Rat) *Object
+	f func(a, b float64) *Object
+	e func(a, b *Object) *Object // for when one of them is not a number
+}
+
+func (ops numOps) call(a, b *Object) *Object {
+	k, from := numKind(a), numKind(b)
+	if from < k {
+		from = k
+	}
+	if from == notNum && ops.e != nil {
+		return ops.e(a, b)
+	}
+	for k = from; k < notNum; k++ {
+		switch {
+		case k == intNum && ops.i != nil:
+			if res, ok := ops.i(a.ToInt(), b.ToInt()); ok {
+				return res
+			}
+		case k == bigNum && ops.b != nil:
+			return ops.b(toBig(a), toBig(b))
+		case k == ratNum && ops.r != nil && from == ratNum:
+			return ops.r(toRat(a), toRat(b))
+		case k == fltNum && ops.f != nil:
+			return ops.f(toFloat(a), toFloat(b))
+		}
+	}
+	if numKind(a) == notNum {
+		panic(TypeError(a))
+	}
+	panic(TypeError(b))
+}
+
+// A comparison between two numbers. Anything that is not a number compares
+// false.
+func numCmp(test func(c int) bool, ff func(a, b float64) bool) func(a, b *Object) *Object {
+	return numOps{
+		i: func(a, b int64) (*Object, bool) {
+			c := 0
+			if a < b {
+				c = -1
+			} else if a > b {
+				c = 1
+			}
+			return Wrap(test(c)), true
+		},
+		b: func(a, b *big.Int) *Object {
+			return Wrap(test(a.Cmp(b)))
+		},
+		r: func(a, b *big.Rat) *Object {
+			return Wrap(test(a.Cmp(b)))
+		},
+		f: func(a, b float64) *Object {
+			return Wrap(ff(a, b))
+		},
+		e: func(a, b *Object) *Object {
+			return False
+		},
+	}.call
+}
+
+var numEq = numCmp(func(c int) bool {
+	return c == 0
+}, func(a, b float64) bool {
+	return a == b
+})
+
+var numLt = numCmp(func(c int) bool {
+	return c < 0
+}, func(a, b float64) bool {
+	return a < b
+})
+
+var numLte = numCmp(func(c int) bool {
+	return c <= 0
+}, func(a, b float64) bool {
+	return a <= b
+})
+
+var numGt = numCmp(func(c int) bool {
+	return c > 0
+}, func(a, b float64) bool {
+	return a > b
+})
+
+var numGte = numCmp(func(c int) bool {
+	return c >= 0
+}, func(a, b float64) bool {
+	return a >= b
+})
+
+var numAdd = numOps{
+	i: func(a, b int64) (*Object, bool) {
+		r := a + b
+		if (a^r) & (b^r) < 0 {
+			return nil, false
+		}
+		return Wrap(r), true
+	},
+	b: func(a, b *big.Int) *Object {
+		return wrapBig(new(big.Int).Add(a, b))
+	},
+	r: func(a, b *big.Rat) *Object {
+		return wrapRat(new(big.Rat).Add(a, b))
+	},
+	f: func(a, b float64) *Object {
+		return Wrap(a + b)
+	},
+}
+
+var numSub = numOps{
+	i: func(a, b int64) (*Object, bool) {
+		r := a - b
+		if (a^b) & (a^r) < 0 {
+			return nil, false
+		}
+		return Wrap(r), true
+	},
+	b: func(a, b *big.Int) *Object {
+		return wrapBig(new(big.Int).Sub(a, b))
+	},
+	r: func(a, b *big.Rat) *Object {
+		return wrapRat(new(big.Rat).Sub(a, b))
+	},
+	f: func(a, b float64) *Object {
+		return Wrap(a - b)
+	},
+}
+
+var numMul = numOps{
+	i: func(a, b int64) (*Object, bool) {
+		r, ok := mulInt(a, b)
+		return Wrap(r), ok
+	},
+	b: func(a, b *big.Int) *Object {
+		return wrapBig(new(big.Int).Mul(a, b))
+	},
+	r: func(a, b *big.Rat) *Object {
+		return wrapRat(new(big.Rat).Mul(a, b))
+	},
+	f: func(a, b float64) *Object {
+		return Wrap(a * b)
+	},
+}
+
+// Division gives a float unless one of the numbers is rational.
+var numDiv = numOps{
+	r: func(a, b *big.Rat) *Object {
+		checkDivisor(b.Sign() == 0)
+		return wrapRat(new(big.Rat).Quo(a, b))
+	},
+	f: func(a, b float64) *Object {
+		return Wrap(a / b)
+	},
+}
+
+// Division rounding towards zero, and its remainder.
+var numQuot = numOps{
+	i: func(a, b int64) (*Object, bool) {
+		checkDivisor(b == 0)
+		if a == math.MinInt64 && b == -1 {
+			return nil, false
+		}
+		return Wrap(a / b), true
+	},
+	b: func(a, b *big.Int) *Object {
+		checkDivisor(b.Sign() == 0)
+		return wrapBig(new(big.Int).Quo(a, b))
+	},
+	r: func(a, b *big.Rat) *Object {
+		return wrapBig(ratQuot(a, b))
+	},
+	f: func(a, b float64) *Object {
+		return Wrap(math.Trunc(a / b))
+	},
+}
+
+var numMod = numOps{
+	i: func(a, b int64) (*Object, bool) {
+		checkDivisor(b == 0)
+		return Wrap(a % b), true
+	},
+	b: func(a, b *big.Int) *Object {
+		checkDivisor(b.Sign() == 0)
+		return wrapBig(new(big.Int).Rem(a, b))
+	},
+	r: func(a, b *big.Rat) *Object {
+		q := new(big.Rat).SetInt(ratQuot(a, b))
+		return wrapRat(q.Sub(a, q.Mul(q, b)))
+	},
+	f: func(a, b float64) *Object {
+		return Wrap(math.Mod(a, b))
+	},
+}
+
+var numPow = numOps{
+	i: func(a, b int64) (*Object, bool) {
+		if b < 0 {
+			return nil, false
+		}
+		res, ok := int64(1), true
+		for {
+			if b & 1 != 0 {
+				if res, ok = mulInt(res, a); !ok {
+					return nil, false
+				}
+			}
+			if b >>= 1; b == 0 {
+				return Wrap(res), true
+			}
+			if a, ok = mulInt(a, a); !ok {
+				return nil, false
+			}
+		}
+	},
+	b: func(a, b *big.Int) *Object {
+		if b.Sign() < 0 {
+			return Wrap(math.Pow(bigFloat(a), bigFloat(b)))
+		}
+		return wrapBig(bigPow(a, b))
+	},
+	r: func(a, b *big.Rat) *Object {
+		if !b.IsInt() || !b.Num().IsInt64() {
+			x, _ := a.Float64()
+			y, _ := b.Float64()
+			return Wrap(math.Pow(x, y))
+		}
+		n := b.Num()
+		if n.Sign() < 0 {
+			checkDivisor(a.Sign() == 0)
+			a = new(big.Rat).Inv(a)
+			n = new(big.Int).Neg(n)
+		}
+		num := bigPow(a.Num(), n)
+		den := bigPow(a.Denom(), n)
+		return wrapRat(new(big.Rat).SetFrac(num, den))
+	},
+	f: func(a, b float64) *Object {
+		return Wrap(math.Pow(a, b))
+	},
+}
+
+// Bitwise operations only apply to integers.
+var numAnd = numOps{
+	i: func(a, b int64) (*Object, bool) {
+		return Wrap(a & b), true
+	},
+	b: func(a, b *big.Int) *Object {
+		return wrapBig(new(big.Int).And(a, b))
+	},
+}
+
+var numOr = numOps{
+	i: func(a, b int64) (*Object, bool) {
+		return Wrap(a | b), true
+	},
+	b: func(a, b *big.Int) *Object {
+		return wrapBig(new(big.Int).Or(a, b))
+	},
+}
+
+var numXor = numOps{
+	i: func(a, b int64) (*Object, bool) {
+		return Wrap(a ^ b), true
+	},
+	b: func(a, b *big.Int) *Object {
+		return wrapBig(new(big.Int).Xor(a, b))
+	},
+}
+
+// Operations on a single integer, which may be big.
+func intNeg(o *Object) *Object {
+	if o.c == IntClass {
+		if x := o.ToInt(); x != math.MinInt64 {
+			return Wrap(-x)
+		}
+	}
+	return wrapBig(new(big.Int).Neg(toBig(o)))
+}
+
+func intNot(o *Object) *Object {
+	if o.c == IntClass {
+		return Wrap(^o.ToInt())
+	}
+	return wrapBig(new(big.Int).Not(toBig(o)))
+}
+
+func intShl(o, x *Object) *Object {
+	n := shiftCount(x)
+	if o.c == IntClass {
+		a := o.ToInt()
+		if n < 63 && a << n >> n == a {
+			return Wrap(a << n)
+		}
+	}
+	a := toBig(o)
+	if a.Sign() != 0 && (n > maxIntBits || uint(a.BitLen()) + n > maxIntBits) {
+		panic(tooLarge())
+	}
+	return wrapBig(new(big.Int).Lsh(a, n))
+}
+
+func intShr(o, x *Object) *Object {
+	n := shiftCount(x)
+	if o.c == IntClass {
+		return Wrap(o.ToInt() >> n)
+	}
+	return wrapBig(new(big.Int).Rsh(toBig(o), n))
+}
+
+func fltNeg(o *Object) *Object {
+	return Wrap(-o.ToFloat())
+}
+
+func mulInt(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	r := a * b
+	if r/b != a || a == math.MinInt64 && b == -1 {
+		return 0, false
+	}
+	return r, true
+}
+
+func ratQuot(a, b *big.Rat) *big.Int {
+	checkDivisor(b.Sign() == 0)
+	q := new(big.Rat).Quo(a, b)
+	return new(big.Int).Quo(q.Num(), q.Denom())
+}
+
+func checkDivisor(zero bool) {
+	if zero {
+		panic(fmt.Errorf("division by zero"))
+	}
+}
+
+func shiftCount(x *Object) uint {
+	if x.c == BigIntClass && x.data.(*big.Int).Sign() > 0 {
+		return ^uint(0)
+	}
+	n := x.ToInt()
+	if n < 0 {
+		panic(fmt.Errorf("negative shift count: %d", n))
+	}
+	return uint(n)
+}
+
+// The most bits that the result of a shift or a power may have. Larger results
+// raise an error rather than using up the memory of the host.
+const maxIntBits = 1 << 24
+
+func tooLarge() error {
+	return fmt.Errorf("integer too large: more than %d bits", maxIntBits)
+}
+
+// Raise a to the power of n, which is not negative.
+func bigPow(a, n *big.Int) *big.Int {
+	if a.CmpAbs(big.NewInt(1)) > 0 {
+		// the result has at least this many bits
+		if !n.IsInt64() || n.Int64() > maxIntBits ||
+		   int64(a.BitLen()-1) * n.Int64() >= maxIntBits {
+			panic(tooLarge())
+		}
+	}
+	return new(big.Int).Exp(a, n, nil)
+}
+
+// An integer, as a BigInt only if it will not fit in an Integer.
+func wrapBig(x *big.Int) *Object {
+	if x.IsInt64() {
+		return wrapInt(x.Int64())
+	}
+	return &Object{c: BigIntClass, data: x}
+}
+
+// A rational, as an Integer if it is whole.
+func wrapRat(x *big.Rat) *Object {
+	if x.IsInt() {
+		return wrapBig(x.Num())
+	}
+	return &Object{c: RationalClass, data: x}
+}
+
+func toBig(o *Object) *big.Int {
+	if o.c == BigIntClass {
+		return o.data.(*big.Int)
+	}
+	return big.NewInt(o.ToInt())
+}
+
+func toRat(o *Object) *big.Rat {
+	if o.c == RationalClass {
+		return o.data.(*big.Rat)
+	}
+	return new(big.Rat).SetInt(toBig(o))
+}
+
+func toFloat(o *Object) float64 {
+	switch o.c {
+	case IntClass:
+		return float64(o.ToInt())
+	case BigIntClass:
+		return bigFloat(o.data.(*big.Int))
+	case RationalClass:
+		f, _ := o.data.(*big.Rat).Float64()
+		return f
+	}
+	return o.ToFloat()
+}
+
+func bigFloat(x *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(x).Float64()
+	return f
+}
+
+// The integer part of a float.
+func floatInt(f float64) *Object {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		panic(fmt.Errorf("cannot convert %v to an integer", f))
+	}
+	if f >= -(1 << 63) && f < 1 << 63 {
+		return Wrap(int64(f))
+	}
+	x, _ := big.NewFloat(f).Int(nil)
+	return wrapBig(x)
+}
+
+// Make a rational from the arguments to Rational().
+func newRational(args []*Object) *Object {
+	switch len(args) {
+	case 1:
+		x := args[0]
+		switch x.c {
+		case FltClass:
+			return wrapRat(new(big.Rat).SetFloat64(x.ToFloat()))
+		case StringClass:
+			r, ok := new(big.Rat).SetString(x.ToString())
+			if !ok {
+				panic(fmt.Errorf("not a number: %s", x.ToString()))
+			}
+			return wrapRat(r)
+		}
+		return wrapRat(new(big.Rat).Set(toRat(x)))
+	case 2:
+		num, den := toBig(args[0]), toBig(args[1])
+		checkDivisor(den.Sign() == 0)
+		return wrapRat(new(big.Rat).SetFrac(num, den))
+	}
+	panic(ArgError(len(args)))
+}
@@ -0,0 +1,57 @@
+package ts_test
+
+import (
+	"testing"
+)
+
+// Integers that overflow 64 bits become BigInts, and come back once they fit.
+func TestBigInts(t *testing.T) {
+	expectAll(t, []scriptTest{
+		{"add", "9223372036854775807 + 1;", "9223372036854775808"},
+		{"sub", "-9223372036854775807 - 2;", "-9223372036854775809"},
+		{"mul", "4294967296 * 4294967296;", "18446744073709551616"},
+		{"neg", "-(-9223372036854775807 - 1);", "9223372036854775808"},
+		{"pow", "2 ** 100;", "1267650600228229401496703205376"},
+		{"shift", "1 << 64;", "18446744073709551616"},
+		{"literal", "123456789012345678901234567890;", "123456789012345678901234567890"},
+		{"class", "[(2 ** 64).is(BigInt), (2 ** 64).is(Integer), (2 ** 64 - 2 ** 64).is(BigInt)];", "[true, true, false]"},
+		{"back again", "2 ** 100 ~/ 2 ** 98;", "4"},
+		{"equal", "[2 ** 64 == 18446744073709551616, 2 ** 64 > 2 ** 63, 2 ** 64 == 2.0 ** 64];", "[true, true, true]"},
+		{"bitwise", "[(2 ** 70 | 1) & 3, ~(2 ** 70) + 2 ** 70];", "[1, -1]"},
+		{"power of one", "[1 ** (2 ** 70), (-1) ** (2 ** 70 + 1), 0 << (2 ** 70), 5 >> (2 ** 70)];", "[1, -1, 0, 0]"},
+		{"float", "(2 ** 64).toFloat();", "1.8446744073709552e+19"},
+	})
+}
+
+// Rationals are exact, and become Integers when they are whole.
+func TestRationals(t *testing.T) {
+	expectAll(t, []scriptTest{
+		{"add", "Rational(1, 3) + 1;", "4/3"},
+		{"divide", "Rational(1, 3) / 2;", "1/6"},
+		{"string", `Rational("0.25");`, "1/4"},
+		{"parts", "def r = Rational(6, 4); [r.numerator, r.denominator];", "[3, 2]"},
+		{"whole", "Rational(1, 3) + Rational(2, 3);", "1"},
+		{"whole integer", "def r = Rational(1, 3) + Rational(2, 3); [r.is(Integer), r.is(Rational), r + 1];", "[true, false, 2]"},
+		{"whole big", "(Rational(1, 2) * 2 ** 100).is(BigInt);", "true"},
+		{"made whole", "[Rational(4, 2), Rational(4, 2).is(Integer)];", "[2, true]"},
+		{"power", "Rational(2, 3) ** -2;", "9/4"},
+		{"mixed float", "Rational(1, 2) + 0.25;", "0.75"},
+	})
+}
+
+// Results too large to hold raise errors that scripts can catch.
+func TestNumberErrors(t *testing.T) {
+	expectErrors(t, []scriptTest{
+		{"shift", "1 << 100000000000;", "test(1): integer too large"},
+		{"big shift", "1 << (2 ** 70);", "integer too large"},
+		{"power", "2 ** 100000000000;", "integer too large"},
+		{"big power", "3 ** (2 ** 70);", "integer too large"},
+		{"rational power", "Rational(2, 3) ** 100000000000;", "integer too large"},
+		{"negative shift", "1 << -1;", "negative shift count: -1"},
+		{"rational zero", "Rational(1, 0);", "division by zero"},
+		{"caught", `
+			def e = catch(fn() = 1 << 100000000000);
+			throw(e.msg + " was caught");
+		`, "was caught"},
+	})
+}
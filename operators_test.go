@@ -0,0 +1,76 @@
+package ts_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+func TestIntegerDivision(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"numbers", "7 ~/ 2;", "3"},
+		{"variable", "def x = 9; x ~/ 2;", "4"},
+		{"call", "def f() = 10; f() ~/ 3;", "3"},
+		{"index", "[11][0] ~/ 5;", "2"},
+		{"precedence", "1 + 7 ~/ 2 * 2;", "7"},
+		{"big", "2 ** 100 ~/ 2 ** 98;", "4"},
+		{"compound", "def x = 17; x ~/= 5; x;", "3"},
+		{"method", "7.__quot__(2);", "3"},
+		{"complement", "~7 ~/ 2;", "-4"},
+		{"user class", `
+			class Cake()
+				def __quot__(n) = "slices";
+			end;
+			Cake() ~/ 4;
+		`, "slices"},
+	}
+	for _, test := range tests {
+		x, err := run(t, ts.New(), 20*time.Second, test.src, ts.Limits{})
+		expect(t, test.name, x, err, test.want)
+	}
+}
+
+func TestIntegerDivisionErrors(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"by zero", "1 ~/ 0;", "division by zero"},
+		{"string", `"ab" ~/ 2;`, "undefined: String.__quot__"},
+		{"caught", `
+			def e = catch(fn() = 1 ~/ 0);
+			throw(e.msg + " was caught");
+		`, "division by zero was caught"},
+	}
+	for _, test := range tests {
+		_, err := run(t, ts.New(), 20*time.Second, test.src, ts.Limits{})
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.want)
+		}
+	}
+}
+
+// "//" always begins a comment, wherever it appears.
+func TestTrailingComments(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"function header", "def f(x) // doubles\n\treturn x * 2;\nend;\nf(4);", "8"},
+		{"class header", "class A(Object) // a class\n\tdef x = 1;\nend;\nA().x;", "1"},
+		{"list", "def a = [1, 2] // a list\n;\na;", "[1, 2]"},
+		{"string", "def s = \"x\" // comment\n;\ns;", "x"},
+		{"number", "def n = 7 // 2\n;\nn;", "7"},
+		{"name", "def n = 3;\nn // 2\n;", "3"},
+		{"call", "def f() = 10;\nf() // 3\n;", "10"},
+		{"statement", "def x = 8; // x ~/ 2;\nx;", "8"},
+		{"line", "// 7 ~/ 2;\n5;", "5"},
+		{"after comma", "[1, // one\n2];", "[1, 2]"},
+		{"after operator", "1 + // 2\n3;", "4"},
+	}
+	for _, test := range tests {
+		x, err := run(t, ts.New(), 20*time.Second, test.src, ts.Limits{})
+		expect(t, test.name, x, err, test.want)
+	}
+}
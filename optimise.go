@@ -0,0 +1,322 @@
+package ts
+
+import (
+	. "github.com/bobappleyard/ts/parse"
+	. "github.com/bobappleyard/ts/bytecode"
+)
+
+/*******************************************************************************
+
+	Optimisation
+
+*******************************************************************************/
+
+// Set how much the code compiled into the unit from now on is optimised.
+//
+// At level 0, the default, code is compiled as it is written. At level 1,
+// arithmetic and comparisons on literal numbers are carried out when the code
+// is compiled, branches on constants are decided, code that cannot be reached
+// is removed, jumps to jumps go straight to where they end up and instructions
+// with no effect are left out. Level 2 also combines common pairs of
+// instructions into one.
+//
+// Optimised code behaves in the same way, apart from running faster, but a
+// debugger or a disassembler will find less of it.
+func (u *Unit) SetOpt(level int) {
+	u.opt = level
+}
+
+// The operations that are carried out on literal numbers, which have the same
+// effect whenever the code is run. Bitwise operations only apply to integers.
+var foldOps = map[string] func(a, b *Object) *Object {
+	"__add__": numAdd.call,
+	"__sub__": numSub.call,
+	"__mul__": numMul.call,
+	"__div__": numDiv.call,
+	"__quot__": numQuot.call,
+	"__mod__": numMod.call,
+	"__pow__": numPow.call,
+	"__eq__": numEq,
+	"__lt__": numLt,
+	"__lte__": numLte,
+	"__gt__": numGt,
+	"__gte__": numGte,
+}
+
+var foldIntOps = map[string] func(a, b *Object) *Object {
+	"__band__": numAnd.call,
+	"__bor__": numOr.call,
+	"__bxor__": numXor.call,
+	"__shl__": intShl,
+	"__shr__": intShr,
+}
+
+var foldIntUnary = map[string] func(a *Object) *Object {
+	"__neg__": intNeg,
+	"__bnot__": intNot,
+}
+
+// Replace operations on literal numbers with their results. Patterns are left
+// alone, as they give operators other meanings.
+func foldConstants(n *Node) {
+	if n == nil {
+		return
+	}
+	switch n.Kind {
+	case patNode:
+		return
+	case caseNode:
+		for _, x := range n.Child[1:] {
+			foldConstants(x)
+		}
+		return
+	}
+	for _, x := range n.Child {
+		foldConstants(x)
+	}
+	if n.Kind != callNode || n.Child[0].Kind != lookNode {
+		return
+	}
+	m := n.Child[0]
+	a := literalNum(m.Child[0])
+	if a == nil {
+		return
+	}
+	var f func() *Object
+	switch len(n.Child) {
+	case 1:
+		if g := foldIntUnary[m.Token.Text]; g != nil && a.Is(IntClass) {
+			f = func() *Object { return g(a) }
+		}
+		if m.Token.Text == "__neg__" && a.c == FltClass {
+			f = func() *Object { return fltNeg(a) }
+		}
+	case 2:
+		b := literalNum(n.Child[1])
+		if b == nil {
+			return
+		}
+		if g := foldOps[m.Token.Text]; g != nil {
+			f = func() *Object { return g(a, b) }
+		}
+		if g := foldIntOps[m.Token.Text]; g != nil && a.Is(IntClass) {
+			f = func() *Object { return g(a, b) }
+		}
+	}
+	if f == nil {
+		return
+	}
+	if x, ok := tryFold(f); ok {
+		*n = Node{Kind: valNode, Token: n.Token, Data: x, Parent: n.Parent}
+	}
+}
+
+// The number that a node stands for, or nil if it does not stand for one.
+func literalNum(n *Node) *Object {
+	if n.Kind != valNode {
+		return nil
+	}
+	x, ok := n.Data.(*Object)
+	if !ok || numKind(x) == notNum {
+		return nil
+	}
+	return x
+}
+
+// Operations that fail, such as division by zero, are left to fail when the
+// code is run.
+func tryFold(f func() *Object) (x *Object, ok bool) {
+	defer func() {
+		if recover() != nil {
+			x, ok = nil, false
+		}
+	}()
+	return f(), true
+}
+
+// An instruction in some code being optimised. Jump targets are held as the
+// index of the instruction jumped to.
+type peepInstr struct {
+	op uint32
+	args []uint32
+	dead bool
+	into int // the instruction it was combined into, or -1
+}
+
+// The operands of these instructions are jump targets.
+func isJump(op uint32) bool {
+	switch op {
+	case JUMP, BRANCH, TRY, FINALLY, FRAME, DEFAULT:
+		return true
+	}
+	return false
+}
+
+// Control never carries on past these instructions to the next one.
+func isFinal(op uint32) bool {
+	switch op {
+	case JUMP, RETURN, THROW:
+		return true
+	}
+	return false
+}
+
+// These instructions set the value being worked on without looking at it.
+func setsValue(op uint32) bool {
+	switch op {
+	case VALUE, ACCESSOR, BOUND, FREE, GLOBAL, THIS, PUSHV, PUSHB, GLOBALU, BOUNDU:
+		return true
+	}
+	return false
+}
+
+// Instructions that may be combined, and what they are combined into.
+var fused = map[[2]uint32] uint32 {
+	{VALUE, PUSH}: PUSHV,
+	{BOUND, PUSH}: PUSHB,
+	{GLOBAL, UNBOX}: GLOBALU,
+	{BOUND, UNBOX}: BOUNDU,
+}
+
+// Improve some code that starts at an offset into its block, and update its
+// table of source positions to match.
+func (u *Unit) peephole(c []uint32, base int, src []srcPos) ([]uint32, []srcPos) {
+	// decode the instructions
+	var is []*peepInstr
+	index := make([]int, len(c)+1)
+	for p := 0; p < len(c); {
+		w := Width(c[p:])
+		index[p] = len(is)
+		args := append([]uint32(nil), c[p+1:p+w]...)
+		is = append(is, &peepInstr{op: c[p], args: args, into: -1})
+		p += w
+	}
+	index[len(c)] = len(is)
+	for _, x := range is {
+		if isJump(x.op) {
+			x.args[0] = uint32(index[int(x.args[0])-base])
+		}
+	}
+	// the first instruction that is still there, from some point on
+	live := func(i int) int {
+		for i < len(is) && is[i].dead {
+			i++
+		}
+		return i
+	}
+	for changed := true; changed; {
+		changed = false
+		// jumps to jumps
+		for _, x := range is {
+			if x.dead || !isJump(x.op) {
+				continue
+			}
+			t := live(int(x.args[0]))
+			for k := 0; k < len(is) && t < len(is) && is[t].op == JUMP; k++ {
+				t = live(int(is[t].args[0]))
+			}
+			if uint32(t) != x.args[0] {
+				x.args[0] = uint32(t)
+				changed = true
+			}
+		}
+		targets := make([]bool, len(is)+1)
+		for _, x := range is {
+			if !x.dead && isJump(x.op) {
+				targets[x.args[0]] = true
+			}
+		}
+		// instructions with no effect, and pairs that can be simplified
+		for i, x := range is {
+			if x.dead {
+				continue
+			}
+			j := live(i+1)
+			var y *peepInstr
+			if j < len(is) {
+				y = is[j]
+			}
+			switch {
+			case x.op == RETRACT && x.args[0] == 0,
+			     x.op == JUMP && live(int(x.args[0])) == j,
+			     x.op == RETRACT && y != nil && y.op == RETURN,
+			     x.op == VALUE && y != nil && setsValue(y.op):
+				x.dead = true
+			case y == nil || targets[j]:
+				continue
+			case x.op == RETRACT && y.op == RETRACT:
+				x.args[0] += y.args[0]
+				y.dead = true
+			case x.op == VALUE && y.op == BRANCH:
+				// the branch is decided already
+				if u.v[x.args[0]] == False {
+					y.op = JUMP
+				} else {
+					y.dead = true
+				}
+			case u.opt >= 2 && fused[[2]uint32{x.op, y.op}] != 0:
+				x.op = fused[[2]uint32{x.op, y.op}]
+				y.dead = true
+				y.into = i
+			default:
+				continue
+			}
+			changed = true
+		}
+		// code that cannot be reached
+		seen := make([]bool, len(is)+1)
+		todo := []int{live(0)}
+		for len(todo) != 0 {
+			i := todo[len(todo)-1]
+			todo = todo[:len(todo)-1]
+			if seen[i] || i == len(is) {
+				continue
+			}
+			seen[i] = true
+			x := is[i]
+			if isJump(x.op) {
+				todo = append(todo, live(int(x.args[0])))
+			}
+			if !isFinal(x.op) {
+				todo = append(todo, live(i+1))
+			}
+		}
+		for i, x := range is {
+			if !x.dead && !seen[i] {
+				x.dead = true
+				changed = true
+			}
+		}
+	}
+	// encode what is left
+	at := make([]int, len(is)+1)
+	var res []uint32
+	for i, x := range is {
+		at[i] = len(res) + base
+		if !x.dead {
+			res = append(res, x.op)
+			res = append(res, x.args...)
+		}
+	}
+	at[len(is)] = len(res) + base
+	for i, x := range is {
+		if !x.dead && isJump(x.op) {
+			res[at[i]-base+1] = uint32(at[live(int(x.args[0]))])
+		}
+	}
+	// a position for code that has gone holds for the code after it, except
+	// where a pair of instructions has been combined into one
+	var ps []srcPos
+	for _, s := range src {
+		i := index[s.offset-base]
+		if i < len(is) && is[i].into != -1 {
+			i = is[i].into
+		}
+		s.offset = at[live(i)]
+		if l := len(ps); l != 0 && ps[l-1].offset == s.offset {
+			ps = ps[:l-1]
+		}
+		ps = append(ps, s)
+	}
+	return res, ps
+}
@@ -0,0 +1,148 @@
+package ts_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+// Run a script with its code optimised to some level.
+func runAt(level int, src string, l ts.Limits) (*ts.Object, error) {
+	u := new(ts.Unit)
+	u.SetOpt(level)
+	if err := u.CompileErr(strings.NewReader(src), "test"); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	return ts.New().ExecLimits(ctx, u, l)
+}
+
+// Scripts give the same results at every level.
+func TestOptimise(t *testing.T) {
+	tests := []scriptTest{
+		{"arithmetic", "60 * 60 * 24;", "86400"},
+		{"mixed", "[1 + 2.5, 7 ~/ 2, 7 % 3, 2 ** 10, 7 / 2];", "[3.5, 3, 1, 1024, 3.5]"},
+		{"overflow", "9223372036854775807 + 1;", "9223372036854775808"},
+		{"bitwise", "[6 & 3, 6 | 3, 6 ^ 3, 1 << 4, 16 >> 2, -5, ~0];", "[2, 7, 5, 16, 4, -5, -1]"},
+		{"comparisons", "[1 < 2, 2 <= 1, 3 == 3.0, 1 > 2, 2 >= 2];", "[true, false, true, false, true]"},
+		{"branches", "def r = []; if 1 < 2 then r.push(1); else r.push(2); end; if false then r.push(3); end; r;", "[1]"},
+		{"loops", "def n = 0, i = 0; while i < 10 do i += 1; if i % 2 == 0 then continue; end; n += i; end; n;", "25"},
+		{"dead code", "def f() return 1; throw(\"unreachable\"); end; f();", "1"},
+		{"variables", "def a = 2; a * 3 + 1;", "7"},
+		{"patterns", `
+			def m(x)
+				match x
+				case 1 | 2 then
+					return "small";
+				case [a, 1 + 1] then
+					return a;
+				case _ then
+					return "big";
+				end;
+			end;
+			[m(1), m(2), m(["pair", 2]), m(5)];
+		`, "[small, small, pair, big]"},
+		{"closures", "def mk(n) = fn(x) = x + n; mk(1)(2) + mk(10)(20);", "33"},
+		{"generators", "def g() yield 1 + 1; yield 3; end; def r = []; for x in g() do r.push(x); end; r;", "[2, 3]"},
+		{"try", "def r = []; try throw(\"x\"); catch e r.push(e.msg); finally r.push(2 * 3); end; r;", "[x, 6]"},
+		{"caught", "catch(fn() = 1 ~/ 0).msg;", "division by zero"},
+		{"caught position", "def e = catch(fn()\n\treturn 1 ~/ 0;\nend);\ne.line;", "2"},
+	}
+	for _, test := range tests {
+		for level := 0; level <= 2; level++ {
+			x, err := runAt(level, test.src, ts.Limits{})
+			if err != nil {
+				t.Errorf("%s (level %d): %s", test.name, level, err)
+			} else if x.String() != test.want {
+				t.Errorf("%s (level %d): got %s, want %s", test.name, level, x, test.want)
+			}
+		}
+	}
+}
+
+// Errors are raised at the same place at every level.
+func TestOptimiseErrors(t *testing.T) {
+	tests := []scriptTest{
+		{"division", "def a = 1;\n\n1 ~/ 0;", "test(3): division by zero"},
+		{"shift", "1 << 1.5;", "test(1): wrong type"},
+		{"huge shift", "def a = 1;\n1 << 100000000000;", "test(2): integer too large"},
+		{"huge power", "2 ** 100000000000;", "test(1): integer too large"},
+		{"wrong type", "def a = 1;\n1 + \"a\";", "test(2): wrong type"},
+		{"too few", "def f(a) = a;\nf();", "test(2): wrong number of arguments 0"},
+		{"too many", "def f(a) = a;\n\nf(1, 2);", "test(3): wrong number of arguments 2"},
+		{"undefined", "1 + 2;\nnope;", "test(2): undefined variable: nope"},
+		{"after branch", "if 1 < 2 then\n\tthrow(\"taken\");\nend;", "test(2): taken"},
+	}
+	for _, test := range tests {
+		for level := 0; level <= 2; level++ {
+			_, err := runAt(level, test.src, ts.Limits{})
+			if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+				t.Errorf("%s (level %d): got %v, want %s", test.name, level, err, test.want)
+			}
+		}
+	}
+}
+
+// Limits hold however the code is optimised, and cannot be caught.
+func TestOptimiseLimits(t *testing.T) {
+	for level := 0; level <= 2; level++ {
+		_, err := runAt(level, `
+			try
+				while 1 < 2 do end;
+			catch e
+				throw("caught");
+			end;
+		`, ts.Limits{Instructions: 10000})
+		var l *ts.LimitError
+		if !errors.As(err, &l) {
+			t.Errorf("level %d: got %v, want a limit", level, err)
+		}
+	}
+}
+
+// What each level leaves out of the code.
+func TestOptimiseCode(t *testing.T) {
+	tests := []struct {
+		name, src string
+		level int
+		want, unwanted string
+	}{
+		{"not folded", "60 * 60 * 24;", 0, "__mul__", "86400"},
+		{"folded", "60 * 60 * 24;", 1, "86400", "__mul__"},
+		{"division by zero", "1 ~/ 0;", 1, "__quot__", ""},
+		{"huge shift", "1 << 100000000000;", 1, "__shl__", ""},
+		{"variable", "def a = 1; a * 2;", 1, "__mul__", "PUSHB"},
+		{"dead", "def f() return 1; g(); end;", 1, "RETURN", "(g)"},
+		{"branch", "if false then g(); end;", 1, "VALUE", "(g)"},
+		{"unfused", "def f(a) = h(a, 1);", 1, "BOUND", "PUSHB"},
+		{"fused", "def f(a) = h(a, 1);", 2, "GLOBALU", "  BOUND "},
+	}
+	for _, test := range tests {
+		u := new(ts.Unit)
+		u.SetOpt(test.level)
+		if err := u.CompileErr(strings.NewReader(test.src), "test"); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		var buf bytes.Buffer
+		u.Disassemble(&buf)
+		listing := buf.String()
+		if !strings.Contains(listing, test.want) || test.unwanted != "" && strings.Contains(listing, test.unwanted) {
+			t.Errorf("%s: want %q and not %q in:\n%s", test.name, test.want, test.unwanted, listing)
+		}
+	}
+}
+
+// Optimised units may be saved and loaded.
+func TestOptimisedUnits(t *testing.T) {
+	u := new(ts.Unit)
+	u.SetOpt(2)
+	u.Compile(strings.NewReader("def f(a, b) = a * b + 60 * 60;\nf(2, 3);"), "test")
+	x, err := ts.New().ExecErr(reload(t, u))
+	expect(t, "reloaded", x, err, "3606")
+}
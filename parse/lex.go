@@ -1,6 +1,7 @@
 package parse
 
 import (
+	"bytes"
 	"io"
 	"unicode/utf8"
 )
@@ -22,15 +23,18 @@ type Source struct {
 	buf []byte
 	file string
 	s, p, l, line, nline int // start, position, last position
+	col int
+	cs int // where col was last worked out
 }
 
 // A state in the lexical analysis. The programmer should provide these to the
 // Lexer to allow it to process text.
 type State func(l *Source) State
 
-// A token represents a section of a source text.
+// A token represents a section of a source text. Columns count characters
+// from 1.
 type Token struct {
-	Kind, Line int
+	Kind, Line, Col int
 	File, Text string
 }
 
@@ -77,6 +81,12 @@ func (l *Lexer) Lookahead() Token {
 	return l.t[0]
 }
 
+// Number the lines of the source from n rather than 1, for text that has been
+// taken from another source.
+func (l *Lexer) SetLine(n int) {
+	l.src.line, l.src.nline = n, n
+}
+
 // Return the text that the lexer has scanned.
 func (l *Lexer) Scanned() string {
 	return string(l.src.buf[:l.src.p])
@@ -88,6 +98,7 @@ func (s *Source) init(src io.Reader, l *Lexer, nm string) *Source {
 	s.file = nm
 	s.line = 1
 	s.nline = 1
+	s.col = 1
 	return s
 }
 
@@ -127,11 +138,21 @@ func (s *Source) Peek() rune {
 func (s *Source) Clear() {
 	s.s = s.p
 	s.line = s.nline
+	// count on from the last column worked out, so long lines are not scanned
+	// again for each token
+	if s.s < s.cs {
+		s.cs, s.col = 0, 1
+	}
+	if nl := bytes.LastIndexByte(s.buf[s.cs:s.s], '\n'); nl != -1 {
+		s.cs, s.col = s.cs + nl + 1, 1
+	}
+	s.col += utf8.RuneCount(s.buf[s.cs:s.s])
+	s.cs = s.s
 }
 
 // Save the portion of text currently under consideration with a provided Kind.
 func (s *Source) Save(k int) {
-	t := Token{k, s.line, s.file, string(s.buf[s.s:s.p])}
+	t := Token{k, s.line, s.col, s.file, string(s.buf[s.s:s.p])}
 	s.lex.t = append(s.lex.t, t)
 }
 
@@ -159,9 +159,19 @@ func (p *Parser) RegElse(ep Prefix) {
 
 
 
+// An error found at a particular point in a source text.
+type Error struct {
+	File string
+	Line, Col int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s(%d): %s", e.File, e.Line, e.Msg)
+}
+
 func TokenError(format string, t Token, args... interface{}) error {
-	args = append([]interface{}{t.File, t.Line}, args...)
-	return fmt.Errorf("%s(%d): " + format, args...)
+	return &Error{t.File, t.Line, t.Col, fmt.Sprintf(format, args...)}
 }
 
 func Expected(s string, t Token) error {
@@ -3,7 +3,9 @@ package ts
 import (
 	"unicode"
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 	. "github.com/bobappleyard/ts/parse"
 )
 
@@ -40,10 +42,23 @@ func start(l *Source) State {
 		return inCmt
 	case '"':
 		return inStr
+	case '`':
+		return inRaw
 	case '_':
 		return inId
+	case '~':
+		// "//" begins a comment, so integer division is "~/"
+		if l.Peek() == '/' {
+			l.Read()
+			if l.Peek() == '=' {
+				l.Read()
+			}
+			l.Save(op)
+			return nil
+		}
+		return inOp
 	case '!', '$', '%', '^', '&', '*', '-', '=', 
-	     '+', '~', '?', '@', '<', '>', '|':
+	     '+', '?', '@', '<', '>', '|':
 		return inOp
 	}
 	if unicode.IsLetter(r) {
@@ -96,16 +111,68 @@ func inCmtbe(l *Source) State {
 }
 
 func inStr(l *Source) State {
-	switch l.Read() {
-	case Eof:
-		panic(UnexpectedEof())
-	case '"':
-		l.Save(str)
-		return nil
-	case '\\':
-		l.Read()
+	scanStr(l)
+	l.Save(str)
+	return nil
+}
+
+// Read the rest of a string, along with any expressions inside it.
+func scanStr(l *Source) {
+	for {
+		switch l.Read() {
+		case Eof:
+			panic(UnexpectedEof())
+		case '"':
+			return
+		case '\\':
+			l.Read()
+		case '$':
+			if l.Peek() == '{' {
+				l.Read()
+				scanInterp(l)
+			}
+		}
+	}
+}
+
+// Read an expression inside a string, up to the closing brace. parseStr()
+// parses it properly.
+func scanInterp(l *Source) {
+	depth := 0
+	for {
+		switch l.Read() {
+		case Eof:
+			panic(UnexpectedEof())
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return
+			}
+			depth--
+		case '"':
+			scanStr(l)
+		case '`':
+			scanRaw(l)
+		}
+	}
+}
+
+func inRaw(l *Source) State {
+	scanRaw(l)
+	l.Save(str)
+	return nil
+}
+
+func scanRaw(l *Source) {
+	for {
+		switch l.Read() {
+		case Eof:
+			panic(UnexpectedEof())
+		case '`':
+			return
+		}
 	}
-	return inStr
 }
 
 func inId(l *Source) State {
@@ -176,6 +243,8 @@ func vNode(x interface{}) *Node {
 
 var keywords = []string {
 	"if", "then", "else", "elif",
+	"while", "in", "do", "break", "continue",
+	"try", "yield",
 	"true", "false", "nil",
 	"def",
 	"fn", "return", "end", 
@@ -241,6 +310,7 @@ func parseEof(p *Parser, l *Lexer, t Token) *Node {
 	e := ErrorClass.New(Wrap("unexpected eof"))
 	ErrorClass.Set(e, 1, Wrap(t.File))
 	ErrorClass.Set(e, 2, Wrap(t.Line))
+	ErrorClass.Set(e, 4, Wrap(t.Col))
 	panic(e)
 }
 
@@ -274,6 +344,18 @@ func (q leftOp) Infix(p *Parser, l *Lexer, left *Node, t Token) *Node {
 	return n.Add(tNode(lookNode, q.m).Add(left), right)
 }
 
+// "*" also marks the rest of an array in a pattern, as in [a, b*] = xs.
+type mulOp struct {
+	leftOp
+}
+
+func (q mulOp) Infix(p *Parser, l *Lexer, left *Node, t Token) *Node {
+	if l.Lookahead().Text == "]" {
+		return (&Node{Kind: restNode, Token: t}).Add(left)
+	}
+	return q.leftOp.Infix(p, l, left, t)
+}
+
 type rightOp struct {
 	p int
 	m string
@@ -311,19 +393,66 @@ func parseGroup(p *Parser, l *Lexer, t Token) *Node {
 
 // value parsers
 func parseStr(p *Parser, l *Lexer, t Token) *Node {
-	s, err := strconv.Unquote(t.Text)
+	if t.Text[0] == '`' {
+		// raw strings have no escapes
+		return strNode(t, t.Text)
+	}
+	// "a${x}b" is "a" + x.toString() + "b"
+	var n *Node
+	add := func(x *Node) {
+		if n == nil {
+			n = x
+			return
+		}
+		m := &Node{Kind: callNode, Token: t}
+		n = m.Add(tNode(lookNode, "__add__").Add(n), x)
+	}
+	s, lit := t.Text[1:len(t.Text)-1], ""
+	for s != "" {
+		switch {
+		case strings.HasPrefix(s, "\\$"):
+			lit, s = lit + "$", s[2:]
+		case s[0] == '\\':
+			lit, s = lit + s[:2], s[2:]
+		case strings.HasPrefix(s, "${"):
+			if lit != "" || n == nil {
+				add(strNode(t, `"` + lit + `"`))
+			}
+			lit = ""
+			sub := NewScanner(strings.NewReader(s[2:]), t.File)
+			sub.SetLine(t.Line)
+			x := expr.Parse(sub, 0)
+			Expect("}", sub.Next())
+			add((&Node{Kind: callNode, Token: t}).Add(tNode(lookNode, "toString").Add(x)))
+			s = s[2+len(sub.Scanned()):]
+		default:
+			lit, s = lit + s[:1], s[1:]
+		}
+	}
+	if lit != "" || n == nil {
+		add(strNode(t, `"` + lit + `"`))
+	}
+	return n
+}
+
+func strNode(t Token, q string) *Node {
+	s, err := strconv.Unquote(q)
 	if err != nil {
-		panic(err)
+		panic(fmt.Errorf("%s(%d): %s", t.File, t.Line, err))
 	}
 	return &Node{Kind: valNode, Token: t, Data: Wrap(s)}
 }
 
 func parseInum(p *Parser, l *Lexer, t Token) *Node {
-	i, err := strconv.Atoi(t.Text)
-	if err != nil {
+	i, err := strconv.ParseInt(t.Text, 10, 64)
+	if err == nil {
+		return &Node{Kind: valNode, Token: t, Data: Wrap(i)}
+	}
+	x, ok := new(big.Int).SetString(t.Text, 10)
+	if !ok {
 		panic(err)
 	}
-	return &Node{Kind: valNode, Token: t, Data: Wrap(i)}
+	return &Node{Kind: valNode, Token: t, Data: wrapBig(x)}
 }
 
 func parseFnum(p *Parser, l *Lexer, t Token) *Node {
@@ -375,11 +504,14 @@ func parseDefv(l *Lexer, v SlotVis) *Node {
 	n := &Node{Kind: defNode}
 	loop: for {
 		c := &Node{Kind: varNode, Data: v}
-		nm := parseName(l)
-		checkKeyword(nm.Token)
+		nm := parsePattern(l)
 		c.Add(nm)
 		n.Add(c)
-		switch l.Lookahead().Text {
+		t := l.Lookahead()
+		if nm.Kind == patNode && t.Text != "=" {
+			panic(Expected("=", t))
+		}
+		switch t.Text {
 		case "=":
 			l.Next()
 			c.Add(expr.Parse(l, 0))
@@ -409,6 +541,85 @@ func parseDefv(l *Lexer, v SlotVis) *Node {
 	return n
 }
 
+// Parse a name, or a pattern for taking a value apart:
+//
+//	[a, b, rest*]    an array
+//	{a, b}           a hash, by key
+//	a : b            a pair
+func parsePattern(l *Lexer) *Node {
+	var n *Node
+	t := l.Lookahead()
+	switch t.Text {
+	case "[":
+		l.Next()
+		n = &Node{Kind: patNode, Token: t}
+		parseList(l, n, "]", func() *Node {
+			if k := len(n.Child); k != 0 && n.Child[k-1].Kind == restNode {
+				panic(Unexpected(l.Lookahead()))
+			}
+			c := parsePattern(l)
+			if l.Lookahead().Text == "*" {
+				c = (&Node{Kind: restNode, Token: l.Next()}).Add(c)
+			}
+			return c
+		})
+		Expect("]", l.Next())
+	case "{":
+		l.Next()
+		n = &Node{Kind: patNode, Token: t}
+		parseList(l, n, "}", func() *Node {
+			nm := parseName(l)
+			checkKeyword(nm.Token)
+			return nm
+		})
+		Expect("}", l.Next())
+	default:
+		n = parseName(l)
+		checkKeyword(n.Token)
+	}
+	if l.Lookahead().Text == ":" {
+		p := &Node{Kind: patNode, Token: l.Next()}
+		n = p.Add(n, parsePattern(l))
+	}
+	return n
+}
+
+// Turn the left hand side of an assignment into a pattern, if it is written as
+// an array, hash or pair.
+func exprPattern(n *Node) *Node {
+	if n.Kind != callNode {
+		return n
+	}
+	pat := &Node{Kind: patNode, Token: n.Token}
+	switch n.Token.Text {
+	case "[":
+		if len(n.Child) != 1 || n.Child[0].Kind != fnNode {
+			return n
+		}
+		// see arrParser
+		for _, x := range n.Child[0].Child[2].Child[1:] {
+			if x.Kind == restNode {
+				x.Child[0] = exprPattern(x.Child[0])
+				pat.Add(x)
+			} else {
+				pat.Add(exprPattern(x))
+			}
+		}
+	case "{":
+		for _, x := range n.Child[1:] {
+			if x.Kind != varNode {
+				panic(Unexpected(x.Token))
+			}
+			pat.Add(x)
+		}
+	case ":":
+		pat.Add(exprPattern(n.Child[1]), exprPattern(n.Child[2]))
+	default:
+		return n
+	}
+	return pat
+}
+
 func parseProp(l *Lexer) *Node {
 	var g, s *Node
 	loop: for {
@@ -454,35 +665,80 @@ func (q funcParser) Infix(p *Parser, l *Lexer, left *Node, t Token) *Node {
 	// calling
 	n := &Node{Kind: callNode, Token: t}
 	n.Add(left)
+	var kws []string
 	parseList(l, n, ")", func() *Node {
-		return p.Parse(l, 0)
+		t := l.Next()
+		if isKeywordArg(l, t) {
+			// a keyword argument
+			l.Next()
+			if lookup(t.Text, kws) != -1 {
+				panic(TokenError("keyword argument repeated: %s", t, t.Text))
+			}
+			kws = append(kws, t.Text)
+			return (&Node{Kind: kwNode, Token: t}).Add(p.Parse(l, 0))
+		}
+		if kws != nil {
+			panic(Unexpected(t))
+		}
+		return p.ParseWith(l, 0, t)
 	})
 	Expect(")", l.Next())
 	return n
 }
 
-type fdesc struct {
-	opt int
-	rest bool
+// Whether an argument starts with a keyword, as in f(x = 1).
+func isKeywordArg(l *Lexer, t Token) bool {
+	return t.Kind == id && lookup(t.Text, keywords) == -1 &&
+	       l.Lookahead().Text == "="
+}
+
+// Describes the arguments of a function. It is the Data of the group holding
+// them, which is the first child of a FnNode.
+type FnArgs struct {
+	Opt int // how many of the arguments, coming before any rest argument, are optional
+	Rest bool // whether the last argument collects the rest, as in fn(x*)
+	Kw bool // whether the last argument collects keyword arguments, as in fn(**k)
 }
 
 func parseFn(l *Lexer) *Node {
 	args := new(Node)
 	fn := kNode(fnNode).Add(args)
-	var desc fdesc
+	var desc FnArgs
+	var pats []*Node
 	inOpt := false
 	parseList(l, args, ")", func() *Node {
-		if args.Data == true {
+		if desc.Kw {
+			panic(fmt.Errorf("bad function syntax"))
+		}
+		if l.Lookahead().Text == "**" {
+			l.Next()
+			desc.Kw = true
+			return parseName(l)
+		}
+		if desc.Rest {
 			panic(fmt.Errorf("bad function syntax"))
 		}
-		n := parseName(l)
+		n := parsePattern(l)
+		if n.Kind == patNode {
+			// the argument is taken apart at the start of the body
+			arg := fmt.Sprintf("@arg%d", len(args.Child))
+			pats = append(pats, kNode(varNode).Add(n, tNode(varNode, arg)))
+			n = &Node{Token: n.Token}
+			n.Token.Text = arg
+		}
 		switch l.Lookahead().Text {
 		case "*":
 			l.Next()
-			desc.rest = true
+			desc.Rest = true
 		case "?":
 			l.Next()
-			desc.opt++
+			desc.Opt++
+			inOpt = true
+		case "=":
+			// the default value
+			l.Next()
+			n.Add(expr.Parse(l, 0))
+			desc.Opt++
 			inOpt = true
 		default:
 			if inOpt {
@@ -493,6 +749,9 @@ func parseFn(l *Lexer) *Node {
 	})
 	args.Data = desc
 	Expect(")", l.Next())
+	if pats != nil {
+		fn.Add(kNode(defNode).Add(pats...))
+	}
 	t := l.Lookahead()
 	if t.Text == "=" {
 		l.Next()
@@ -529,6 +788,16 @@ func (q arrParser) Precedence(t Token) int {
 }
 
 func (q arrParser) Prefix(p *Parser, l *Lexer, t Token) *Node {
+	items := new(Node)
+	parseList(l, items, "]", func() *Node {
+		return p.Parse(l, 0)
+	})
+	Expect("]", l.Next())
+	return arrayNode(t, items.Child)
+}
+
+// An array literal holding the values of some expressions.
+func arrayNode(t Token, items []*Node) *Node {
 	avar := tNode(varNode, "@tmp")
 	nn := &Node{Kind: callNode, Token: t}
 	def := kNode(defNode).Add(kNode(varNode).Add(
@@ -537,10 +806,7 @@ func (q arrParser) Prefix(p *Parser, l *Lexer, t Token) *Node {
 	))
 	add := &Node{Kind: callNode, Token: t}
 	add.Add(tNode(lookNode, "add").Add(avar))
-	parseList(l, add, "]", func() *Node {
-		return p.Parse(l, 0)
-	})
-	Expect("]", l.Next())
+	add.Add(items...)
 	n := &Node{Kind: callNode, Token: t}
 	return n.Add(kNode(fnNode).Add(new(Node),def,add,kNode(retNode).Add(avar)))
 }
@@ -583,16 +849,54 @@ func (q objParser) Infix(p *Parser, l *Lexer, left *Node, t Token) *Node {
 }
 
 // classes
+//
+// The children of a class node are its name, the global it defines, its
+// ancestor, the traits it mixes in and then its members. The ancestor may be
+// nil. The data is true for traits, which have no ancestor.
 func parseClass(l *Lexer, nm, gl *Node) *Node {
 	n := kNode(classNode)
 	n.Add(nm, gl)
 	Expect("(", l.Next())
-	if l.Lookahead().Text == ")" {
+	ts := new(Node)
+	parseList(l, ts, ")", func() *Node {
+		return expr.Parse(l, 0)
+	})
+	Expect(")", l.Next())
+	if len(ts.Child) == 0 {
 		n.Add(nil)
 	} else {
-		n.Add(expr.Parse(l, 0))
+		n.Add(ts.Child[0])
+		ts.Child = ts.Child[1:]
 	}
-	Expect(")", l.Next())
+	n.Add(ts)
+	parseMembers(l, n)
+	return n
+}
+
+// A trait, which may include other traits:
+//
+//	trait Ordered(Comparable)
+//		require compare;
+//		def __lt__(x) = this.compare(x) < 0;
+//	end
+func parseTrait(l *Lexer, nm, gl *Node) *Node {
+	n := kNode(classNode)
+	n.Data = true
+	n.Add(nm, gl, nil)
+	ts := new(Node)
+	if l.Lookahead().Text == "(" {
+		l.Next()
+		parseList(l, ts, ")", func() *Node {
+			return expr.Parse(l, 0)
+		})
+		Expect(")", l.Next())
+	}
+	n.Add(ts)
+	parseMembers(l, n)
+	return n
+}
+
+func parseMembers(l *Lexer, n *Node) {
 	v := Public
 	loop: for {
 		t := l.Next()
@@ -604,13 +908,31 @@ func parseClass(l *Lexer, nm, gl *Node) *Node {
 		case "def":
 			n.Add(parseDefv(l, v))
 			Expect(";", l.Next())
+		case "require":
+			if n.Data != true {
+				panic(Expected("def", t))
+			}
+			n.Add(parseRequire(l))
+			Expect(";", l.Next())
 		case "end":
 			break loop
 		default:
 			panic(Expected("def", t))
 		}
 	}
-	return n
+}
+
+// The methods a trait requires are given by name. They become abstract methods.
+func parseRequire(l *Lexer) *Node {
+	n := &Node{Kind: defNode}
+	for {
+		c := &Node{Kind: fnNode, Data: Public}
+		n.Add(c.Add(parseName(l), nil))
+		if l.Lookahead().Text != "," {
+			return n
+		}
+		l.Next()
+	}
 }
 
 func parseAnonClass(p *Parser, l *Lexer, t Token) *Node {
@@ -623,6 +945,16 @@ func parseInnerClass(p *Parser, l *Lexer, t Token) *Node {
 	return kNode(defNode).Add(c)
 }
 
+func parseInnerTrait(p *Parser, l *Lexer, t Token) *Node {
+	// trait is not reserved, so it may also name a variable
+	if l.Lookahead().Kind != id {
+		return parseStmt(p, l, t)
+	}
+	nm := parseName(l)
+	c := kNode(varNode).Add(nm, parseTrait(l, nm, nil))
+	return kNode(defNode).Add(c)
+}
+
 func parseReturn(p *Parser, l *Lexer, t Token) *Node {
 	n := &Node{Kind: retNode, Token: t}
 	if l.Lookahead().Text == ";" {
@@ -633,14 +965,32 @@ func parseReturn(p *Parser, l *Lexer, t Token) *Node {
 	return n
 }
 
+// The methods that compound assignments, such as x += 1, call.
+var updateOps = map[string] string {
+	"+=": "__add__",
+	"-=": "__sub__",
+	"*=": "__mul__",
+	"/=": "__div__",
+	"~/=": "__quot__",
+	"%=": "__mod__",
+	"**=": "__pow__",
+	"<<=": "__shl__",
+	">>=": "__shr__",
+	"&=": "__band__",
+	"^=": "__bxor__",
+	"|=": "__bor__",
+}
+
 func parseStmt(p *Parser, l *Lexer, t Token) *Node {
 	n := expr.ParseWith(l, 0, t)
 	if l.Lookahead().Text == "=" {
 		l.Next()
-		loc := n
+		loc := exprPattern(n)
 		n = &Node{Kind: mutNode, Token: t}
 		n.Add(loc)
 		n.Add(expr.Parse(l, 0))
+	} else if updateOps[l.Lookahead().Text] != "" {
+		n = (&Node{Kind: updNode, Token: l.Next()}).Add(n, expr.Parse(l, 0))
 	}
 	return n
 }
@@ -675,6 +1025,155 @@ func parseIf(p *Parser, l *Lexer, t Token) *Node {
 	return n
 }
 
+// loops
+func parseWhile(p *Parser, l *Lexer, t Token) *Node {
+	n := &Node{Kind: whileNode, Token: t}
+	cn := expr.Parse(l, 0)
+	Expect("do", l.Next())
+	bn := new(Node)
+	parseBlock(l, bn)
+	return n.Add(cn, bn)
+}
+
+func parseFor(p *Parser, l *Lexer, t Token) *Node {
+	// for is also a function in the prelude
+	if la := l.Lookahead(); la.Kind != id && la.Text != "[" && la.Text != "{" {
+		return parseStmt(p, l, t)
+	}
+	n := &Node{Kind: forNode, Token: t}
+	nm := parsePattern(l)
+	Expect("in", l.Next())
+	in := expr.Parse(l, 0)
+	Expect("do", l.Next())
+	bn := new(Node)
+	if nm.Kind == patNode {
+		// the item is taken apart at the start of each pass
+		bn.Add(kNode(defNode).Add(kNode(varNode).Add(nm, tNode(varNode, "@each"))))
+		nm = &Node{Token: nm.Token}
+		nm.Token.Text = "@each"
+	}
+	parseBlock(l, bn)
+	return n.Add(nm, in, bn)
+}
+
+func parseBreak(p *Parser, l *Lexer, t Token) *Node {
+	if t.Text == "continue" {
+		return &Node{Kind: contNode, Token: t}
+	}
+	return &Node{Kind: breakNode, Token: t}
+}
+
+// generators
+func parseYield(p *Parser, l *Lexer, t Token) *Node {
+	n := &Node{Kind: yieldNode, Token: t}
+	switch l.Lookahead().Text {
+	case ";", ",", ")", "]", "}":
+		return n.Add(vNode(Nil))
+	}
+	return n.Add(p.Parse(l, 0))
+}
+
+// error handling
+func parseTry(p *Parser, l *Lexer, t Token) *Node {
+	n := &Node{Kind: tryNode, Token: t}
+	bn, cs := new(Node), new(Node)
+	var fn *Node
+	cur := bn
+	for {
+		t = l.Next()
+		// catch and finally are also functions in the prelude
+		switch {
+		case t.Text == "end":
+			if len(cs.Child) == 0 && fn == nil {
+				panic(Expected("catch or finally", t))
+			}
+			return n.Add(bn, cs, fn)
+		case t.Text == "catch" && l.Lookahead().Kind == id:
+			if fn != nil {
+				panic(Unexpected(t))
+			}
+			c := &Node{Kind: catchNode, Token: t}
+			nm := parseName(l)
+			checkKeyword(nm.Token)
+			var cls *Node
+			if l.Lookahead().Text == ":" {
+				l.Next()
+				cls = expr.Parse(l, 0)
+			}
+			cur = new(Node)
+			cs.Add(c.Add(nm, cls, cur))
+			continue
+		case t.Text == "finally" && startsClause(l.Lookahead()):
+			if fn != nil {
+				panic(Unexpected(t))
+			}
+			fn = new(Node)
+			cur = fn
+			continue
+		}
+		cur.Add(stmt.ParseWith(l, 0, t))
+		Expect(";", l.Next())
+	}
+}
+
+func startsClause(t Token) bool {
+	switch t.Text {
+	case "(", ".", "[", "=", ";":
+		return false
+	}
+	return true
+}
+
+// pattern matching
+func parseMatch(p *Parser, l *Lexer, t Token) *Node {
+	// match is also a common name, so "match (x)" and "match [x]" are a
+	// call and an index until a case turns up
+	var v *Node
+	if startsClause(l.Lookahead()) {
+		v = expr.Parse(l, 0)
+	} else {
+		v = parseStmt(p, l, t)
+		if l.Lookahead().Text != "case" {
+			return v
+		}
+		switch {
+		case v.Kind == callNode && v.Token.Text == "(" && v.Child[0].Kind == varNode &&
+		     len(v.Child) == 2 && v.Child[1].Kind != kwNode:
+			v = v.Child[1]
+		case v.Kind == alookNode && v.Child[0].Kind == varNode:
+			v = arrayNode(v.Token, v.Child[1:])
+		default:
+			panic(Unexpected(l.Lookahead()))
+		}
+	}
+	n := &Node{Kind: matchNode, Token: t}
+	n.Add(v)
+	Expect("case", l.Next())
+	for {
+		c := &Node{Kind: caseNode, Token: t}
+		pat := expr.Parse(l, 0)
+		var guard *Node
+		if l.Lookahead().Text == "if" {
+			l.Next()
+			guard = expr.Parse(l, 0)
+		}
+		Expect("then", l.Next())
+		bn := new(Node)
+		n.Add(c.Add(pat, guard, bn))
+		for {
+			t = l.Next()
+			if t.Text == "end" {
+				return n
+			}
+			if t.Text == "case" {
+				break
+			}
+			bn.Add(stmt.ParseWith(l, 0, t))
+			Expect(";", l.Next())
+		}
+	}
+}
+
 // packages
 func parsePkg(l *Lexer) *Node {
 	n := new(Node)
@@ -708,7 +1207,7 @@ func transPkg(n *Node) *Node {
 	ds := kNode(defNode)
 	pc := kNode(classNode).Add(
 		tNode(varNode, nm), nil, 
-		tNode(varNode, "Package"), ds,
+		tNode(varNode, "Package"), new(Node), ds,
 	)
 	for _, x := range n.Child[1].Child {
 		// use properties to thread access
@@ -777,6 +1276,14 @@ func parseToplevel(l *Lexer) *Node {
 		l.Next()
 		nm := parseName(l)
 		n = parseClass(l, nm, nm)
+	case "trait":
+		l.Next()
+		if l.Lookahead().Kind != id {
+			n = parseStmt(stmt, l, t)
+			break
+		}
+		nm := parseName(l)
+		n = parseTrait(l, nm, nm)
 	case "package":
 		l.Next()
 		n = parsePkg(l)
@@ -822,16 +1329,28 @@ func initParsers() (expr, stmt *Parser) {
 	expr.RegPrefix(literal, "(", ParserFunc(parseGroup))
 	
 	expr.RegPrefix(op, "@", ParserFunc(parseAccExpr))
+	expr.RegPrefix(id, "yield", ParserFunc(parseYield))
 	
 	expr.RegPrefix(op, "!", prefixOp{60, "__inv__"})
 	expr.RegPrefix(op, "-", prefixOp{60, "__neg__"})
+	expr.RegPrefix(op, "~", prefixOp{60, "__bnot__"})
 
-	expr.RegInfix(op, "*", leftOp{60, "__mul__"})
+	expr.RegInfix(op, "**", rightOp{70, "__pow__"})
+
+	expr.RegInfix(op, "*", mulOp{leftOp{60, "__mul__"}})
 	expr.RegInfix(op, "/", leftOp{60, "__div__"})
+	expr.RegInfix(op, "~/", leftOp{60, "__quot__"})
+	expr.RegInfix(op, "%", leftOp{60, "__mod__"})
 
 	expr.RegInfix(op, "+", leftOp{50, "__add__"})
 	expr.RegInfix(op, "-", leftOp{50, "__sub__"})
 	
+	expr.RegInfix(op, "<<", leftOp{46, "__shl__"})
+	expr.RegInfix(op, ">>", leftOp{46, "__shr__"})
+	expr.RegInfix(op, "&", leftOp{45, "__band__"})
+	expr.RegInfix(op, "^", leftOp{44, "__bxor__"})
+	expr.RegInfix(op, "|", leftOp{43, "__bor__"})
+	
 	expr.RegInfix(op, "<", leftOp{40, "__lt__"})
 	expr.RegInfix(op, "<=", leftOp{40, "__lte__"})
 	expr.RegInfix(op, ">", leftOp{40, "__gt__"})
@@ -848,7 +1367,14 @@ func initParsers() (expr, stmt *Parser) {
 	
 	stmt.RegPrefix(id, "def", ParserFunc(parseDef))
 	stmt.RegPrefix(id, "class", ParserFunc(parseInnerClass))
+	stmt.RegPrefix(id, "trait", ParserFunc(parseInnerTrait))
 	stmt.RegPrefix(id, "if", ParserFunc(parseIf))
+	stmt.RegPrefix(id, "while", ParserFunc(parseWhile))
+	stmt.RegPrefix(id, "for", ParserFunc(parseFor))
+	stmt.RegPrefix(id, "break", ParserFunc(parseBreak))
+	stmt.RegPrefix(id, "continue", ParserFunc(parseBreak))
+	stmt.RegPrefix(id, "try", ParserFunc(parseTry))
+	stmt.RegPrefix(id, "match", ParserFunc(parseMatch))
 	stmt.RegPrefix(id, "return", ParserFunc(parseReturn))
 	stmt.RegPrefix(id, "import", ParserFunc(parseImport))
 	stmt.RegElse(ParserFunc(parseStmt))
@@ -1,13 +1,17 @@
 package ts
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"math/big"
 	"os"
 	"reflect"
 	"regexp"
 	"strings"
 	"strconv"
 	"sort"
+	"sync"
 	"unicode/utf8"
 	"unsafe"
 )
@@ -26,6 +30,7 @@ func init() {
 	initSimpleClasses()
 	initNumberClasses()
 	initCollectionClasses()
+	initGoClasses()
 	initCache()
 }
 
@@ -39,11 +44,23 @@ func initCache() {
 		intCache[i] = new(intObj).init(int64(i))
 	}
 	for i := 0; i < 128; i++ {
-		strCache[i] = new(strObj).init(string(i))
+		strCache[i] = new(strObj).init(string(rune(i)))
 	}
 	emptyStr = new(strObj).init("")
 }
 
+func isCached(x *Object) bool {
+	switch x.c {
+	case IntClass:
+		n := x.data.(int64)
+		return n >= 0 && n < 1024 && intCache[n] == x
+	case StringClass:
+		s := x.data.(string)
+		return s == "" && x == emptyStr || len(s) == 1 && s[0] < 128 && strCache[s[0]] == x
+	}
+	return false
+}
+
 func wrapInt(x int64) *Object {
 	if x >= 0 && x < 1024 {
 		return intCache[x]
@@ -51,8 +68,21 @@ func wrapInt(x int64) *Object {
 	return new(intObj).init(x)
 }
 
+// Unsigned values too large for an Integer become BigInts.
+func wrapUint(x uint64) *Object {
+	if x > math.MaxInt64 {
+		return wrapBig(new(big.Int).SetUint64(x))
+	}
+	return wrapInt(int64(x))
+}
+
 // Given a bool, number, string, slice or map return an object corresponding to 
-// that value. Well, it doesn't support all those types yet.
+// that value. A *big.Int becomes an integer and a *big.Rat a Rational, both
+// copied. Slices and arrays become arrays, maps become hashes. Structs, and
+// pointers to them, become instances of GoObject, which exposes exported fields
+// as properties and methods as methods, named as in a class made by BindClass().
+// Other values (channels, say) are also held in a GoObject. Use Unwrap() to
+// convert back.
 //
 // If a function is passed in: This function should take an object argument
 // representing the receiver, and then zero to four other object arguments
@@ -62,6 +92,20 @@ func wrapInt(x int64) *Object {
 // Ignore the receiver in the case of functions that are not methods; its value 
 // is undefined.
 //
+// A function may also take a map after the arguments, in which case it receives
+// any keyword arguments passed to it. Other functions raise an error when they
+// are passed keyword arguments.
+//
+// A function taking an array of arguments may also take a context before the
+// receiver. The context carries the control of the code calling the function:
+// pass it to CallContext(), ExecContext() and Go() when calling back into the
+// interpreter, and select on its Done() channel when blocking.
+//
+// Functions of any other type do not see the receiver. Their arguments are
+// converted with Unwrap() and their results with Wrap(). A function returning
+// several results returns them in an array, and if the last result is a non-nil
+// error then it is raised.
+//
 func Wrap(x interface{}) *Object {
 	if x == nil {
 		return Nil
@@ -97,11 +141,15 @@ func Wrap(x interface{}) *Object {
 	case int64:
 		return wrapInt(int64(v))
 	case uint64:
-		return wrapInt(int64(v))
+		return wrapUint(v)
 	case int:
 		return wrapInt(int64(v))
 	case uint:
-		return wrapInt(int64(v))
+		return wrapUint(uint64(v))
+	case *big.Int:
+		return wrapBig(new(big.Int).Set(v))
+	case *big.Rat:
+		return wrapRat(new(big.Rat).Set(v))
 	case float32:
 		return new(fltObj).init(float64(v))
 	case float64:
@@ -151,7 +199,35 @@ func Wrap(x interface{}) *Object {
 		}
 		return new(funcObj).init(func(p *process) {
 			p.b = len(p.s) - p.n
-			p.ret(v(p.t, p.args()))
+			p.noKeywords()
+			p.retGo(v(p.t, p.args()))
+		})
+	case func(*Object, []*Object, map[string] *Object) *Object:
+		if v == nil {
+			return Nil
+		}
+		return new(funcObj).init(func(p *process) {
+			p.b = len(p.s) - p.n
+			kw := p.keywords()
+			p.retGo(v(p.t, p.args(), kw))
+		})
+	case func(context.Context, *Object, []*Object) *Object:
+		if v == nil {
+			return Nil
+		}
+		return new(funcObj).init(func(p *process) {
+			p.b = len(p.s) - p.n
+			p.noKeywords()
+			p.retGo(v(p.ctx(), p.t, p.args()))
+		})
+	case func(context.Context, *Object, []*Object, map[string] *Object) *Object:
+		if v == nil {
+			return Nil
+		}
+		return new(funcObj).init(func(p *process) {
+			p.b = len(p.s) - p.n
+			kw := p.keywords()
+			p.retGo(v(p.ctx(), p.t, p.args(), kw))
 		})
 	case func(o *Object) *Object:
 		if v == nil {
@@ -160,7 +236,7 @@ func Wrap(x interface{}) *Object {
 		return new(funcObj).init(func(p *process) {
 			p.b = len(p.s) - p.n
 			p.parseArgs()
-			p.ret(v(p.t))
+			p.retGo(v(p.t))
 		})
 	case func(o, a *Object) *Object:
 		if v == nil {
@@ -170,7 +246,7 @@ func Wrap(x interface{}) *Object {
 			var a *Object
 			p.b = len(p.s) - p.n
 			p.parseArgs(&a)
-			p.ret(v(p.t, a))
+			p.retGo(v(p.t, a))
 		})
 	case func(o, a, b *Object) *Object:
 		if v == nil {
@@ -180,7 +256,7 @@ func Wrap(x interface{}) *Object {
 			var a, b *Object
 			p.b = len(p.s) - p.n
 			p.parseArgs(&a, &b)
-			p.ret(v(p.t, a, b))
+			p.retGo(v(p.t, a, b))
 		})
 	case func(o, a, b, c *Object) *Object:
 		if v == nil {
@@ -190,7 +266,7 @@ func Wrap(x interface{}) *Object {
 			var a, b, c *Object
 			p.b = len(p.s) - p.n
 			p.parseArgs(&a, &b, &c)
-			p.ret(v(p.t, a, b, c))
+			p.retGo(v(p.t, a, b, c))
 		})
 	case func(o, a, b, c, d *Object) *Object:
 		if v == nil {
@@ -200,21 +276,12 @@ func Wrap(x interface{}) *Object {
 			var a, b, c, d *Object
 			p.b = len(p.s) - p.n
 			p.parseArgs(&a, &b, &c, &d)
-			p.ret(v(p.t, a, b, c, d))
+			p.retGo(v(p.t, a, b, c, d))
 		})
 	case error:
 		return Wrap(v.Error())
 	}
-	v := reflect.ValueOf(x)
-	if v.Kind() == reflect.Array {
-		res := []*Object{}
-		l := v.Len()
-		for i := 0; i < l; i++ {
-			res = append(res, Wrap(v.Index(i).Interface()))
-		}
-		return Wrap(res)
-	}	
-	panic(fmt.Errorf("invalid type: %v", x))
+	return wrapValue(reflect.ValueOf(x))
 }
 
 // Public field slot.
@@ -238,11 +305,14 @@ func PropSlot(n string, g, s interface{}) Slot {
 	return Slot{Flags: Flags(Property, Public), Name: n, Value: gv, Set: sv}
 }
 
-// Slot describing a method that descendant classes ought to implement.
+// Slot describing a method that descendant classes ought to implement. In a
+// trait, a method that classes mixing in the trait must define.
 func AbstractMethod(n string) Slot {
-	return MSlot(n, func(o *Object, args []*Object) *Object {
+	s := MSlot(n, func(o *Object, args []*Object) *Object {
 		panic(fmt.Errorf("abstract method: %s.%s", o.c.n, n))
 	})
+	s.Flags |= abstractSlot
+	return s
 }
 
 // Retrieve int associated with the object. Panics if there is no such datum.
@@ -300,7 +370,7 @@ func RegisterExtension(n string, f func(*Interpreter) map[string] *Object) {
 func loadExtension(n string, itpr *Interpreter) *Object {
 	es := []Slot{}
 	f := extensions[n]
-	if f == nil {
+	if f == nil || !itpr.extensionAllowed(n) {
 		panic("undefined extension: " + n)
 	}
 	for k, v := range f(itpr) {
@@ -333,46 +403,59 @@ func root() string {
 type sorter struct {
 	intf *[4]*Accessor // size, __aget__, __aset__, __lt__
 	inner *Object
+	ctx context.Context // of the code doing the sorting
 }
 
 func (s sorter) Len() int {
-	return int(s.inner.Get((*s.intf)[0]).ToInt())
+	return int(s.inner.get(controlIn(s.ctx), (*s.intf)[0], nil).ToInt())
 }
 
 func (s sorter) Less(i, j int) bool {
-	atI := s.inner.Call((*s.intf)[1], Wrap(i))
-	atJ := s.inner.Call((*s.intf)[1], Wrap(j))
-	return atI.Call((*s.intf)[3], atJ) != False
+	atI := s.inner.CallContext(s.ctx, (*s.intf)[1], Wrap(i))
+	atJ := s.inner.CallContext(s.ctx, (*s.intf)[1], Wrap(j))
+	return atI.CallContext(s.ctx, (*s.intf)[3], atJ) != False
 }
 
 func (s sorter) Swap(i, j int) {
 	iv, jv := Wrap(i), Wrap(j)
-	atI := s.inner.Call((*s.intf)[1], iv)
-	atJ := s.inner.Call((*s.intf)[1], jv)
-	s.inner.Call((*s.intf)[2], iv, atJ)
-	s.inner.Call((*s.intf)[2], jv, atI)
+	atI := s.inner.CallContext(s.ctx, (*s.intf)[1], iv)
+	atJ := s.inner.CallContext(s.ctx, (*s.intf)[1], jv)
+	s.inner.CallContext(s.ctx, (*s.intf)[2], iv, atJ)
+	s.inner.CallContext(s.ctx, (*s.intf)[2], jv, atI)
 }
 
+// The first interpreter to load its primitives fills in the tables of the
+// built-in classes, which are never changed after that.
+var builtinsAdded sync.Once
+
 // registration function called by New()
 func (i *Interpreter) LoadPrimitives() {
 	
-	AccessorClass.n = ""
 	cs := []*Class {
 		ObjectClass, ClassClass, FunctionClass, AccessorClass,
 		BooleanClass, TrueClass, FalseClass, NilClass,
-		NumberClass, IntClass, FltClass, CollectionClass, SequenceClass,
-		IteratorClass, sequenceIteratorClass,
+		NumberClass, IntClass, BigIntClass, FltClass, RationalClass,
+		CollectionClass, SequenceClass,
+		IteratorClass, sequenceIteratorClass, GeneratorClass,
 		StringClass, ArrayClass, HashClass, BufferClass, PairClass,
-		ErrorClass,
+		ErrorClass, frameClass, GoObjectClass,
 	}
+	shared := true
+	builtinsAdded.Do(func() {
+		for _, x := range cs {
+			i.addClass(x)
+		}
+		shared = false
+	})
 	for _, x := range cs {
-		x.added = false
-		i.addClass(x)
-		if x.n != "" {
+		if shared {
+			i.shareClass(x)
+		}
+		// scripts see the Accessor class defined below
+		if x != AccessorClass {
 			i.Define(x.n, x.o)
 		}
 	}
-	AccessorClass.n = "Accessor"
 	
 	var accClass *Class
 	accClass = AccessorClass.Extend(i, "Accessor", Final, []Slot {
@@ -388,34 +471,48 @@ func (i *Interpreter) LoadPrimitives() {
 	})
 	i.Define("Accessor", accClass.o)
 	
-	i.Define("currentSourceFile", new(funcObj).init(func(p *process) {
+	i.defineBuiltin("currentSourceFile", new(funcObj).init(func(p *process) {
 		p.b = len(p.s) - p.n
 		p.ret(Wrap(p.u.file))
 	}))
 	
-	i.Define("currentLoadFile", new(funcObj).init(func(p *process) {
+	i.defineBuiltin("currentLoadFile", new(funcObj).init(func(p *process) {
 		p.b = len(p.s) - p.n
 		p.ret(Wrap(p.u.path))
 	}))
 	
-	i.Define("load", Wrap(func(o, p *Object) *Object {
-		i.Load(p.ToString())
+	i.defineBuiltin("load", Wrap(func(ctx context.Context, o *Object, args []*Object) *Object {
+		if len(args) != 1 {
+			panic(ArgError(len(args)))
+		}
+		path := args[0].ToString()
+		f, err := i.OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		i.loadFile(controlIn(ctx), f, path)
 		return Nil
 	}))
 	
-	i.Define("eval", Wrap(func(o, expr *Object) *Object {
-		return i.Eval(expr.ToString())
+	i.defineBuiltin("eval", Wrap(func(ctx context.Context, o *Object, args []*Object) *Object {
+		if len(args) != 1 {
+			panic(ArgError(len(args)))
+		}
+		u := new(Unit)
+		u.CompileStr(args[0].ToString())
+		return i.execUnder(u, controlIn(ctx))
 	}))
 
-	i.Define("read", Wrap(func(o *Object) *Object {
+	i.defineBuiltin("read", Wrap(func(o *Object) *Object {
 		return Wrap(readString(os.Stdin, '\n'))
 	}))
 	
-	i.Define("names", Wrap(func(o *Object) *Object {
+	i.defineBuiltin("names", Wrap(func(o *Object) *Object {
 		return Wrap(i.ListDefined())
 	}))
 	
-	i.Define("print", Wrap(func(o *Object, args []*Object) *Object {
+	i.defineBuiltin("print", Wrap(func(o *Object, args []*Object) *Object {
 		as := make([]interface{}, len(args))
 		for i, x := range args {
 			as[i] = x
@@ -424,7 +521,7 @@ func (i *Interpreter) LoadPrimitives() {
 		return Nil
 	}))
 	
-	i.Define("exit", Wrap(func(o *Object, args []*Object) *Object {
+	i.defineBuiltin("exit", Wrap(func(o *Object, args []*Object) *Object {
 		code := 0
 		switch len(args) {
 		case 1:
@@ -433,30 +530,36 @@ func (i *Interpreter) LoadPrimitives() {
 		default:
 			 panic(ArgError(len(args)))
 		}
+		if i.sandbox != nil {
+			panic(&ExitError{code})
+		}
 		os.Exit(code)
 		return Nil
 	}))
 	
-	i.Define("throw", Wrap(func(o, x *Object) *Object {
+	i.defineBuiltin("throw", Wrap(func(o, x *Object) *Object {
 		panic(x)
 	}))
 	
-	i.Define("catch", new(funcObj).init(func(p *process) {
+	i.defineBuiltin("catch", new(funcObj).init(func(p *process) {
 		defer func() {
 			if e := recover(); e != nil {
+				if isHostError(e) {
+					panic(e)
+				}
 				p.ret(p.wrapError(e))
 			}
 		}()/**/
 		var thk *Object
 		p.b = len(p.s) - p.n
 		p.parseArgs(&thk)
-		thk.Call(nil)
+		thk.callFrom(p.nested(), thk, nil, nil)
 		p.ret(False)
 	}))
 	
-	i.Define("done", Done)
+	i.defineBuiltin("done", Done)
 	
-	i.Define("loadExtension", Wrap(func(o, n *Object) *Object {
+	i.defineBuiltin("loadExtension", Wrap(func(o, n *Object) *Object {
 		return loadExtension(n.ToString(), i)
 	}))
 	
@@ -467,8 +570,11 @@ func (i *Interpreter) LoadPrimitives() {
 		i.Accessor("__lt__"),
 	}
 	
-	i.Define("sort", Wrap(func(o, a *Object) *Object {
-		sort.Sort(sorter{&sortIntf, a})
+	i.defineBuiltin("sort", Wrap(func(ctx context.Context, o *Object, args []*Object) *Object {
+		if len(args) != 1 {
+			panic(ArgError(len(args)))
+		}
+		sort.Sort(sorter{&sortIntf, args[0], ctx})
 		return Nil
 	}))
 }
@@ -566,21 +672,57 @@ type pairKey struct {
 	next interface{}
 }
 
+// Hashes may be shared between goroutines, so each access takes a lock.
+type hashTable struct {
+	mu sync.RWMutex
+	m map[hashKey] hashItem
+}
+
+func (h *hashTable) get(k hashKey) (hashItem, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	x, ok := h.m[k]
+	return x, ok
+}
+
+func (h *hashTable) set(k hashKey, x hashItem) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.m[k] = x
+}
+
+func (h *hashTable) size() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.m)
+}
+
+// A copy of the contents, in no particular order.
+func (h *hashTable) items() []hashItem {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	res := make([]hashItem, 0, len(h.m))
+	for _, x := range h.m {
+		res = append(res, x)
+	}
+	return res
+}
+
 type hashObj struct {}
 
 func (dd *hashObj) init(m map[hashKey] hashItem) *Object {
 	o := new(Object)
 	o.c = HashClass
-	o.data = m
-	if o.data == nil {
-		o.data = make(map[hashKey] hashItem)
+	if m == nil {
+		m = make(map[hashKey] hashItem)
 	}
+	o.data = &hashTable{m: m}
 	return o
 }
 
-func (o *Object) hashData() map[hashKey] hashItem {
+func (o *Object) hashData() *hashTable {
 	o.checkClass(o.c == HashClass)
-	return o.data.(map[hashKey] hashItem)
+	return o.data.(*hashTable)
 }
 
 func keyData(o *Object) hashKey {
@@ -592,6 +734,8 @@ func keyData(o *Object) hashKey {
 		x = o.ToString()
 	case IntClass:
 		x = o.ToInt()
+	case BigIntClass, RationalClass:
+		x = fmt.Sprint(o.data)
 	case FltClass:
 		x = o.ToFloat()
 	case PairClass:
@@ -721,14 +865,16 @@ func initBaseClasses() {
 		MSlot("copy", func(o *Object) *Object {
 			return o;
 		}),
-		MSlot("__call__", func(o *Object, args []*Object) *Object {
-			return o.Call(nil, args...)
+		MSlot("__call__", func(ctx context.Context, o *Object, args []*Object, kw map[string] *Object) *Object {
+			return o.callFrom(controlIn(ctx), o, args, kw)
 		}),
 	}
 	
 	ObjectClass.e = []Slot {
-		MSlot("__new__", func(o *Object, args []*Object) *Object {
-			ObjectClass.Call(o, _Object_create, args...)
+		MSlot("__new__", func(ctx context.Context, o *Object, args []*Object, kw map[string] *Object) *Object {
+			c := controlIn(ctx)
+			create := o.getMethod(c, nil, &ObjectClass.e[_Object_create])
+			o.callFrom(c, create, args, kw)
 			return o
 		}),
 		MSlot("create", func(o *Object) *Object {
@@ -756,8 +902,11 @@ func initBaseClasses() {
 		MSlot("toString", func(o *Object) *Object {
 			return Wrap(fmt.Sprintf("#<%s>", o.c.n))
 		}),
-		MSlot("equals", func(o, x *Object) *Object {
-			return ObjectClass.Call(o, _Object_eq, x)
+		MSlot("equals", func(ctx context.Context, o *Object, args []*Object) *Object {
+			if len(args) != 1 {
+				panic(ArgError(len(args)))
+			}
+			return o.callFrom(controlIn(ctx), o.c.m[_Object_eq], args, nil)
 		}),
 		MSlot("__key__", func(o *Object) *Object {
 			return o
@@ -767,15 +916,29 @@ func initBaseClasses() {
 			copy(f, o.f)
 			return &Object{o.c, f, nil}
 		}),
-		MSlot("apply", func(o, args *Object) *Object {
-			return o.Call(nil, args.ToArray()...)
+		MSlot("apply", func(ctx context.Context, o *Object, args []*Object) *Object {
+			var kw map[string] *Object
+			switch len(args) {
+			case 1:
+			case 2:
+				kw = map[string] *Object{}
+				for _, item := range args[1].hashData().items() {
+					kw[item.key.ToString()] = item.val
+				}
+			default:
+				 panic(ArgError(len(args)))
+			}
+			return o.callFrom(controlIn(ctx), o, args[0].ToArray(), kw)
 		}),
 		MSlot("is", func(o, d *Object) *Object {
 			c := o.Class()
 			return Wrap(c.Is(d.ToClass()))
 		}),
-		MSlot("__neq__", func(o, x *Object) *Object {
-			return Wrap(ObjectClass.Call(o, _Object_eq, x) == False)
+		MSlot("__neq__", func(ctx context.Context, o *Object, args []*Object) *Object {
+			if len(args) != 1 {
+				panic(ArgError(len(args)))
+			}
+			return Wrap(o.callFrom(controlIn(ctx), o.c.m[_Object_eq], args, nil) == False)
 		}),
 		MSlot("__inv__", func(o *Object) *Object {
 			return False
@@ -798,9 +961,15 @@ func initBaseClasses() {
 
 	ClassClass.e = []Slot {
 		FSlot("help", False),
-		MSlot("__call__", func(o *Object, args []*Object) *Object {
-			return o.ToClass().New(args...)
-		}),
+		// a primitive of its own, so that create() runs under the control
+		// of the code making the object
+		MSlot("__call__", new(funcObj).init(func(p *process) {
+			p.b = len(p.s) - p.n
+			kw := p.keywords()
+			c := p.t.ToClass()
+			p.allocatedOne()
+			p.ret(c.alloc().callFrom(p.nested(), c.m[_Object_new], p.args(), kw))
+		})),
 		MSlot("inheritsFrom", func(o, c *Object) *Object {
 			return Wrap(o.ToClass().Is(c.ToClass()))
 		}),
@@ -811,7 +980,10 @@ func initBaseClasses() {
 			return Wrap(o.ToClass().n)
 		}, Nil),
 		PropSlot("ancestor", func(o *Object) *Object {
-			return o.ToClass().a.o
+			if a := o.ToClass().Ancestor(); a != nil {
+				return a.o
+			}
+			return Nil
 		}, Nil),
 		MSlot("instanceSlots", func(o *Object, flags []*Object) *Object {
 			c := o.ToClass()
@@ -867,17 +1039,23 @@ func initBaseClasses() {
 			}
 			return Wrap(e.Flags.Kind() == Method)
 		}),
-		MSlot("get", func(a, o *Object) *Object {
-			return o.Get(a.accessorData())
+		MSlot("get", func(ctx context.Context, a *Object, args []*Object) *Object {
+			if len(args) != 1 {
+				panic(ArgError(len(args)))
+			}
+			return args[0].get(controlIn(ctx), a.accessorData(), nil)
 		}),
-		MSlot("set", func(a, o, x *Object) *Object {
-			o.Set(a.accessorData(), x)
+		MSlot("set", func(ctx context.Context, a *Object, args []*Object) *Object {
+			if len(args) != 2 {
+				panic(ArgError(len(args)))
+			}
+			args[0].set(controlIn(ctx), a.accessorData(), nil, args[1])
 			return Nil
 		}),
-		MSlot("call", func(a *Object, args []*Object) *Object {
+		MSlot("call", func(ctx context.Context, a *Object, args []*Object) *Object {
 			o := args[0]
 			args = args[1:]
-			return o.Call(a.accessorData(), args...)
+			return o.CallContext(ctx, a.accessorData(), args...)
 		}),
 		MSlot("is", func(o, c *Object) *Object {
 			oc := o.Class()
@@ -891,7 +1069,7 @@ func initBaseClasses() {
 			a := o.accessorData()
 			fmt.Println(a.n)
 			fmt.Println("-----")
-			for _, e := range a.e {
+			for _, e := range a.entries() {
 				nm := e.Name
 				if e.Flags.Kind() == Method {
 					nm += "()"
@@ -988,58 +1166,6 @@ func initSimpleClasses() {
 	FalseClass.flags = Final|Abstract
 }
 
-func numG(fi func(a, b int64) *Object,
-          ff func(a, b float64) *Object,
-          fe func(a, b *Object) *Object) func(o, b *Object) *Object {
-	return func(o, bv *Object) *Object {
-		av := o
-		if av.c == IntClass && bv.c == IntClass {
-			a := av.ToInt()
-			b := bv.ToInt()
-			return fi(a, b)
-		}
-		var a, b float64
-		if av.c == IntClass {
-			a = float64(av.ToInt())
-		} else if fe == nil || av.c == FltClass {
-			a = av.ToFloat()
-		} else {
-			return fe(av, bv)
-		}
-		if bv.c == IntClass {
-			b = float64(bv.ToInt())
-		} else if fe == nil || bv.c == FltClass {
-			b = bv.ToFloat()
-		} else {
-			return fe(av, bv)
-		}
-		return ff(a, b)
-	}
-}
-
-func numOp(fi func(a,b int64) int64,
-           ff func(a,b float64) float64) func(o, b *Object) *Object {
-	return numG(func(a,b int64) *Object {
-		if fi == nil {
-			return Wrap(ff(float64(a), float64(b)))
-		}
-		return Wrap(fi(a, b))
-	}, func(a,b float64) *Object {
-		return Wrap(ff(a, b))
-	}, nil)
-}
-
-func numPred(fi func(a,b int64) bool,
-             ff func(a,b float64) bool) func(o, b *Object) *Object {
-	return numG(func(a,b int64) *Object {
-		return Wrap(fi(a, b))
-	}, func(a,b float64) *Object {
-		return Wrap(ff(a, b))
-	}, func(a,b *Object) *Object {
-		return False
-	})
-}
-
 func initNumberClasses() {
 	NumberClass = ObjectClass.extend("Number", 0, []Slot {
 		AbstractMethod("toInt"),
@@ -1047,52 +1173,21 @@ func initNumberClasses() {
 		MSlot("copy", func(o *Object) *Object {
 			return o;
 		}),
-		MSlot("__add__", numOp(func(a, b int64) int64 {
-			return a + b
-		}, func(a, b float64) float64 {
-			return a + b
-		})),
-		MSlot("__sub__", numOp(func(a, b int64) int64 {
-			return a - b
-		}, func(a, b float64) float64 {
-			return a - b
-		})),
-		MSlot("__mul__", numOp(func(a, b int64) int64 {
-			return a * b
-		}, func(a, b float64) float64 {
-			return a * b
-		})),
-		MSlot("__div__", numOp(nil, func(a, b float64) float64 {
-			return a / b
-		})),
-		MSlot("__eq__", numPred(func(a, b int64) bool {
-			return a == b
-		}, func(a, b float64) bool {
-			return a == b
-		})),
-		MSlot("__lt__", numPred(func(a, b int64) bool {
-			return a < b
-		}, func(a, b float64) bool {
-			return a < b
-		})),
-		MSlot("__lte__", numPred(func(a, b int64) bool {
-			return a <= b
-		}, func(a, b float64) bool {
-			return a <= b
-		})),
-		MSlot("__gt__", numPred(func(a, b int64) bool {
-			return a > b
-		}, func(a, b float64) bool {
-			return a > b
-		})),
-		MSlot("__gte__", numPred(func(a, b int64) bool {
-			return a >= b
-		}, func(a, b float64) bool {
-			return a >= b
-		})),
+		MSlot("__add__", numAdd.call),
+		MSlot("__sub__", numSub.call),
+		MSlot("__mul__", numMul.call),
+		MSlot("__div__", numDiv.call),
+		MSlot("__quot__", numQuot.call),
+		MSlot("__mod__", numMod.call),
+		MSlot("__pow__", numPow.call),
+		MSlot("__eq__", numEq),
+		MSlot("__lt__", numLt),
+		MSlot("__lte__", numLte),
+		MSlot("__gt__", numGt),
+		MSlot("__gte__", numGte),
 	})
 
-	IntClass = NumberClass.extend("Integer", Final|Abstract, []Slot {
+	IntClass = NumberClass.extend("Integer", Abstract, []Slot {
 		MSlot("toString", func(o *Object) *Object {
 			return Wrap(fmt.Sprint(o.ToInt()))
 		}),
@@ -1105,35 +1200,85 @@ func initNumberClasses() {
 		MSlot("toFloat", func(o *Object) *Object {
 			return Wrap(float64(o.ToInt()))
 		}),
-		MSlot("__neg__", func(o *Object) *Object {
-			return Wrap(-o.ToInt())
-		}),
-		MSlot("quotient", func(o, x *Object) *Object {
-			return Wrap(o.ToInt() / x.ToInt())
+		MSlot("__neg__", intNeg),
+		MSlot("quotient", numQuot.call),
+		MSlot("modulo", numMod.call),
+		MSlot("__band__", numAnd.call),
+		MSlot("__bor__", numOr.call),
+		MSlot("__bxor__", numXor.call),
+		MSlot("__bnot__", intNot),
+		MSlot("__shl__", intShl),
+		MSlot("__shr__", intShr),
+	})
+
+	BigIntClass = IntClass.extend("BigInt", Final|Abstract, []Slot {
+		MSlot("toString", func(o *Object) *Object {
+			return Wrap(toBig(o).String())
 		}),
-		MSlot("modulo", func(o, x *Object) *Object {
-			return Wrap(o.ToInt() % x.ToInt())
+		MSlot("toFloat", func(o *Object) *Object {
+			return Wrap(toFloat(o))
 		}),
+		MSlot("__neg__", intNeg),
+		MSlot("__bnot__", intNot),
 	})
-	
+	IntClass.flags = Final|Abstract
+
 	FltClass = NumberClass.extend("Float", Final|Abstract, []Slot {
 		MSlot("toString", func(o *Object) *Object {
 			return Wrap(fmt.Sprint(o.ToFloat()))
 		}),
 		MSlot("toInt", func(o *Object) *Object {
-			return Wrap(int(o.ToFloat()))
+			return floatInt(o.ToFloat())
 		}),
 		MSlot("toFloat", func(o *Object) *Object {
 			return o
 		}),
+		MSlot("__neg__", fltNeg),
+	})
+
+	RationalClass = NumberClass.extend("Rational", Final, []Slot {
+		MSlot("__new__", func(o *Object, args []*Object) *Object {
+			return newRational(args)
+		}),
+		PropSlot("numerator", func(o *Object) *Object {
+			return wrapBig(new(big.Int).Set(toRat(o).Num()))
+		}, Nil),
+		PropSlot("denominator", func(o *Object) *Object {
+			return wrapBig(new(big.Int).Set(toRat(o).Denom()))
+		}, Nil),
+		MSlot("toString", func(o *Object) *Object {
+			return Wrap(toRat(o).RatString())
+		}),
+		MSlot("toInt", func(o *Object) *Object {
+			r := toRat(o)
+			return wrapBig(new(big.Int).Quo(r.Num(), r.Denom()))
+		}),
+		MSlot("toFloat", func(o *Object) *Object {
+			return Wrap(toFloat(o))
+		}),
 		MSlot("__neg__", func(o *Object) *Object {
-			return Wrap(-o.ToFloat())
-		}),	
+			return wrapRat(new(big.Rat).Neg(toRat(o)))
+		}),
 	})
-	
+
 	NumberClass.flags = Final|Abstract
 }
 
+// Combine two buffers of the same size byte by byte.
+func bufOp(f func(a, b byte) byte) func(o, x *Object) *Object {
+	return func(o, x *Object) *Object {
+		bufa, bufb := o.ToBuffer(), x.ToBuffer()
+		if len(bufa) != len(bufb) {
+			panic(fmt.Errorf("buffer sizes differ: %d, %d", len(bufa), len(bufb)))
+		}
+		res := make([]byte, len(bufa))
+		for i := range res {
+			res[i] = f(bufa[i], bufb[i])
+		}
+		return Wrap(res)
+	}
+}
+
 func setOp(a, b []*Object, op int) (ina, inb, inboth []*Object) {
 	loop: for _, x := range a {
 		for i := 0; i < len(b); i++ {
@@ -1155,6 +1300,7 @@ func initCollectionClasses() {
 		FSlot("file", Nil),
 		FSlot("line", Nil),
 		FSlot("trace", Nil),
+		FSlot("column", Nil),
 		MSlot("toString", func(o *Object) *Object {
 			msg := ErrorClass.Get(o, 0)
 			file := ErrorClass.Get(o, 1)
@@ -1169,10 +1315,22 @@ func initCollectionClasses() {
 			ErrorClass.Set(o, 1, Wrap(""))
 			ErrorClass.Set(o, 2, Wrap(0))
 			ErrorClass.Set(o, 3, Wrap([]*Object{}))
+			ErrorClass.Set(o, 4, Wrap(0))
 			return Nil
 		}),
 	})
 	
+	frameClass = ObjectClass.extend("StackFrame", Final, []Slot {
+		FSlot("name", Nil),
+		FSlot("class", Nil),
+		FSlot("file", Nil),
+		FSlot("line", Nil),
+		FSlot("column", Nil),
+		MSlot("toString", func(o *Object) *Object {
+			return Wrap(stackFrame(o).String())
+		}),
+	})
+	
 	CollectionClass = ObjectClass.extend("Collection", Abstract, []Slot {
 		AbstractMethod("__aget__"),
 		AbstractMethod("__aset__"),
@@ -1212,6 +1370,16 @@ func initCollectionClasses() {
 		}),
 	})
 	
+	GeneratorClass = IteratorClass.extend("Generator", Final|Abstract, []Slot {
+		MSlot("next", new(funcObj).init(func(p *process) {
+			p.b = len(p.s) - p.n
+			p.parseArgs()
+			o := p.t
+			o.checkClass(o.c == GeneratorClass)
+			p.ret(o.data.(*generator).next(p))
+		})),
+	})
+	
 	ArrayClass = SequenceClass.extend("Array", Final, []Slot {
 		MSlot("copy", func(o *Object) *Object {
 			a := o.ToArray()
@@ -1361,7 +1529,7 @@ func initCollectionClasses() {
 	HashClass = CollectionClass.extend("Hash", Final, []Slot {
 		MSlot("keys", func(o *Object) *Object {
 			res := []*Object{}
-			for _, v := range o.hashData() {
+			for _, v := range o.hashData().items() {
 				res = append(res, Wrap(v.key))
 			}
 			return Wrap(res)
@@ -1383,7 +1551,7 @@ func initCollectionClasses() {
 		MSlot("toString", func(o *Object) *Object {
 			res := "{"
 			start := true
-			for _, v := range o.hashData() {
+			for _, v := range o.hashData().items() {
 				if !start {
 					res += ", "
 				}
@@ -1394,21 +1562,21 @@ func initCollectionClasses() {
 			return Wrap(res)
 		}),
 		MSlot("__aget__", func(o, k *Object) *Object {
-			res, ok := o.hashData()[keyData(k)]
+			res, ok := o.hashData().get(keyData(k))
 			if !ok {
 				panic(fmt.Errorf("missing value: %s", k))
 			}
 			return res.val
 		}),
 		MSlot("__aset__", func(o, k, v *Object) *Object {
-			o.hashData()[keyData(k)] = hashItem{k, v}
+			o.hashData().set(keyData(k), hashItem{k, v})
 			return Nil
 		}),
 		PropSlot("size", func(o *Object) *Object {
-			return Wrap(len(o.hashData()))
+			return Wrap(o.hashData().size())
 		}, Nil),
 		MSlot("contains", func(o, k *Object) *Object {
-			_, ok := o.hashData()[keyData(k)]
+			_, ok := o.hashData().get(keyData(k))
 			return Wrap(ok)
 		}),
 	})
@@ -1458,6 +1626,23 @@ func initCollectionClasses() {
 			copy(res[len(bufa):], bufb)
 			return Wrap(res)
 		}),
+		MSlot("__band__", bufOp(func(a, b byte) byte {
+			return a & b
+		})),
+		MSlot("__bor__", bufOp(func(a, b byte) byte {
+			return a | b
+		})),
+		MSlot("__bxor__", bufOp(func(a, b byte) byte {
+			return a ^ b
+		})),
+		MSlot("__bnot__", func(o *Object) *Object {
+			buf := o.ToBuffer()
+			res := make([]byte, len(buf))
+			for i, x := range buf {
+				res[i] = ^x
+			}
+			return Wrap(res)
+		}),
 	})
 
 	StringClass = SequenceClass.extend("String", Final|Abstract, []Slot {
@@ -0,0 +1,619 @@
+package ts
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+)
+
+/*******************************************************************************
+
+	Go values
+
+*******************************************************************************/
+
+var (
+	objectType = reflect.TypeOf((*Object)(nil))
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	bytesType = reflect.TypeOf([]byte(nil))
+	anyType = reflect.TypeOf((*interface{})(nil)).Elem()
+	bigIntType = reflect.TypeOf((*big.Int)(nil))
+	bigRatType = reflect.TypeOf((*big.Rat)(nil))
+)
+
+// Returned by Unwrap() when an object cannot be converted into a Go value.
+type ConversionError struct {
+	Object *Object
+	Type reflect.Type
+	Path string // where the object was found in the value being converted
+	Reason string
+}
+
+func (e *ConversionError) Error() string {
+	res := fmt.Sprintf("cannot convert %s to %s", className(e.Object), e.Type)
+	if e.Reason != "" {
+		res += ": " + e.Reason
+	}
+	if e.Path != "" {
+		res += " (at " + e.Path + ")"
+	}
+	return res
+}
+
+// The name of the first named class in the object's ancestry.
+func className(o *Object) string {
+	c := o.c
+	for c.n == "" && c.a != nil {
+		c = c.a
+	}
+	return c.n
+}
+
+func newGoObject(x interface{}) *Object {
+	return &Object{c: GoObjectClass, data: x}
+}
+
+// The value held by a GoObject and, if that is a pointer, what it points to.
+func (o *Object) goData() (v, elem reflect.Value) {
+	o.checkClass(o.c == GoObjectClass)
+	v = reflect.ValueOf(o.data)
+	elem = v
+	if v.Kind() == reflect.Ptr {
+		elem = v.Elem()
+	}
+	return
+}
+
+// The fields of a Go struct type that scripts may use, by the names they know
+// them by. These are the public fields of a class bound to the type.
+func goFields(t reflect.Type) map[string] reflect.StructField {
+	res := map[string] reflect.StructField{}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		if n, vis, ok := bindName(f); ok && vis == Public {
+			res[n] = f
+		}
+	}
+	return res
+}
+
+// Look up a field on a Go struct.
+func goField(v reflect.Value, n string) (reflect.Value, bool) {
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	f, ok := goFields(v.Type())[n]
+	if !ok {
+		return reflect.Value{}, false
+	}
+	res, err := v.FieldByIndexErr(f.Index)
+	return res, err == nil
+}
+
+// Look up a method on a Go value, by its name as a method of a bound class.
+func goMethod(v reflect.Value, n string) reflect.Value {
+	for i := 0; i < v.NumMethod(); i++ {
+		if scriptName(v.Type().Method(i).Name) == n {
+			return v.Method(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// Wrap the value of a struct field. Structs within structs are shared rather
+// than copied, so that their fields may be set.
+func wrapField(f reflect.Value) *Object {
+	if f.Kind() == reflect.Struct && f.CanAddr() {
+		return newGoObject(f.Addr().Interface())
+	}
+	return Wrap(f.Interface())
+}
+
+// Set a struct field, leaving it alone if the object cannot be converted.
+func setField(f reflect.Value, x *Object, path string) {
+	y := reflect.New(f.Type()).Elem()
+	if err := unwrapValue(x, y, path); err != nil {
+		panic(err)
+	}
+	f.Set(y)
+}
+
+// Wrap a value of a type that Wrap() has no special case for.
+func wrapValue(v reflect.Value) *Object {
+	switch v.Kind() {
+	case reflect.Bool:
+		return Wrap(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return wrapInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return wrapUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return Wrap(v.Float())
+	case reflect.String:
+		return Wrap(v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return Wrap(v.Bytes())
+		}
+		fallthrough
+	case reflect.Array:
+		res := make([]*Object, v.Len())
+		for i := range res {
+			res[i] = Wrap(v.Index(i).Interface())
+		}
+		return Wrap(res)
+	case reflect.Map:
+		if v.IsNil() {
+			return Nil
+		}
+		res := make(map[hashKey] hashItem)
+		for it := v.MapRange(); it.Next(); {
+			k, x := Wrap(it.Key().Interface()), Wrap(it.Value().Interface())
+			res[keyData(k)] = hashItem{k, x}
+		}
+		return new(hashObj).init(res)
+	case reflect.Func:
+		if v.IsNil() {
+			return Nil
+		}
+		return wrapFunc(v)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return Nil
+		}
+		if v.Elem().Kind() != reflect.Struct && v.NumMethod() == 0 {
+			return Wrap(v.Elem().Interface())
+		}
+	case reflect.Struct:
+		// copy the struct so that its fields may be set
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return newGoObject(p.Interface())
+	}
+	return newGoObject(v.Interface())
+}
+
+// Wrap a Go function of any type. Its arguments are converted with Unwrap()
+// and its results with Wrap(). Where it has several results they are returned
+// in an array. If its last result is an error then that is raised rather than
+// returned.
+func wrapFunc(f reflect.Value) *Object {
+	return new(funcObj).init(func(p *process) {
+		p.b = len(p.s) - p.n
+		p.noKeywords()
+		p.retGo(callFunc(f, p.args()))
+	})
+}
+
+func callFunc(f reflect.Value, args []*Object) *Object {
+	t := f.Type()
+	n := t.NumIn()
+	if t.IsVariadic() && len(args) < n-1 || !t.IsVariadic() && len(args) != n {
+		panic(ArgError(len(args)))
+	}
+	in := make([]reflect.Value, len(args))
+	for i, x := range args {
+		var at reflect.Type
+		if t.IsVariadic() && i >= n-1 {
+			at = t.In(n-1).Elem()
+		} else {
+			at = t.In(i)
+		}
+		in[i] = reflect.New(at).Elem()
+		path := fmt.Sprintf("argument %d", i+1)
+		if err := unwrapValue(x, in[i], path); err != nil {
+			panic(err)
+		}
+	}
+	out := f.Call(in)
+	if n := len(out); n != 0 && t.Out(n-1) == errorType {
+		if err := out[n-1]; !err.IsNil() {
+			panic(err.Interface())
+		}
+		out = out[:n-1]
+	}
+	switch len(out) {
+	case 0:
+		return Nil
+	case 1:
+		return Wrap(out[0].Interface())
+	}
+	res := make([]*Object, len(out))
+	for i, x := range out {
+		res[i] = Wrap(x.Interface())
+	}
+	return Wrap(res)
+}
+
+// Convert an object into a Go value, storing it in the variable that p points
+// to. This reverses Wrap().
+//
+// Numbers, strings, booleans and buffers convert to the corresponding Go
+// types, so long as the value fits. Arrays convert to slices or arrays, and
+// hashes to maps or, when their keys are strings naming fields as Wrap() does,
+// to structs. Nil converts to the zero value of pointers, slices, maps,
+// functions and interfaces. An object wrapping a Go value converts to any
+// type that value may be assigned to, as does the user data of an instance of a
+// class such as those made by BindClass(). Any other object may be used as a
+// function, whose arguments are converted using Wrap() and whose results are
+// converted using Unwrap().
+//
+// When converting to interface{} the Go type is chosen to suit the object:
+// int64, float64, string, bool, []byte, []interface{},
+// map[interface{}]interface{} or, failing those, *Object.
+func Unwrap(o *Object, p interface{}) error {
+	v := reflect.ValueOf(p)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("cannot unwrap into %T", p)
+	}
+	return unwrapValue(o, v.Elem(), "")
+}
+
+// Convert an object into a Go value, storing it in v, which must be settable.
+// The path describes where the object was found, for error messages.
+func unwrapValue(o *Object, v reflect.Value, path string) error {
+	t := v.Type()
+	fail := func(reason string) error {
+		return &ConversionError{o, t, path, reason}
+	}
+	if t == objectType {
+		v.Set(reflect.ValueOf(o))
+		return nil
+	}
+	if o.c == GoObjectClass || o.c.FlagSet(UserData) && o.data != nil {
+		x := reflect.ValueOf(o.data)
+		elem := x
+		if x.Kind() == reflect.Ptr && !x.IsNil() {
+			elem = x.Elem()
+		}
+		switch {
+		case x.Type().AssignableTo(t):
+			v.Set(x)
+			return nil
+		case elem.Type().AssignableTo(t):
+			v.Set(elem)
+			return nil
+		}
+		if o.c == GoObjectClass {
+			return fail("")
+		}
+	}
+	if o == Nil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Func,
+			reflect.Interface:
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		return fail("")
+	}
+	switch t {
+	case bigIntType:
+		if o.c != IntClass && o.c != BigIntClass {
+			return fail("")
+		}
+		v.Set(reflect.ValueOf(new(big.Int).Set(toBig(o))))
+		return nil
+	case bigRatType:
+		if k := numKind(o); k > ratNum {
+			return fail("")
+		}
+		v.Set(reflect.ValueOf(new(big.Rat).Set(toRat(o))))
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		switch o {
+		case True:
+			v.SetBool(true)
+		case False:
+			v.SetBool(false)
+		default:
+			return fail("")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if o.c == BigIntClass {
+			return fail(fmt.Sprintf("%s is out of range", o.data))
+		}
+		if o.c != IntClass {
+			return fail("")
+		}
+		n := o.ToInt()
+		if v.OverflowInt(n) {
+			return fail(fmt.Sprintf("%d is out of range", n))
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		if o.c == BigIntClass {
+			x := toBig(o)
+			if x.Sign() < 0 || !x.IsUint64() || v.OverflowUint(x.Uint64()) {
+				return fail(fmt.Sprintf("%s is out of range", x))
+			}
+			v.SetUint(x.Uint64())
+			return nil
+		}
+		if o.c != IntClass {
+			return fail("")
+		}
+		n := o.ToInt()
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			return fail(fmt.Sprintf("%d is out of range", n))
+		}
+		v.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		if numKind(o) == notNum {
+			return fail("")
+		}
+		v.SetFloat(toFloat(o))
+	case reflect.String:
+		if o.c != StringClass {
+			return fail("")
+		}
+		v.SetString(o.ToString())
+	case reflect.Slice:
+		bytes := t.Elem().Kind() == reflect.Uint8
+		switch {
+		case bytes && o.c == BufferClass:
+			v.SetBytes(o.ToBuffer())
+		case bytes && o.c == StringClass:
+			v.SetBytes([]byte(o.ToString()))
+		case o.c == ArrayClass:
+			xs := o.ToArray()
+			s := reflect.MakeSlice(t, len(xs), len(xs))
+			if err := unwrapElements(xs, s, path); err != nil {
+				return err
+			}
+			v.Set(s)
+		default:
+			return fail("")
+		}
+	case reflect.Array:
+		if o.c != ArrayClass {
+			return fail("")
+		}
+		xs := o.ToArray()
+		if len(xs) != t.Len() {
+			return fail(fmt.Sprintf("wrong length %d", len(xs)))
+		}
+		a := reflect.New(t).Elem()
+		if err := unwrapElements(xs, a, path); err != nil {
+			return err
+		}
+		v.Set(a)
+	case reflect.Map:
+		if o.c != HashClass {
+			return fail("")
+		}
+		h := o.hashData().items()
+		m := reflect.MakeMapWithSize(t, len(h))
+		for _, item := range h {
+			kpath := fmt.Sprintf("%s[%s]", path, item.key)
+			k := reflect.New(t.Key()).Elem()
+			if err := unwrapValue(item.key, k, kpath); err != nil {
+				return err
+			}
+			if k.Kind() == reflect.Interface && !k.IsNil() &&
+			   !k.Elem().Type().Comparable() {
+				k.Set(reflect.ValueOf(item.key))
+			}
+			x := reflect.New(t.Elem()).Elem()
+			if err := unwrapValue(item.val, x, kpath); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, x)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		if o.c != HashClass {
+			return fail("")
+		}
+		s := reflect.New(t).Elem()
+		for _, item := range o.hashData().items() {
+			if item.key.c != StringClass {
+				return fail(fmt.Sprintf("%s key", className(item.key)))
+			}
+			n := item.key.ToString()
+			f, ok := goField(s, n)
+			if !ok {
+				return fail("no field " + n)
+			}
+			if err := unwrapValue(item.val, f, path + "." + n); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Ptr:
+		x := reflect.New(t.Elem())
+		if err := unwrapValue(o, x.Elem(), path); err != nil {
+			return err
+		}
+		v.Set(x)
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			x := reflect.New(naturalType(o)).Elem()
+			if err := unwrapValue(o, x, path); err != nil {
+				return err
+			}
+			v.Set(x)
+			break
+		}
+		if !objectType.Implements(t) {
+			return fail("")
+		}
+		v.Set(reflect.ValueOf(o))
+	case reflect.Func:
+		v.Set(unwrapFunc(o, t))
+	default:
+		return fail("")
+	}
+	return nil
+}
+
+func unwrapElements(xs []*Object, v reflect.Value, path string) error {
+	for i, x := range xs {
+		err := unwrapValue(x, v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The type an object takes when converted to interface{}.
+func naturalType(o *Object) reflect.Type {
+	switch o.c {
+	case TrueClass, FalseClass:
+		return reflect.TypeOf(false)
+	case IntClass:
+		return reflect.TypeOf(int64(0))
+	case BigIntClass:
+		return bigIntType
+	case RationalClass:
+		return bigRatType
+	case FltClass:
+		return reflect.TypeOf(float64(0))
+	case StringClass:
+		return reflect.TypeOf("")
+	case BufferClass:
+		return bytesType
+	case ArrayClass:
+		return reflect.SliceOf(anyType)
+	case HashClass:
+		return reflect.MapOf(anyType, anyType)
+	}
+	return objectType
+}
+
+// Make a Go function of type t that calls o. Its arguments are converted with
+// Wrap() and its results with Unwrap(), several results being taken from an
+// array. If its last result is an error then anything raised by the call is
+// returned there rather than left to panic.
+func unwrapFunc(o *Object, t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(in []reflect.Value) (out []reflect.Value) {
+		out = make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.New(t.Out(i)).Elem()
+		}
+		n := len(out)
+		if n != 0 && t.Out(n-1) == errorType {
+			n--
+			defer func() {
+				if e := recover(); e != nil {
+					for i := range out {
+						out[i] = reflect.New(t.Out(i)).Elem()
+					}
+					out[n].Set(reflect.ValueOf(ToError(e)))
+				}
+			}()
+		}
+		if t.IsVariadic() {
+			last := in[len(in)-1]
+			in = in[:len(in)-1]
+			for i := 0; i < last.Len(); i++ {
+				in = append(in, last.Index(i))
+			}
+		}
+		args := make([]*Object, len(in))
+		for i, x := range in {
+			args[i] = Wrap(x.Interface())
+		}
+		res := o.Call(nil, args...)
+		switch n {
+		case 0:
+		case 1:
+			if err := unwrapValue(res, out[0], "result"); err != nil {
+				panic(err)
+			}
+		default:
+			if res.c != ArrayClass || len(res.ToArray()) != n {
+				panic(&ConversionError{res, t, "result", "not enough results"})
+			}
+			for i, x := range res.ToArray() {
+				path := fmt.Sprintf("result %d", i+1)
+				if err := unwrapValue(x, out[i], path); err != nil {
+					panic(err)
+				}
+			}
+		}
+		return
+	})
+}
+
+func initGoClasses() {
+	GoObjectClass = ObjectClass.extend("GoObject", Final|Abstract|UserData, []Slot {
+		MSlot("__getFailed__", func(o, a *Object) *Object {
+			n := a.accessorData().n
+			v, elem := o.goData()
+			if f, ok := goField(elem, n); ok {
+				return wrapField(f)
+			}
+			if m := goMethod(v, n); m.IsValid() {
+				return wrapFunc(m)
+			}
+			panic(fmt.Errorf("undefined: %s.%s", v.Type(), n))
+		}),
+		MSlot("__setFailed__", func(o, a, x *Object) *Object {
+			n := a.accessorData().n
+			v, elem := o.goData()
+			f, ok := goField(elem, n)
+			if !ok {
+				panic(fmt.Errorf("undefined: %s.%s", v.Type(), n))
+			}
+			if !f.CanSet() {
+				panic(fmt.Errorf("cannot set %s.%s", v.Type(), n))
+			}
+			setField(f, x, n)
+			return Nil
+		}),
+		MSlot("__callFailed__", func(o *Object, args []*Object) *Object {
+			if len(args) < 1 {
+				 panic(ArgError(len(args)))
+			}
+			n := args[0].accessorData().n
+			v, elem := o.goData()
+			if m := goMethod(v, n); m.IsValid() {
+				return callFunc(m, args[1:])
+			}
+			if f, ok := goField(elem, n); ok {
+				return Wrap(f.Interface()).Call(nil, args[1:]...)
+			}
+			panic(fmt.Errorf("undefined: %s.%s", v.Type(), n))
+		}),
+		MSlot("__eq__", func(o, x *Object) *Object {
+			return Wrap(x.c == GoObjectClass && o.data == x.data)
+		}),
+		MSlot("toString", func(o *Object) *Object {
+			switch x := o.data.(type) {
+			case fmt.Stringer:
+				return Wrap(x.String())
+			case error:
+				return Wrap(x.Error())
+			}
+			return Wrap(fmt.Sprintf("#<%T>", o.data))
+		}),
+		MSlot("slotNames", func(o *Object, flags []*Object) *Object {
+			in := map[string] bool{}
+			hook, deep := parseNamesFlags(flags)
+			classScanNames(o.c, in, hook, deep)
+			v, elem := o.goData()
+			if elem.Kind() == reflect.Struct {
+				for n := range goFields(elem.Type()) {
+					in[n] = true
+				}
+			}
+			for i := 0; i < v.NumMethod(); i++ {
+				in[scriptName(v.Type().Method(i).Name)] = true
+			}
+			res := []string{}
+			for x := range in {
+				res = append(res, x)
+			}
+			return Wrap(res)
+		}),
+	})
+}
+
@@ -0,0 +1,234 @@
+package ts_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+type point struct {
+	X, Y int
+	hidden int
+}
+
+func (p point) Sum() int {
+	return p.X + p.Y
+}
+
+func (p *point) Move(dx, dy int) {
+	p.X += dx
+	p.Y += dy
+}
+
+// Go structs, maps and functions of any type may be used by scripts.
+func TestWrapValues(t *testing.T) {
+	i := ts.New()
+	i.Define("p", ts.Wrap(&point{X: 1, Y: 2}))
+	i.Define("v", ts.Wrap(point{X: 3, Y: 4}))
+	i.Define("m", ts.Wrap(map[string]int{"a": 1, "b": 2}))
+	i.Define("xs", ts.Wrap([]float64{1.5, 2.5}))
+	i.Define("add", ts.Wrap(func(a, b int) int { return a + b }))
+	i.Define("join", ts.Wrap(strings.Join))
+	i.Define("split", ts.Wrap(func(s string) (string, string) {
+		return s[:1], s[1:]
+	}))
+	i.Define("check", ts.Wrap(func(n int8) (int, error) {
+		if n < 0 {
+			return 0, errors.New("negative")
+		}
+		return int(n), nil
+	}))
+	i.Define("sum", ts.Wrap(func(xs ...int) int {
+		res := 0
+		for _, x := range xs {
+			res += x
+		}
+		return res
+	}))
+	tests := []struct {
+		name, src, want string
+	}{
+		{"field", "p.x;", "1"},
+		{"method", "p.sum();", "3"},
+		{"pointer method", "p.move(2, 3); [p.x, p.y];", "[3, 5]"},
+		{"set field", "p.y = 10; p.sum();", "13"},
+		{"struct value", "v.x = 5; v.sum();", "9"},
+		{"unexported", "catch(fn() = p.hidden).is(Error);", "true"},
+		{"map", `[m["a"], m["b"], m.is(Hash)];`, "[1, 2, true]"},
+		{"slice", "[xs[1], xs.size];", "[2.5, 2]"},
+		{"func", "add(1, 2);", "3"},
+		{"func slice", `join(["a", "b"], "-");`, "a-b"},
+		{"results", `split("abc");`, "[a, bc]"},
+		{"error result", "[check(3), catch(fn() = check(-1)).msg];", "[3, negative]"},
+		{"variadic", "[sum(), sum(1, 2, 3)];", "[0, 6]"},
+		{"bad argument", `catch(fn() = add("x", 1)).msg;`, "cannot convert String to int (at argument 1)"},
+		{"out of range", "catch(fn() = check(300)).msg;", "cannot convert Integer to int8: 300 is out of range (at argument 1)"},
+		{"arity", "catch(fn() = add(1)).msg;", "wrong number of arguments 1"},
+	}
+	for _, test := range tests {
+		x, err := i.EvalErr(test.src)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if x.String() != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, x, test.want)
+		}
+	}
+}
+
+// Objects convert back into Go values of the type asked for.
+func TestUnwrapValues(t *testing.T) {
+	i := ts.New()
+	eval := func(src string) *ts.Object {
+		x, err := i.EvalErr(src)
+		if err != nil {
+			t.Fatalf("%s: %s", src, err)
+		}
+		return x
+	}
+	var n int
+	var f float64
+	var s []string
+	var m map[string]int
+	var p point
+	var pp *point
+	var fn func(int, int) (int, error)
+	var any interface{}
+	tests := []struct {
+		src string
+		into, want interface{}
+	}{
+		{"1 + 2;", &n, 3},
+		{"2.5;", &f, 2.5},
+		{`["a", "b"];`, &s, []string{"a", "b"}},
+		{`{"a": 1};`, &m, map[string]int{"a": 1}},
+		{`{"x": 1, "y": 2};`, &p, point{X: 1, Y: 2}},
+		{`{"x": 3};`, &pp, &point{X: 3}},
+		{"nil;", &s, []string(nil)},
+		{`[1, "a", [true]];`, &any, []interface{}{int64(1), "a", []interface{}{true}}},
+	}
+	for _, test := range tests {
+		if err := ts.Unwrap(eval(test.src), test.into); err != nil {
+			t.Errorf("%s: %s", test.src, err)
+			continue
+		}
+		got := reflect.ValueOf(test.into).Elem().Interface()
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.src, got, test.want)
+		}
+	}
+	if err := ts.Unwrap(eval("fn(a, b) = a * b;"), &fn); err != nil {
+		t.Fatal(err)
+	}
+	if x, err := fn(3, 4); x != 12 || err != nil {
+		t.Errorf("func: got %v, %v", x, err)
+	}
+	if err := ts.Unwrap(eval(`fn(a, b) = throw("no");`), &fn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fn(3, 4); err == nil || !strings.Contains(err.Error(), "no") {
+		t.Errorf("func error: got %v", err)
+	}
+	p = point{}
+	if err := ts.Unwrap(ts.Wrap(&point{X: 7}), &p); err != nil || p.X != 7 {
+		t.Errorf("go object: got %+v, %v", p, err)
+	}
+}
+
+// Objects that cannot be converted give errors saying why and where.
+func TestUnwrapErrors(t *testing.T) {
+	i := ts.New()
+	var n int8
+	var s []int
+	var p point
+	tests := []struct {
+		src string
+		into interface{}
+		want string
+	}{
+		{`"a";`, &n, "cannot convert String to int8"},
+		{"300;", &n, "cannot convert Integer to int8: 300 is out of range"},
+		{`[1, "b"];`, &s, "cannot convert String to int (at [1])"},
+		{`{"x": "a"};`, &p, "cannot convert String to int (at .x)"},
+		{`{"z": 1};`, &p, "cannot convert Hash to ts_test.point: no field z"},
+	}
+	for _, test := range tests {
+		x, err := i.EvalErr(test.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ts.Unwrap(x, test.into)
+		var cerr *ts.ConversionError
+		if !errors.As(err, &cerr) || err.Error() != test.want {
+			t.Errorf("%s: got %v, want %s", test.src, err, test.want)
+		}
+	}
+	if err := ts.Unwrap(ts.Wrap(1), n); err == nil {
+		t.Error("not a pointer: no error")
+	}
+}
+
+type page struct {
+	Title string `ts:"heading"`
+	URLPath string
+	Secret string `ts:"-"`
+	Views int `ts:",private"`
+}
+
+func (p *page) WordCount() int {
+	return 2
+}
+
+// Go values are known to scripts by the same names as those of a bound class.
+func TestWrapNames(t *testing.T) {
+	i := ts.New()
+	p := &page{Title: "Hello there", URLPath: "/hello", Secret: "x", Views: 3}
+	i.Define("p", ts.Wrap(p))
+	tests := []struct {
+		name, src, want string
+	}{
+		{"renamed", "p.heading;", "Hello there"},
+		{"default", "p.urlPath;", "/hello"},
+		{"method", "p.wordCount();", "2"},
+		{"set", `p.heading = "Bye"; p.heading;`, "Bye"},
+		{"go name", "catch(fn() = p.Title).msg;", "undefined: *ts_test.page.Title"},
+		{"hidden", "catch(fn() = p.secret).msg;", "undefined: *ts_test.page.secret"},
+		{"private", "catch(fn() = p.views).msg;", "undefined: *ts_test.page.views"},
+		{"set hidden", "catch(fn() p.secret = 1; end).msg;", "undefined: *ts_test.page.secret"},
+		{"names", `
+			def names = ["heading", "urlPath", "wordCount", "Title", "secret", "views"];
+			filter(names, fn(n) = filter(p.slotNames(), fn(m) = m == n).size != 0);
+		`, "[heading, urlPath, wordCount]"},
+	}
+	for _, test := range tests {
+		x, err := run(t, i, 20*time.Second, test.src, ts.Limits{})
+		expect(t, test.name, x, err, test.want)
+	}
+}
+
+// Hashes convert to structs by the names that scripts know their fields by.
+func TestUnwrapNames(t *testing.T) {
+	i := ts.New()
+	var p page
+	x, err := run(t, i, 20*time.Second, `{"heading": "Hi", "urlPath": "/hi"};`, ts.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Unwrap(x, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Title != "Hi" || p.URLPath != "/hi" {
+		t.Errorf("got %+v", p)
+	}
+	for _, src := range []string{`{"Title": "Hi"};`, `{"secret": "x"};`, `{"views": 1};`} {
+		x, err := run(t, i, 20*time.Second, src, ts.Limits{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.Unwrap(x, &p); err == nil {
+			t.Errorf("%s: converted to %+v", src, p)
+		}
+	}
+}
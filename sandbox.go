@@ -0,0 +1,167 @@
+package ts
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*******************************************************************************
+
+	Sandboxing
+
+*******************************************************************************/
+
+// Describes what the code running in a sandboxed interpreter may do.
+type Sandbox struct {
+	// The builtins defined by LoadPrimitives() that scripts may use. The
+	// classes are always available. Much of the prelude relies on done,
+	// catch and throw.
+	Builtins []string
+	// The registered extensions that loadExtension() may load.
+	Extensions []string
+	// The directory that file access is confined to. If empty, files may not
+	// be accessed at all. Packages are imported from its "pkg" directory.
+	Root string
+	// The environment variables that scripts may read.
+	Env []string
+	// Whether scripts may read standard input and write standard output
+	// through the system extension.
+	Stdio bool
+	// The command line arguments that scripts see in place of os.Args.
+	Args []string
+	// Limits that apply whenever Go code calls into the interpreter.
+	Limits Limits
+}
+
+type sandbox struct {
+	Sandbox
+	root *os.Root
+	withheld []string
+}
+
+var NoFileAccess = errors.New("file access is not allowed")
+
+// Where a sandboxed interpreter looks for packages, relative to its root.
+const sandboxPackages = "/pkg"
+
+// Create a new interpreter where the host decides what scripts may do. Unlike
+// in the default environment, exit() raises an *ExitError rather than ending
+// the program, which ends the script as scripts cannot catch it. Panics if the
+// sandbox's root cannot be opened.
+func NewSandboxed(s Sandbox) *Interpreter {
+	i := new(Interpreter)
+	i.sandbox = &sandbox{Sandbox: s}
+	if s.Root != "" {
+		r, err := os.OpenRoot(s.Root)
+		if err != nil {
+			panic(err)
+		}
+		i.sandbox.root = r
+	}
+	i.LoadPrimitives()
+	i.Load(root() + "/prelude")
+	// scripts see the root as "/", so packages outside of it cannot be loaded
+	paths := Wrap([]*Object{Wrap(sandboxPackages)})
+	i.Get("packages").Set(i.Accessor("packagePaths"), paths)
+	// the prelude may use any builtin while it is being loaded
+	for _, n := range i.sandbox.withheld {
+		b := i.lookup(n)
+		i.mu.Lock()
+		b.c = undefinedClass
+		b.data = Wrap(n)
+		i.mu.Unlock()
+	}
+	i.SetLimits(s.Limits)
+	return i
+}
+
+// Builtins that compiled code calls, which a sandbox cannot withhold.
+var requiredBuiltins = []string{"currentLoadFile"}
+
+// Define a builtin, noting whether the sandbox (if any) withholds it.
+func (i *Interpreter) defineBuiltin(n string, x *Object) {
+	i.Define(n, x)
+	if lookup(n, requiredBuiltins) != -1 {
+		return
+	}
+	if i.sandbox != nil && lookup(n, i.sandbox.Builtins) == -1 {
+		i.sandbox.withheld = append(i.sandbox.withheld, n)
+	}
+}
+
+// Check whether an extension may be loaded.
+func (i *Interpreter) extensionAllowed(n string) bool {
+	return i.sandbox == nil || lookup(n, i.sandbox.Extensions) != -1
+}
+
+// Open a file on behalf of running code, as os.OpenFile(). In a sandboxed
+// interpreter the name is taken relative to the sandbox's root and may not
+// refer to anything outside of it. Extensions should use this rather than
+// accessing the filesystem directly.
+func (i *Interpreter) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	if i.sandbox == nil {
+		return os.OpenFile(name, flag, perm)
+	}
+	if i.sandbox.root == nil {
+		return nil, NoFileAccess
+	}
+	return i.sandbox.root.OpenFile(rootRelative(name), flag, perm)
+}
+
+// Describe a file on behalf of running code, as os.Stat(). Subject to the
+// same restrictions as OpenFile().
+func (i *Interpreter) Stat(name string) (os.FileInfo, error) {
+	if i.sandbox == nil {
+		return os.Stat(name)
+	}
+	if i.sandbox.root == nil {
+		return nil, NoFileAccess
+	}
+	return i.sandbox.root.Stat(rootRelative(name))
+}
+
+// The environment visible to running code, as os.Environ().
+func (i *Interpreter) Environ() []string {
+	env := os.Environ()
+	if i.sandbox == nil {
+		return env
+	}
+	res := []string{}
+	for _, x := range env {
+		n := strings.SplitN(x, "=", 2)[0]
+		if lookup(n, i.sandbox.Env) != -1 {
+			res = append(res, x)
+		}
+	}
+	return res
+}
+
+// The standard input and output of running code, os.Stdin and os.Stdout.
+// Both are nil in a sandbox that does not grant them.
+func (i *Interpreter) Stdio() (io.Reader, io.Writer) {
+	if i.sandbox != nil && !i.sandbox.Stdio {
+		return nil, nil
+	}
+	return os.Stdin, os.Stdout
+}
+
+// The command line arguments visible to running code, as os.Args.
+func (i *Interpreter) Args() []string {
+	if i.sandbox == nil {
+		return os.Args
+	}
+	return append([]string{}, i.sandbox.Args...)
+}
+
+// Scripts see the root as "/".
+func rootRelative(name string) string {
+	sep := string(filepath.Separator)
+	name = strings.TrimLeft(filepath.Clean(sep + name), sep)
+	if name == "" {
+		return "."
+	}
+	return name
+}
@@ -0,0 +1,116 @@
+package ts_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+	_ "github.com/bobappleyard/ts/ext/system"
+)
+
+// A sandbox whose root holds some packages.
+func sandboxWith(t *testing.T, pkgs map[string] string) *ts.Interpreter {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for n, src := range pkgs {
+		err := os.WriteFile(filepath.Join(dir, "pkg", n + ".pkg"), []byte(src), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ts.NewSandboxed(ts.Sandbox{
+		Builtins: []string{"done", "catch", "throw", "exit"},
+		Root: dir,
+	})
+}
+
+// Packages are imported from the sandbox's root.
+func TestSandboxImport(t *testing.T) {
+	i := sandboxWith(t, map[string] string{
+		"greet": `
+			package greet
+				export hello;
+				def hello(n) = "hello " + n;
+			end;
+		`,
+	})
+	x, err := run(t, i, 20*time.Second, `
+		import greet;
+		greet.hello("world");
+	`, ts.Limits{})
+	expect(t, "import", x, err, "hello world")
+}
+
+// The error that stops a package from loading is raised by the import.
+func TestSandboxImportError(t *testing.T) {
+	i := sandboxWith(t, map[string] string{
+		"broken": `
+			package broken
+				throw("broken on load");
+			end;
+		`,
+	})
+	tests := []struct {
+		name, src, want string
+	}{
+		{"missing", "import nothing;", "nothing.pkg"},
+		{"raised", "import broken;", "broken on load"},
+		{"caught", `
+			def e = catch(fn() import broken; end);
+			throw(e.msg + " and caught");
+		`, "broken on load and caught"},
+	}
+	for _, test := range tests {
+		_, err := run(t, i, 20*time.Second, test.src, ts.Limits{})
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+// A sandboxed script cannot catch exit(), and ends when it calls it.
+func TestSandboxExit(t *testing.T) {
+	i := sandboxWith(t, nil)
+	_, err := run(t, i, 20*time.Second, `
+		catch(fn() exit(3); end);
+		throw("still running");
+	`, ts.Limits{})
+	var e *ts.ExitError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v, want an exit", err)
+	}
+	if e.Code != 3 {
+		t.Errorf("got code %d, want 3", e.Code)
+	}
+}
+
+// The system extension only reaches standard input and output, and sees the
+// command line, when the sandbox grants them.
+func TestSandboxSystem(t *testing.T) {
+	const src = `
+		def system = loadExtension("system");
+		[system.args, catch(fn() = system.input).is(Error), catch(fn() = system.output).is(Error)];
+	`
+	i := ts.NewSandboxed(ts.Sandbox{
+		Builtins: []string{"done", "catch", "throw", "loadExtension"},
+		Extensions: []string{"system"},
+	})
+	x, err := run(t, i, 20*time.Second, src, ts.Limits{})
+	expect(t, "withheld", x, err, "[[], true, true]")
+	i = ts.NewSandboxed(ts.Sandbox{
+		Builtins: []string{"done", "catch", "throw", "loadExtension"},
+		Extensions: []string{"system"},
+		Stdio: true,
+		Args: []string{"script", "-v"},
+	})
+	x, err = run(t, i, 20*time.Second, src, ts.Limits{})
+	expect(t, "granted", x, err, "[[script, -v], false, false]")
+	if in, out := i.Stdio(); in != os.Stdin || out != os.Stdout {
+		t.Error("standard input and output not granted")
+	}
+}
@@ -0,0 +1,63 @@
+package ts_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+func TestInterpolation(t *testing.T) {
+	expectAll(t, []scriptTest{
+		{"names", `def name = "Bob", n = 2; "Hello ${name}, you have ${n + 1} items";`, "Hello Bob, you have 3 items"},
+		{"values", `"a ${[1, 2]} b ${nil} c";`, "a [1, 2] b nil c"},
+		{"adjacent", `"${1}${2}";`, "12"},
+		{"nested", `"nested ${"x${1 + 1}y"} z";`, "nested x2y z"},
+		{"braces", `"braces ${{"a": 1}["a"]}";`, "braces 1"},
+		{"brace in string", `"${"}"}";`, "}"},
+		{"dollar", `"cost \$5 ${1}";`, "cost $5 1"},
+		{"toString", `def o = class() def toString() = "o"; end(); "${o}!";`, "o!"},
+		{"in function", `def f(x) = "<${x}>"; f(1);`, "<1>"},
+		{"caught", `def e = catch(fn() "${nope}"; end); e.msg;`, "undefined variable: nope"},
+		{"try", "def r;\ntry\n\t\"a ${1 ~/ 0}\";\ncatch e\n\tr = [e.line, e.msg];\nend;\nr;", "[3, division by zero]"},
+	})
+}
+
+func TestRawStrings(t *testing.T) {
+	expectAll(t, []scriptTest{
+		{"no escapes", "`raw \\n ${x}`;", `raw \n ${x}`},
+		{"lines", "`a\nb`;", "a\nb"},
+		{"joined", "\"${1}\" + `2`;", "12"},
+	})
+}
+
+func TestStringErrors(t *testing.T) {
+	expectErrors(t, []scriptTest{
+		{"undefined", `"${undefinedThing}";`, "undefined variable: undefinedThing"},
+		{"empty", `"empty ${}";`, "unexpected }"},
+		{"bad expression", `"bad ${1 +}";`, "unexpected }"},
+		{"unterminated", `"unterminated ${1";`, "unexpected EOF"},
+		{"unterminated raw", "`unterminated", "unexpected EOF"},
+		{"newline", "\"line\n${1}\";", "invalid syntax"},
+		{"raising toString", `def o = class() def toString() = throw("no"); end(); "${o}";`, "no"},
+		{"wrong type", `def o = class() def toString() = 5; end(); "x${o}";`, "wrong type: Integer"},
+		{"arity", `def f(x) = "<${x}>"; f();`, "wrong number of arguments 0"},
+		{"line", "def s = `a\nb`;\n\"${nope}\";", "test(3): undefined variable: nope"},
+	})
+}
+
+// Interpolated values are built by calls, and so count towards the limits.
+func TestInterpolationLimit(t *testing.T) {
+	_, err := run(t, ts.New(), 20*time.Second, `
+		def deep(n) = "(${deep(n + 1)})";
+		try
+			deep(0);
+		catch e
+			throw("caught");
+		end;
+	`, ts.Limits{Frames: 100})
+	var l *ts.LimitError
+	if !errors.As(err, &l) {
+		t.Errorf("got %v, want a limit", err)
+	}
+}
@@ -0,0 +1,94 @@
+package ts_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+// The frames of the calls in progress when an error is raised, innermost first.
+func TestTrace(t *testing.T) {
+	expectAll(t, []scriptTest{
+		{"functions", `
+			def fail() = throw("x");
+			def g()
+				return [fail()];
+			end;
+			def e = catch(fn() = [g()]);
+			map(e.trace, fn(f) = f.toString());
+		`, "[test(2): fail, test(4): g, test(6): <anonymous>]"},
+		{"method", `
+			class A()
+				def m() = [throw("x")];
+			end;
+			def e = catch(fn() = [A().m()]);
+			map(e.trace, fn(f) = f.toString());
+		`, "[test(3): A.m, test(5): <anonymous>]"},
+		{"fields", `
+			def f = fn()
+				throw("y");
+			end;
+			def r = catch(f).trace[0];
+			[r.name, r.file, r.line, r.column];
+		`, "[f, test, 3, 10]"},
+		{"through Go", `
+			def fail(x) = throw("x");
+			def e = catch(fn() = map([1], fail));
+			[e.trace[0].toString(), e.trace.size > 2];
+		`, "[test(2): fail, true]"},
+		{"cut short", `
+			def deep(next, n)
+				if n == 0 then
+					throw("deep");
+				end;
+				return [next(n - 1)];
+			end;
+			def e = catch(fn() = loop(deep, 500));
+			[e.trace.size, e.trace[0].toString(), e.trace[100].toString()];
+		`, "[101, test(4): deep, ... 903 more]"},
+	})
+}
+
+// The trace as seen from Go.
+func TestTraceFromGo(t *testing.T) {
+	src := "def f() = [g()];\ndef g() = [throw(\"boom\")];\nf();"
+	_, err := run(t, ts.New(), 20*time.Second, src, ts.Limits{})
+	var e *ts.ScriptError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v", err)
+	}
+	var got []string
+	for _, f := range e.Trace {
+		got = append(got, f.String())
+	}
+	want := "test(2): g, test(1): f, test(3): <top level>"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+	if f := e.Trace[0]; f.Function != "g" || f.Class != "" || f.File != "test" || f.Line != 2 {
+		t.Errorf("got %#v", f)
+	}
+}
+
+// An error raised deep inside calls made from Go is reported in good time.
+func TestDeepTrace(t *testing.T) {
+	u := new(ts.Unit)
+	u.Compile(strings.NewReader("loop(fn(next) = next());"), "test")
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := ts.New().ExecContext(ctx, u)
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("took %s", d)
+	}
+	var e *ts.ScriptError
+	if !errors.As(err, &e) {
+		t.Fatalf("got %v", err)
+	}
+	if l := len(e.Trace); l != 101 || !strings.HasPrefix(e.Trace[100].Function, "... ") {
+		t.Errorf("got %d frames, ending %v", l, e.Trace[l-1])
+	}
+}
@@ -0,0 +1,193 @@
+package ts
+
+import (
+	"fmt"
+)
+
+/*******************************************************************************
+
+	Traits
+
+*******************************************************************************/
+
+// Marks a slot as a method that a trait requires, rather than provides.
+const abstractSlot SlotFlags = 1 << 3
+
+// Create a trait with the given name and members. It includes the members of
+// the traits given, which its own members override. Methods made with
+// AbstractMethod() are required by the trait: a class it is mixed into must
+// define them. The trait is not defined as a global. Panics if any of the
+// members is private.
+func NewTrait(n string, e []Slot, includes ...*Class) *Class {
+	for _, s := range e {
+		if s.Flags.Vis() == Private {
+			panic(fmt.Errorf("private member in trait: %s.%s", n, s.Name))
+		}
+	}
+	return newTrait(n, e, includes)
+}
+
+func newTrait(n string, e []Slot, includes []*Class) *Class {
+	for _, t := range includes {
+		if t.flags & Trait == 0 {
+			panic(fmt.Errorf("not a trait: %s", t.n))
+		}
+	}
+	c := &Class{flags: Trait|Abstract, n: n, e: e, t: includes}
+	c.o = new(clsObj).init(c)
+	return c
+}
+
+// Mix traits into a class that is about to be extended. Each trait, after the
+// traits it includes, becomes a class between the ancestor and the class that
+// extends it, so its members override those of the ancestor. The class that
+// extends it defines the names in own, which override the traits' members in
+// turn. If the ancestor is itself a trait then it is mixed into Object.
+func (i *Interpreter) mixin(a *Class, traits []*Class, own []string) *Class {
+	if a.flags & Trait != 0 {
+		traits = append([]*Class{a}, traits...)
+		a = ObjectClass
+	}
+	var ts []*Class
+	for _, t := range traits {
+		if t.flags & Trait == 0 {
+			panic(fmt.Errorf("not a trait: %s", t.n))
+		}
+		ts = linearize(ts, a, t)
+	}
+	checkConflicts(ts, own)
+	for _, t := range ts {
+		a = i.mixClass(a, t)
+	}
+	return a
+}
+
+// Add a trait to a list of traits to mix into a class, after the traits it
+// includes. Traits already in the list or already mixed into the class are
+// left out.
+func linearize(ts []*Class, a, t *Class) []*Class {
+	for _, x := range t.t {
+		ts = linearize(ts, a, x)
+	}
+	if a.Is(t) {
+		return ts
+	}
+	for _, x := range ts {
+		if x == t {
+			return ts
+		}
+	}
+	return append(ts, t)
+}
+
+// Two traits conflict if they both provide a member with the same name, and
+// neither trait includes the other. The class being defined can settle the
+// conflict by defining the member itself.
+func checkConflicts(ts []*Class, own []string) {
+	defined := map[string] bool{}
+	for _, n := range own {
+		defined[n] = true
+	}
+	from := map[string] *Class{}
+	for _, t := range ts {
+		for _, s := range t.e {
+			if s.Flags & abstractSlot != 0 || defined[s.Name] {
+				continue
+			}
+			if u := from[s.Name]; u != nil && !t.Is(u) {
+				panic(fmt.Errorf("traits conflict: %s.%s and %s.%s", u.n, s.Name,
+				                 t.n, s.Name))
+			}
+			from[s.Name] = t
+		}
+	}
+}
+
+// The class made by mixing a trait into an ancestor. The same class is used for
+// every class that mixes the trait into that ancestor.
+func (i *Interpreter) mixClass(a, t *Class) *Class {
+	classLock.Lock()
+	defer classLock.Unlock()
+	if c := t.mixes[a]; c != nil {
+		return c
+	}
+	var e []Slot
+	for _, s := range t.e {
+		// a required method is already there if the ancestor has it
+		if s.Flags & abstractSlot != 0 && a.slotFor(s.Name) != nil {
+			continue
+		}
+		e = append(e, s)
+	}
+	c := a.extend(t.n, Abstract, e)
+	c.mix = t
+	i.slotUnit(c.e).clInner(c)
+	if t.mixes == nil {
+		t.mixes = map[*Class] *Class{}
+	}
+	t.mixes[a] = c
+	return c
+}
+
+// The public slot a class uses for a name, or nil if it has none.
+func (c *Class) slotFor(n string) *Slot {
+	for cur := c; cur != nil; cur = cur.a {
+		for j := range cur.e {
+			s := &cur.e[j]
+			if s.Name == n && s.Flags.Kind() != Marker && s.Flags.Vis() == Public {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+// Check that a class defines the methods required by the traits mixed into it.
+func (c *Class) checkRequired() {
+	for cur := c.a; cur != nil; cur = cur.a {
+		if cur.mix == nil {
+			continue
+		}
+		for _, s := range cur.e {
+			if s.Flags & abstractSlot != 0 && c.slotFor(s.Name).Flags & abstractSlot != 0 {
+				panic(fmt.Errorf("%s does not define %s, required by %s", c.n,
+				                 s.Name, cur.n))
+			}
+		}
+	}
+}
+
+// Mix the traits on the stack into the class below them. The skeleton of the
+// class that is to extend the result is below that.
+func (p *process) mixin(n int) *Object {
+	l := len(p.s) - n
+	ts := make([]*Class, n)
+	for j, x := range p.s[l:] {
+		ts[j] = x.ToClass()
+	}
+	a := p.s[l-1].ToClass()
+	e := p.s[l-2].skelData()
+	p.s = p.s[:l-1]
+	var own []string
+	for _, s := range e[1:] {
+		if s.Flags.Kind() != Marker {
+			own = append(own, p.u.a[s.access].n)
+		}
+	}
+	return p.u.i.mixin(a, ts, own).o
+}
+
+// Begin a trait that includes the traits on the stack, in the same way that
+// extend() begins a class.
+func (p *process) trait(n int) {
+	l := len(p.s) - n
+	ts := make([]*Class, n)
+	for j, x := range p.s[l:] {
+		ts[j] = x.ToClass()
+	}
+	e := p.s[l-1].skelData()
+	p.s = p.s[:l-1]
+	c := newTrait(e[0].Name, copySlots(e[1:]), ts)
+	c.p = p.sc
+	p.sc = c
+}
@@ -0,0 +1,176 @@
+package ts_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+const comparable = `trait Comparable require compare; def __lt__(x) = this.compare(x) < 0; def __gt__(x) = this.compare(x) > 0; end; `
+
+const money = `class Money(Comparable) def amount; def create(a) this.amount = a; end; def compare(o) = this.amount - o.amount; end; `
+
+func TestTraits(t *testing.T) {
+	expectAll(t, []scriptTest{
+		{"provided", comparable + money + "[Money(1) < Money(2), Money(1) > Money(2)];", "[true, false]"},
+		{"is", comparable + money + "[Money(5).is(Comparable), Money.inheritsFrom(Comparable), 1.is(Comparable)];", "[true, true, false]"},
+		{"ancestor", comparable + money + "Money.ancestor == Object;", "true"},
+		{"required", "trait R require go; end; class C(R) def go() = 1; end; C().go();", "1"},
+		{"after ancestor", `
+			class Base() def f() = "base"; end;
+			trait T def f() = "T"; def g() = 2; end;
+			class D(Base, T) def f() = "D" + super.f(); end;
+			[D().f(), D().g(), D.ancestor == Base, D().is(Base), D().is(T)];
+		`, "[DT, 2, true, true, true]"},
+		{"class overrides", `
+			trait A def f() = "A"; end;
+			trait B def f() = "B"; end;
+			class C(A, B) def f() = "C" + super.f(); end;
+			C().f();
+		`, "CB"},
+		{"includes", `
+			trait A def f() = "A"; end;
+			trait B(A) def f() = "B"; end;
+			class C(A, B) end;
+			[C().f(), C().is(A), B.inheritsFrom(A)];
+		`, "[B, true, true]"},
+		{"twice", "trait T def f() = 1; end; class C(T, T) end; C().f();", "1"},
+		{"fields", `
+			trait T def n = 0; def bump() this.n = this.n + 1; return this.n; end; end;
+			class C(T) end;
+			def c = C(); c.bump(); c.bump();
+		`, "2"},
+		{"caught", `
+			trait T def f() = throw("no"); end;
+			class C(T) end;
+			catch(fn() C().f(); end).msg;
+		`, "no"},
+		{"caught requirement", `
+			trait T require go; end;
+			catch(fn() class C(T) end; end).msg;
+		`, "C does not define go, required by T"},
+		{"try", "trait A def f() = 1; end; trait B def f() = 2; end;\ndef r;\ntry\n\tclass C(A, B) end;\ncatch e\n\tr = [e.line, e.msg];\nend;\nr;", "[4, traits conflict: A.f and B.f]"},
+	})
+}
+
+func TestTraitErrors(t *testing.T) {
+	expectErrors(t, []scriptTest{
+		{"conflict", `
+			trait A def f() = "A"; end;
+			trait B def f() = "B"; end;
+			class C(A, B) end;
+		`, "traits conflict: A.f and B.f"},
+		{"missing", "trait R require go; end; class C(R) end;", "C does not define go, required by R"},
+		{"instance", "trait T def x = 1; end; T();", "class is abstract: T"},
+		{"private", "trait T private def x = 1; end;", "private member in trait: T.x"},
+		{"super", "trait T def f() = super.f(); end; class C(T) end; C().f();", "only use super with methods you have overridden"},
+		{"shadow trait", "trait T def f() = 1; end; class C(T) def f = 2; end;", "cannot shadow T.f"},
+		{"shadow ancestor", "class B() def f = 2; end; trait T def f() = 1; end; class C(B, T) end;", "cannot shadow B.f"},
+		{"class as trait", "class K() end; class C(Object, K) end;", "not a trait: K"},
+		{"include class", "trait T(Object) end;", "not a trait: Object"},
+		{"wrong type", "trait T(5) end;", "wrong type: Integer"},
+		{"too few", "trait T def f(a) = a; end; class C(T) end; C().f();", "wrong number of arguments 0"},
+		{"too many", "trait T def f(a) = a; end; class C(T) end; C().f(1, 2);", "wrong number of arguments 2"},
+	})
+}
+
+// Go code builds traits with NewTrait and mixes them in with Extend.
+func TestGoTraits(t *testing.T) {
+	i := ts.New()
+	named := ts.NewTrait("Named", []ts.Slot{
+		ts.AbstractMethod("name"),
+		ts.MSlot("greet", func(o *ts.Object, args []*ts.Object) *ts.Object {
+			n := o.Call(i.Accessor("name")).ToString()
+			return ts.Wrap("hello " + n)
+		}),
+	})
+	i.Define("Named", named.Object())
+	person := ts.ObjectClass.Extend(i, "Person", 0, []ts.Slot{
+		ts.MSlot("name", func(o *ts.Object, args []*ts.Object) *ts.Object {
+			return ts.Wrap("bob")
+		}),
+	}, named)
+	i.Define("Person", person.Object())
+	tests := []struct {
+		name, src, want string
+	}{
+		{"provided", "Person().greet();", "hello bob"},
+		{"is", "[Person().is(Named), Person.inheritsFrom(Named)];", "[true, true]"},
+		{"script class", `class Robot(Named) def name() = "r2"; end; Robot().greet();`, "hello r2"},
+		{"caught", "catch(fn() class Rock(Named) end; end).msg;", "Rock does not define name, required by Named"},
+	}
+	for _, test := range tests {
+		x, err := run(t, i, 20*time.Second, test.src, ts.Limits{})
+		expect(t, test.name, x, err, test.want)
+	}
+	if !person.Is(named) {
+		t.Error("Person is not Named")
+	}
+}
+
+type badge struct {
+	Label string
+}
+
+// Bound classes take traits through their options.
+func TestBindTraits(t *testing.T) {
+	i := ts.New()
+	x, err := run(t, i, 20*time.Second, "trait Shout def shout() = this.label + \"!\"; end; Shout;", ts.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ts.BindClass[badge](i, "Badge", ts.BindOptions{Traits: []*ts.Class{x.ToClass()}})
+	i.Define("Badge", c.Object())
+	x, err = run(t, i, 20*time.Second, `Badge({"label": "hi"}).shout();`, ts.Limits{})
+	expect(t, "bound", x, err, "hi!")
+}
+
+func TestGoTraitErrors(t *testing.T) {
+	i := ts.New()
+	m := func(v string) ts.Slot {
+		return ts.MSlot("f", func(o *ts.Object, args []*ts.Object) *ts.Object {
+			return ts.Wrap(v)
+		})
+	}
+	a := ts.NewTrait("A", []ts.Slot{m("a")})
+	b := ts.NewTrait("B", []ts.Slot{m("b")})
+	r := ts.NewTrait("R", []ts.Slot{ts.AbstractMethod("go")})
+	tests := []struct {
+		name string
+		f func()
+		want string
+	}{
+		{"conflict", func() {
+			ts.ObjectClass.Extend(i, "C", 0, nil, a, b)
+		}, "traits conflict: A.f and B.f"},
+		{"missing", func() {
+			ts.ObjectClass.Extend(i, "C", 0, nil, r)
+		}, "C does not define go, required by R"},
+		{"not a trait", func() {
+			ts.ObjectClass.Extend(i, "C", 0, nil, ts.ObjectClass)
+		}, "not a trait: Object"},
+		{"include", func() {
+			ts.NewTrait("T", nil, ts.ObjectClass)
+		}, "not a trait: Object"},
+		{"private", func() {
+			ts.NewTrait("T", []ts.Slot{ts.PSlot("x", 1)})
+		}, "private member in trait: T.x"},
+		{"instance", func() {
+			a.New()
+		}, "class is abstract: A"},
+	}
+	for _, test := range tests {
+		got := func() (msg string) {
+			defer func() {
+				msg = fmt.Sprint(recover())
+			}()
+			test.f()
+			return "no panic"
+		}()
+		if !strings.Contains(got, test.want) {
+			t.Errorf("%s: got %s, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package ts_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"github.com/bobappleyard/ts"
+)
+
+// Put before each script, on the script's first line.
+const notFound = "class NotFound(Error) end; "
+
+func TestTry(t *testing.T) {
+	tests := []scriptTest{
+		{"typed", `
+			def r;
+			try
+				throw(NotFound("x"));
+			catch e: NotFound
+				r = "not found " + e.msg;
+			catch e
+				r = "other";
+			end;
+			r;
+		`, "not found x"},
+		{"untyped", `
+			def r;
+			try
+				throw("plain");
+			catch e: NotFound
+				r = "not found";
+			catch e
+				r = "other " + e.msg;
+			end;
+			r;
+		`, "other plain"},
+		{"wrapped", "def r; try throw(5); catch e r = [e.is(Error), e.msg]; end; r;", "[true, 5]"},
+		{"go error", `def r; try 1 + "a"; catch e r = e.msg; end; r;`, "wrong type: a"},
+		{"arity", "def f(x) = x; def r; try f(1, 2); catch e r = e.msg; end; r;", "wrong number of arguments 2"},
+		{"position", "def r;\ntry\n\t[1].foo();\ncatch e\n\tr = e.line;\nend;\nr;", "3"},
+		{"no error", "def r = 0; try r = 1; catch e r = 2; end; r;", "1"},
+		{"finally", "def r = 0; try r = 1; finally r = r + 10; end; r;", "11"},
+		{"finally after error", `
+			def r = [];
+			try
+				try
+					throw("in");
+				finally
+					r.push("inner");
+				end;
+			catch e
+				r.push(e.msg);
+			end;
+			r;
+		`, "[inner, in]"},
+		{"finally after catch", `
+			def r = [];
+			try
+				try
+					throw("a");
+				catch e
+					throw("b");
+				finally
+					r.push("f");
+				end;
+			catch e
+				r.push(e.msg);
+			end;
+			r;
+		`, "[f, b]"},
+		{"finally after return", `
+			def r = [];
+			def f()
+				try
+					return 1;
+				finally
+					r.push("f");
+				end;
+			end;
+			[f(), r];
+		`, "[1, [f]]"},
+		{"catch function", `
+			def r;
+			try
+				throw(1);
+			catch e
+				r = catch(fn() throw("inner"); end).msg;
+			end;
+			r;
+		`, "inner"},
+	}
+	for i := range tests {
+		tests[i].src = notFound + tests[i].src
+	}
+	expectAll(t, tests)
+}
+
+func TestTryErrors(t *testing.T) {
+	tests := []scriptTest{
+		{"no clauses", "try 1; end;", "expected catch or finally"},
+		{"unmatched", `try throw("plain"); catch e: NotFound end;`, "plain"},
+		{"rethrown", `try throw("a"); catch e throw("b"); end;`, "b"},
+		{"finally throws", `try throw("a"); finally throw("b"); end;`, "b"},
+		{"not a class", "try throw(5); catch e: 3 end;", "wrong type: Integer"},
+	}
+	for i := range tests {
+		tests[i].src = notFound + tests[i].src
+	}
+	expectErrors(t, tests)
+}
+
+// Errors from the host pass catch clauses by, but finally blocks still run.
+func TestTryHostErrors(t *testing.T) {
+	i := ts.New()
+	_, err := run(t, i, 20*time.Second, `
+		def caught = false, ran = false;
+		def f() = [f()];
+		try
+			f();
+		catch e
+			caught = true;
+		finally
+			ran = true;
+		end;
+	`, ts.Limits{Frames: 100})
+	var l *ts.LimitError
+	if !errors.As(err, &l) {
+		t.Errorf("limit: got %v", err)
+	}
+	if i.Get("caught") != ts.False || i.Get("ran") != ts.True {
+		t.Errorf("limit: caught %s, finally ran %s", i.Get("caught"), i.Get("ran"))
+	}
+	_, err = run(t, i, 50*time.Millisecond, `
+		try
+			while true do end;
+		catch e
+			caught = true;
+		end;
+	`, ts.Limits{})
+	if !errors.Is(err, context.DeadlineExceeded) || i.Get("caught") != ts.False {
+		t.Errorf("deadline: got %v, caught %s", err, i.Get("caught"))
+	}
+	s := ts.NewSandboxed(ts.Sandbox{Builtins: []string{"exit"}})
+	_, err = run(t, s, 20*time.Second, `
+		def ran = false;
+		try
+			exit(2);
+		catch e
+			ran = "caught";
+		finally
+			ran = true;
+		end;
+	`, ts.Limits{})
+	var x *ts.ExitError
+	if !errors.As(err, &x) || s.Get("ran") != ts.True {
+		t.Errorf("exit: got %v, ran %s", err, s.Get("ran"))
+	}
+}
@@ -0,0 +1,148 @@
+package ts_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"github.com/bobappleyard/ts"
+	"github.com/bobappleyard/ts/bytecode"
+	"github.com/bobappleyard/ts/parse"
+)
+
+// Save a unit and load it back.
+func reload(t *testing.T, u *ts.Unit) *ts.Unit {
+	var buf bytes.Buffer
+	u.Save(&buf)
+	v := new(ts.Unit)
+	if !v.Load(&buf) {
+		t.Fatal("not a unit")
+	}
+	return v
+}
+
+// Compiled units give the same results after they are saved and loaded.
+func TestUnitRoundTrip(t *testing.T) {
+	var wide strings.Builder
+	wide.WriteString("def r = 0;\nif r == 0 then r = [")
+	for n := 0; n < 70000; n++ {
+		fmt.Fprintf(&wide, "%d, ", n)
+	}
+	wide.WriteString("\"end\"]; else r = 1; end;\n")
+	for n := 0; n < 70000; n++ {
+		fmt.Fprintf(&wide, "def g%d = %d;\n", n, -n)
+	}
+	wide.WriteString("[r[69999], r[70000], r.size, g69999];")
+	tests := []scriptTest{
+		{"values", `[1, 2.5, "three", 12345678901234, 123456789012345678901234567890];`, "[1, 2.5, three, 12345678901234, 123456789012345678901234567890]"},
+		{"functions", "def f(a, b = 2, r*) = [a, b, r]; f(1, 3, 4, 5);", "[1, 3, [4, 5]]"},
+		{"classes", "class A() def x = 1; def y() = this.x + 1; end; class B(A) def y() = super.y() * 10; end; B().y();", "20"},
+		{"traits", "trait T def f() = 1; end; class C(T) end; C().is(T);", "true"},
+		{"generators", "def g() yield 1; yield 2; end; def r = []; for x in g() do r.push(x); end; r;", "[1, 2]"},
+		{"try", "def r = []; try throw(\"x\"); catch e r.push(e.msg); finally r.push(2); end; r;", "[x, 2]"},
+		{"wide", wide.String(), "[69999, end, 70001, -69999]"},
+	}
+	for _, test := range tests {
+		u := new(ts.Unit)
+		if err := u.CompileErr(strings.NewReader(test.src), "test"); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		x, err := ts.New().ExecLimits(context.Background(), reload(t, u), ts.Limits{})
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if x.String() != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, x, test.want)
+		}
+	}
+}
+
+// Errors from loaded units are placed where they were in the source.
+func TestUnitPositions(t *testing.T) {
+	u := new(ts.Unit)
+	u.Compile(strings.NewReader("def f(a) = a;\n\nf();"), "test")
+	_, err := ts.New().ExecErr(reload(t, u))
+	if err == nil || err.Error() != "test(3): wrong number of arguments 0" {
+		t.Errorf("got %v", err)
+	}
+}
+
+// A unit in the original format, with 16 bit words and the source position in
+// the code. It runs throw("boom") in old.ts at line 7.
+func version0() []byte {
+	var buf bytes.Buffer
+	w := func(x interface{}) {
+		binary.Write(&buf, binary.LittleEndian, x)
+	}
+	code := []uint16{
+		bytecode.SOURCE, 4, 7,
+		bytecode.FRAME, 13,
+		bytecode.VALUE, 3,
+		bytecode.PUSH,
+		bytecode.GLOBAL, 0,
+		bytecode.UNBOX,
+		bytecode.CALL, 1,
+	}
+	// magic, version, globals, accessors, blocks, code, strings, ints, floats,
+	// skeletons, skeleton size
+	w([]uint16{0x4200, 0x4353, 0, 1, 0, 1, uint16(len(code)), 2, 0, 0, 0, 0})
+	buf.WriteString("throw\x00")
+	w(code)
+	w([]uint16{uint16(len(code))})
+	w([]uint16{3, 4})
+	buf.WriteString("boom\x00old.ts\x00")
+	return buf.Bytes()
+}
+
+func TestUnitVersion0(t *testing.T) {
+	u := new(ts.Unit)
+	if !u.Load(bytes.NewReader(version0())) {
+		t.Fatal("not a unit")
+	}
+	_, err := ts.New().ExecErr(u)
+	if err == nil || err.Error() != "old.ts(7): boom" {
+		t.Errorf("got %v", err)
+	}
+}
+
+// Units that cannot be saved or loaded fail with an error.
+func TestUnitErrors(t *testing.T) {
+	future := version0()
+	future[4] = 99
+	tests := []struct {
+		name string
+		f func() bool
+		want string
+	}{
+		{"future", func() bool {
+			return new(ts.Unit).Load(bytes.NewReader(future))
+		}, "unsupported unit version: 99"},
+		{"truncated", func() bool {
+			return new(ts.Unit).Load(bytes.NewReader(version0()[:30]))
+		}, "EOF"},
+		{"value", func() bool {
+			n := &parse.Node{Kind: ts.ValNode, Data: ts.Wrap([]int{1})}
+			u := new(ts.Unit)
+			u.CompileNode(n)
+			u.Save(new(bytes.Buffer))
+			return true
+		}, "cannot save value: Array"},
+	}
+	for _, test := range tests {
+		got := func() (msg string) {
+			defer func() {
+				msg = fmt.Sprint(recover())
+			}()
+			test.f()
+			return "no panic"
+		}()
+		if !strings.Contains(got, test.want) {
+			t.Errorf("%s: got %s, want %q", test.name, got, test.want)
+		}
+	}
+	if new(ts.Unit).Load(strings.NewReader("def x;")) {
+		t.Error("loaded source text")
+	}
+}